@@ -0,0 +1,86 @@
+package chatgpt
+
+import (
+	"context"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Limits of the tool-calling loop, used when a Pipeline does not set its own.
+const (
+	DefaultMaxToolRounds   = 4
+	DefaultToolTokenBudget = 60000
+	// finalAnswerReserve is the time kept for writing the answer. Closer to
+	// the deadline the model gets no more tools.
+	finalAnswerReserve = 20 * time.Second
+)
+
+// TraceStep is one completion call of an answer and the tool calls the
+// model made in it. The steps of an answer form its trace, which is kept
+// for admins and not shown to users.
+type TraceStep struct {
+	Attempt          int             `json:"attempt"`
+	Round            int             `json:"round"`
+	Model            string          `json:"model"`
+	DurationMS       int64           `json:"duration_ms"`
+	PromptTokens     int64           `json:"prompt_tokens"`
+	CompletionTokens int64           `json:"completion_tokens"`
+	FinishReason     string          `json:"finish_reason"`
+	ToolsOffered     bool            `json:"tools_offered"`
+	ToolCalls        []TraceToolCall `json:"tool_calls,omitempty"`
+}
+
+// TraceToolCall is a tool call and the size of its result.
+type TraceToolCall struct {
+	Name        string `json:"name"`
+	Arguments   string `json:"arguments"`
+	ResultChars int    `json:"result_chars"`
+	DurationMS  int64  `json:"duration_ms"`
+	Error       string `json:"error,omitempty"`
+}
+
+func traceStep(attempt, round int, model string, toolsOffered bool, result *openai.ChatCompletion, started time.Time) TraceStep {
+	if result.Model != "" {
+		model = result.Model
+	}
+	return TraceStep{
+		Attempt:          attempt,
+		Round:            round,
+		Model:            model,
+		DurationMS:       time.Since(started).Milliseconds(),
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+		FinishReason:     string(result.Choices[0].FinishReason),
+		ToolsOffered:     toolsOffered,
+	}
+}
+
+func (p *Pipeline) maxToolRounds() int {
+	if p.MaxToolRounds > 0 {
+		return p.MaxToolRounds
+	}
+	return DefaultMaxToolRounds
+}
+
+func (p *Pipeline) toolTokenBudget() int64 {
+	if p.ToolTokenBudget > 0 {
+		return p.ToolTokenBudget
+	}
+	return DefaultToolTokenBudget
+}
+
+// moreTools reports whether the model may call tools once more after round
+// rounds that used tokens tokens, or has to answer with what it has.
+func (p *Pipeline) moreTools(ctx context.Context, round int, tokens int64) (bool, string) {
+	if round >= p.maxToolRounds() {
+		return false, "max_rounds"
+	}
+	if tokens >= p.toolTokenBudget() {
+		return false, "token_budget"
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < finalAnswerReserve {
+		return false, "deadline"
+	}
+	return true, ""
+}
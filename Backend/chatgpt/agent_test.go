@@ -0,0 +1,71 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+func analyseWithTrace(t *testing.T, p *chatgpt.Pipeline, prompt string) []chatgpt.TraceStep {
+	t.Helper()
+	result := p.Analyse(context.Background(), prompt, chatgpt.Options{})
+	var a struct {
+		Trace []chatgpt.TraceStep `json:"trace"`
+	}
+	if err := json.Unmarshal([]byte(result), &a); err != nil {
+		t.Fatalf("result is not a chatResponse: %v\n%s", err, result)
+	}
+	return a.Trace
+}
+
+func TestAnalyseSearchesAgainAfterReadingResults(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.SearchCall("call_1", "red vožnje Sarajevo Mostar"),
+		chatgpttest.SearchCall("call_2", "red vožnje Sarajevo Mostar nedjelja"),
+		chatgpttest.Answer("Autobus polazi u 7:00.", "Polazak u 7:00", "Red vožnje"),
+	)
+	p, search, _ := newPipeline(client)
+
+	trace := analyseWithTrace(t, p, `{"received":"Kad ide autobus za Mostar u nedjelju?"}`)
+
+	if got := search.Queries(); len(got) != 2 {
+		t.Errorf("searched for %v, want two searches", got)
+	}
+	if len(trace) != 3 {
+		t.Fatalf("trace has %d steps, want 3: %+v", len(trace), trace)
+	}
+	for i, step := range trace {
+		if step.Round != i+1 {
+			t.Errorf("step %d is round %d", i, step.Round)
+		}
+	}
+	if len(trace[0].ToolCalls) != 1 || len(trace[1].ToolCalls) != 1 || trace[1].ToolCalls[0].Name != "search_google" {
+		t.Errorf("tool calls not traced: %+v", trace)
+	}
+}
+
+func TestAnalyseStopsOfferingToolsAfterMaxRounds(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.SearchCall("call_1", "prvi upit"),
+		chatgpttest.SearchCall("call_2", "drugi upit"),
+		chatgpttest.Answer("Odgovor.", "Odgovor", "Naslov"),
+	)
+	p, search, _ := newPipeline(client)
+	p.MaxToolRounds = 2
+
+	trace := analyseWithTrace(t, p, `{"received":"Pitanje"}`)
+
+	requests := client.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("got %d completion requests, want 3", len(requests))
+	}
+	if !requests[1].Tools.Present || requests[2].Tools.Present {
+		t.Error("tools must be offered until the last round and withheld after it")
+	}
+	if len(search.Queries()) != 2 || len(trace) != 3 || trace[2].ToolsOffered {
+		t.Errorf("unexpected trace %+v", trace)
+	}
+}
@@ -0,0 +1,349 @@
+package chatgpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// DefaultAnthropicModel is used when the Anthropic provider has no model configured.
+const DefaultAnthropicModel = "claude-3-5-haiku-latest"
+
+const (
+	anthropicBaseURL   = "https://api.anthropic.com"
+	anthropicVersion   = "2023-06-01"
+	anthropicMaxTokens = 4096
+)
+
+// AnthropicClient is a CompletionClient for the Anthropic Messages API. It
+// translates OpenAI chat completion requests and responses, so the pipeline
+// does not need to know which provider is active.
+type AnthropicClient struct {
+	baseURL string
+	apikey  string
+	http    *http.Client
+}
+
+// NewAnthropicClient returns a client for the Anthropic API. An empty baseURL
+// means the public API.
+func NewAnthropicClient(baseURL, apikey string) *AnthropicClient {
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	return &AnthropicClient{baseURL: strings.TrimRight(baseURL, "/"), apikey: apikey, http: http.DefaultClient}
+}
+
+// Capabilities reports tool calling and vision. Structured output is asked
+// for in the system prompt, and answers are not streamed.
+func (c *AnthropicClient) Capabilities(ctx context.Context, model string) Capabilities {
+	return Capabilities{Tools: true, Vision: true}
+}
+
+// openAIRequest is the subset of the OpenAI wire format that is translated.
+type openAIRequest struct {
+	Model     string `json:"model"`
+	MaxTokens int    `json:"max_tokens"`
+	Messages  []struct {
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		ToolCallID string          `json:"tool_call_id"`
+		ToolCalls  []struct {
+			ID       string `json:"id"`
+			Function struct {
+				Name      string `json:"name"`
+				Arguments string `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	} `json:"messages"`
+	Tools []struct {
+		Function struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Parameters  json.RawMessage `json:"parameters"`
+		} `json:"function"`
+	} `json:"tools"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Source    *anthropicImage `json:"source,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicImage struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int64 `json:"input_tokens"`
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// translateRequest converts OpenAI chat completion parameters to an
+// Anthropic Messages request.
+func translateRequest(params openai.ChatCompletionNewParams) (anthropicRequest, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return anthropicRequest{}, err
+	}
+	var in openAIRequest
+	if err := json.Unmarshal(data, &in); err != nil {
+		return anthropicRequest{}, err
+	}
+
+	out := anthropicRequest{Model: in.Model, MaxTokens: in.MaxTokens}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = anthropicMaxTokens
+	}
+	for _, tool := range in.Tools {
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+	}
+
+	var system []string
+	for _, m := range in.Messages {
+		var blocks []anthropicBlock
+		role := m.Role
+		switch m.Role {
+		case "system", "developer":
+			text, _, err := contentParts(m.Content)
+			if err != nil {
+				return out, err
+			}
+			system = append(system, text...)
+			continue
+		case "tool":
+			text, _, err := contentParts(m.Content)
+			if err != nil {
+				return out, err
+			}
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: strings.Join(text, "\n")})
+		case "user", "assistant":
+			text, images, err := contentParts(m.Content)
+			if err != nil {
+				return out, err
+			}
+			for _, t := range text {
+				if t != "" {
+					blocks = append(blocks, anthropicBlock{Type: "text", Text: t})
+				}
+			}
+			for _, url := range images {
+				blocks = append(blocks, anthropicBlock{Type: "image", Source: imageSource(url)})
+			}
+			for _, call := range m.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+		default:
+			return out, fmt.Errorf("unsupported message role %q", m.Role)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		// Anthropic wants alternating roles, so merge e.g. several tool results
+		if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == role {
+			out.Messages[n-1].Content = append(out.Messages[n-1].Content, blocks...)
+			continue
+		}
+		out.Messages = append(out.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	out.System = strings.Join(system, "\n\n")
+	return out, nil
+}
+
+// contentParts splits OpenAI message content, which is a string or a list of
+// parts, into texts and image URLs.
+func contentParts(raw json.RawMessage) (texts, images []string, err error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}, nil, nil
+	}
+	var parts []openAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, nil, fmt.Errorf("unsupported message content: %v", err)
+	}
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			texts = append(texts, p.Text)
+		case "image_url":
+			images = append(images, p.ImageURL.URL)
+		}
+	}
+	return texts, images, nil
+}
+
+// imageSource converts a data URL or a plain URL to an Anthropic image source.
+func imageSource(url string) *anthropicImage {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		mediaType, data, _ := strings.Cut(rest, ";base64,")
+		return &anthropicImage{Type: "base64", MediaType: mediaType, Data: data}
+	}
+	return &anthropicImage{Type: "url", URL: url}
+}
+
+// translateResponse converts an Anthropic response to an OpenAI chat completion.
+func translateResponse(resp anthropicResponse) *openai.ChatCompletion {
+	msg := openai.ChatCompletionMessage{Role: openai.ChatCompletionMessageRoleAssistant}
+	var text []string
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			msg.ToolCalls = append(msg.ToolCalls, openai.ChatCompletionMessageToolCall{
+				ID:   block.ID,
+				Type: openai.ChatCompletionMessageToolCallTypeFunction,
+				Function: openai.ChatCompletionMessageToolCallFunction{
+					Name:      block.Name,
+					Arguments: string(block.Input),
+				},
+			})
+		}
+	}
+	msg.Content = strings.Join(text, "")
+
+	finish := openai.ChatCompletionChoicesFinishReasonStop
+	switch resp.StopReason {
+	case "tool_use":
+		finish = openai.ChatCompletionChoicesFinishReasonToolCalls
+	case "max_tokens":
+		finish = openai.ChatCompletionChoicesFinishReasonLength
+	case "refusal":
+		finish = openai.ChatCompletionChoicesFinishReasonContentFilter
+	}
+	return &openai.ChatCompletion{
+		ID:      resp.ID,
+		Model:   resp.Model,
+		Object:  openai.ChatCompletionObjectChatCompletion,
+		Choices: []openai.ChatCompletionChoice{{Message: msg, FinishReason: finish}},
+		Usage: openai.CompletionUsage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		},
+	}
+}
+
+func (c *AnthropicClient) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	body, err := translateRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/messages", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", c.apikey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out anthropicResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, &StatusError{Provider: ProviderAnthropic, StatusCode: resp.StatusCode, Message: string(raw)}
+		}
+		return nil, fmt.Errorf("anthropic: invalid response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || out.Error != nil {
+		message := string(raw)
+		if out.Error != nil {
+			message = out.Error.Type + ": " + out.Error.Message
+		}
+		return nil, &StatusError{
+			Provider:   ProviderAnthropic,
+			StatusCode: resp.StatusCode,
+			Message:    message,
+			RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+		}
+	}
+	return translateResponse(out), nil
+}
+
+// CompleteStreaming is not supported; the whole answer is delivered as a single delta.
+func (c *AnthropicClient) CompleteStreaming(ctx context.Context, params openai.ChatCompletionNewParams, onDelta func(string)) (*openai.ChatCompletion, error) {
+	result, err := c.Complete(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	onDelta(result.Choices[0].Message.Content)
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+func TestAnthropicClientTranslatesToolCalls(t *testing.T) {
+	var got anthropicRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("X-Api-Key") != "key" || r.Header.Get("Anthropic-Version") == "" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(`{"id":"msg_1","model":"claude","stop_reason":"tool_use",
+			"content":[{"type":"text","text":"Tražim."},{"type":"tool_use","id":"tu_2","name":"search_google","input":{"query":"ljekarne"}}],
+			"usage":{"input_tokens":30,"output_tokens":12}}`))
+	}))
+	defer srv.Close()
+
+	// An earlier tool round trip, as the pipeline sends it on the second call
+	earlier := openai.ChatCompletionMessage{
+		Role: openai.ChatCompletionMessageRoleAssistant,
+		ToolCalls: []openai.ChatCompletionMessageToolCall{{
+			ID:       "tu_1",
+			Type:     openai.ChatCompletionMessageToolCallTypeFunction,
+			Function: openai.ChatCompletionMessageToolCallFunction{Name: "search_google", Arguments: `{"query":"apoteke"}`},
+		}},
+	}
+	params := openai.ChatCompletionNewParams{
+		Model: openai.F("claude"),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage("Budi kratak."),
+			openai.UserMessageParts(openai.TextPart("Pitanje"), openai.ImagePart("data:image/jpeg;base64,AAAA")),
+			earlier,
+			openai.ToolMessage("tu_1", "rezultati pretrage"),
+		}),
+		Tools: openai.F([]openai.ChatCompletionToolParam{{
+			Type: openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(openai.FunctionDefinitionParam{
+				Name:       openai.String("search_google"),
+				Parameters: openai.F(openai.FunctionParameters{"type": "object"}),
+			}),
+		}}),
+	}
+
+	result, err := NewAnthropicClient(srv.URL, "key").Complete(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.System != "Budi kratak." || got.MaxTokens != anthropicMaxTokens || len(got.Tools) != 1 {
+		t.Errorf("unexpected request %+v", got)
+	}
+	if len(got.Messages) != 3 {
+		t.Fatalf("got %d messages, want user, assistant and tool result", len(got.Messages))
+	}
+	if img := got.Messages[0].Content[1]; img.Type != "image" || img.Source.MediaType != "image/jpeg" || img.Source.Data != "AAAA" {
+		t.Errorf("image block = %+v", img)
+	}
+	if use := got.Messages[1].Content[0]; use.Type != "tool_use" || use.ID != "tu_1" || string(use.Input) != `{"query":"apoteke"}` {
+		t.Errorf("tool_use block = %+v", use)
+	}
+	if res := got.Messages[2]; res.Role != "user" || res.Content[0].ToolUseID != "tu_1" || res.Content[0].Content != "rezultati pretrage" {
+		t.Errorf("tool result = %+v", res)
+	}
+
+	msg := result.Choices[0].Message
+	if msg.Content != "Tražim." || len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Arguments != `{"query":"ljekarne"}` {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if result.Choices[0].FinishReason != openai.ChatCompletionChoicesFinishReasonToolCalls || result.Usage.TotalTokens != 42 {
+		t.Errorf("finish reason %s, usage %+v", result.Choices[0].FinishReason, result.Usage)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	if _, _, err := NewProvider(ProviderConfig{Name: "watson"}, "key"); err == nil {
+		t.Error("unknown provider accepted")
+	}
+	if _, _, err := NewProvider(ProviderConfig{Name: ProviderOpenAICompatible, Model: "llama3.1"}, ""); err == nil {
+		t.Error("OpenAI-compatible provider without base URL accepted")
+	}
+	client, model, err := NewProvider(ProviderConfig{Name: ProviderOpenAICompatible, BaseURL: "http://127.0.0.1:1/v1", Model: "llama3.1", Capabilities: "tools,json_mode"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps := capabilitiesOf(context.Background(), client, model)
+	if model != "llama3.1" || caps != (Capabilities{Tools: true, JSONMode: true}) {
+		t.Errorf("model %q, capabilities %+v", model, caps)
+	}
+	if _, model, _ := NewProvider(ProviderConfig{Name: ProviderAnthropic}, "key"); model != DefaultAnthropicModel {
+		t.Errorf("anthropic default model = %q", model)
+	}
+}
+
+func TestCompatibleCapabilitiesRetryUntilDetected(t *testing.T) {
+	var ready atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/show" || !ready.Load() {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"capabilities":["completion","vision"]}`))
+	}))
+	defer srv.Close()
+	client := NewCompatibleClient(srv.URL+"/v1", "")
+
+	if caps := client.Capabilities(context.Background(), "llava"); caps != compatibleDefaults {
+		t.Errorf("while starting: %+v, want the defaults", caps)
+	}
+	ready.Store(true)
+	want := Capabilities{JSONMode: true, Streaming: true, Vision: true}
+	if caps := client.Capabilities(context.Background(), "llava"); caps != want {
+		t.Errorf("once started: %+v, want %+v", caps, want)
+	}
+	ready.Store(false)
+	if caps := client.Capabilities(context.Background(), "llava"); caps != want {
+		t.Errorf("detected capabilities were not cached: %+v", caps)
+	}
+}
@@ -0,0 +1,391 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EURPeg is the fixed rate of the convertible mark to the euro.
+const EURPeg = 1.95583
+
+// RatesProvider gives exchange rates as convertible marks (KM) per unit of
+// a currency. EUR and BAM are converted at the peg and not asked for.
+type RatesProvider interface {
+	Rate(ctx context.Context, currency string) (float64, error)
+}
+
+// StaticRates are fixed exchange rates in KM, keyed by ISO currency code.
+type StaticRates map[string]float64
+
+func (r StaticRates) Rate(ctx context.Context, currency string) (float64, error) {
+	if rate, ok := r[currency]; ok && rate > 0 {
+		return rate, nil
+	}
+	return 0, fmt.Errorf("no exchange rate for %s", currency)
+}
+
+// Rates provides the exchange rates of the calculate tool. main loads them
+// from the RATES_FILE file; without it only KM and EUR are known.
+var Rates RatesProvider = StaticRates{}
+
+// LoadRates sets Rates from a JSON file of the form {"USD": 1.79}, giving
+// the value of one unit of each currency in KM.
+func LoadRates(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rates StaticRates
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return err
+	}
+	Rates = rates
+	return nil
+}
+
+// currencyCode returns the ISO code of a currency as written by users.
+func currencyCode(s string) string {
+	switch c := strings.ToUpper(strings.TrimSpace(s)); c {
+	case "KM", "BAM", "MARKA", "MARAKA":
+		return "BAM"
+	case "€", "EURO", "EURA":
+		return "EUR"
+	case "$":
+		return "USD"
+	default:
+		return c
+	}
+}
+
+// kmPer returns the value of one unit of a currency in KM.
+func kmPer(ctx context.Context, currency string) (float64, error) {
+	switch currency {
+	case "BAM":
+		return 1, nil
+	case "EUR":
+		return EURPeg, nil
+	}
+	return Rates.Rate(ctx, currency)
+}
+
+type unit struct {
+	dimension string
+	factor    float64
+}
+
+// units are converted through the unit with factor 1 of their dimension.
+// Names are case sensitive, so that km is a kilometre and KM a currency.
+var units = map[string]unit{
+	"mm": {"length", 0.001}, "cm": {"length", 0.01}, "m": {"length", 1}, "km": {"length", 1000},
+	"mg": {"mass", 0.001}, "g": {"mass", 1}, "dag": {"mass", 10}, "kg": {"mass", 1000}, "t": {"mass", 1e6},
+	"ml": {"volume", 0.001}, "dl": {"volume", 0.1}, "l": {"volume", 1},
+	"m2": {"area", 1}, "ar": {"area", 100}, "dunum": {"area", 1000}, "ha": {"area", 10000}, "km2": {"area", 1e6},
+	"min": {"time", 60}, "h": {"time", 3600}, "dan": {"time", 86400},
+}
+
+// convert converts value between units or currencies and describes the
+// rate used.
+func convert(ctx context.Context, value float64, from, to string) (float64, string, error) {
+	if uf, ok := units[from]; ok {
+		ut, ok := units[to]
+		if !ok || ut.dimension != uf.dimension {
+			return 0, "", fmt.Errorf("cannot convert %s to %s", from, to)
+		}
+		return value * uf.factor / ut.factor, "", nil
+	}
+	var notes []string
+	rates := [2]float64{}
+	for i, c := range []string{currencyCode(from), currencyCode(to)} {
+		rate, err := kmPer(ctx, c)
+		if err != nil {
+			return 0, "", err
+		}
+		rates[i] = rate
+		switch c {
+		case "BAM":
+		case "EUR":
+			notes = append(notes, fmt.Sprintf("1 EUR = %s KM, fiksni kurs", FormatNumber(rate, -1)))
+		default:
+			notes = append(notes, fmt.Sprintf("1 %s = %s KM", c, FormatNumber(rate, -1)))
+		}
+	}
+	return value * rates[0] / rates[1], strings.Join(notes, "; "), nil
+}
+
+// currencyName returns how a currency or unit is written in results.
+func currencyName(s string) string {
+	if !isCurrency(s) {
+		return s
+	}
+	if c := currencyCode(s); c != "BAM" {
+		return c
+	}
+	return "KM"
+}
+
+func isCurrency(s string) bool {
+	_, ok := units[s]
+	return !ok
+}
+
+// FormatNumber formats a number the Bosnian way, with dots between
+// thousands and a decimal comma, e.g. 1.234,56. A negative decimals keeps
+// up to six decimals without trailing zeros.
+func FormatNumber(v float64, decimals int) string {
+	s := ""
+	if decimals < 0 {
+		s = strconv.FormatFloat(math.Abs(v), 'f', 6, 64)
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	} else {
+		s = strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteByte(',')
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// maxExpressionLength bounds the expressions the calculator evaluates.
+const maxExpressionLength = 200
+
+var errDivisionByZero = errors.New("division by zero")
+
+// Evaluate evaluates an arithmetic expression with + - * / ^, parentheses
+// and percentages such as 20%. A percentage added or subtracted is one of
+// the left operand, so 100 + 20% is 120. Numbers use a decimal point, or a
+// decimal comma with dots between thousands.
+func Evaluate(expression string) (float64, error) {
+	if len(expression) > maxExpressionLength {
+		return 0, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+	e := &evaluator{s: expression}
+	v, err := e.sum(0)
+	if err != nil {
+		return 0, err
+	}
+	if e.skip(); e.pos < len(e.s) {
+		return 0, fmt.Errorf("unexpected %q in expression", e.s[e.pos:])
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errors.New("result is not a number")
+	}
+	return v, nil
+}
+
+// evaluator is a recursive descent parser that evaluates as it parses.
+type evaluator struct {
+	s   string
+	pos int
+	// percent reports whether the last operand parsed ended in %.
+	percent bool
+}
+
+const maxExpressionDepth = 20
+
+func (e *evaluator) skip() {
+	for e.pos < len(e.s) && e.s[e.pos] == ' ' {
+		e.pos++
+	}
+}
+
+// next returns the next operator, treating × and ÷ as * and /.
+func (e *evaluator) next() string {
+	e.skip()
+	for _, op := range []string{"×", "÷", "+", "-", "*", "/", "^", "%", "(", ")"} {
+		if strings.HasPrefix(e.s[e.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (e *evaluator) sum(depth int) (float64, error) {
+	v, err := e.product(depth)
+	for err == nil {
+		op := e.next()
+		if op != "+" && op != "-" {
+			break
+		}
+		e.pos += len(op)
+		var w float64
+		if w, err = e.product(depth); e.percent {
+			w *= v
+		}
+		if op == "+" {
+			v += w
+		} else {
+			v -= w
+		}
+	}
+	return v, err
+}
+
+func (e *evaluator) product(depth int) (float64, error) {
+	v, err := e.unary(depth)
+	for err == nil {
+		op := e.next()
+		if op != "*" && op != "/" && op != "×" && op != "÷" {
+			break
+		}
+		e.pos += len(op)
+		var w float64
+		if w, err = e.unary(depth); err != nil {
+			break
+		}
+		if op == "*" || op == "×" {
+			v *= w
+		} else if w == 0 {
+			return 0, errDivisionByZero
+		} else {
+			v /= w
+		}
+	}
+	return v, err
+}
+
+// unary handles signs, which bind more loosely than ^ so -2^2 is -4.
+func (e *evaluator) unary(depth int) (float64, error) {
+	switch e.next() {
+	case "-":
+		e.pos++
+		v, err := e.unary(depth)
+		return -v, err
+	case "+":
+		e.pos++
+		return e.unary(depth)
+	}
+	return e.power(depth)
+}
+
+func (e *evaluator) power(depth int) (float64, error) {
+	v, err := e.percentage(depth)
+	if err != nil || e.next() != "^" {
+		return v, err
+	}
+	e.pos++
+	w, err := e.unary(depth)
+	return math.Pow(v, w), err
+}
+
+func (e *evaluator) percentage(depth int) (float64, error) {
+	v, err := e.primary(depth)
+	e.percent = err == nil && e.next() == "%"
+	if e.percent {
+		e.pos++
+		v /= 100
+	}
+	return v, err
+}
+
+func (e *evaluator) primary(depth int) (float64, error) {
+	if depth > maxExpressionDepth {
+		return 0, errors.New("expression is nested too deeply")
+	}
+	if e.next() == "(" {
+		e.pos++
+		v, err := e.sum(depth + 1)
+		if err != nil {
+			return 0, err
+		}
+		if e.next() != ")" {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		e.pos++
+		return v, nil
+	}
+	start := e.pos
+	for e.pos < len(e.s) && (unicode.IsDigit(rune(e.s[e.pos])) || e.s[e.pos] == '.' || e.s[e.pos] == ',') {
+		e.pos++
+	}
+	if start == e.pos {
+		if e.pos == len(e.s) {
+			return 0, errors.New("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q in expression", e.s[e.pos:])
+	}
+	number := e.s[start:e.pos]
+	// A dot is a decimal point unless the number also has a decimal comma,
+	// as in 1.234,50, where the dots separate thousands
+	if whole, fraction, ok := strings.Cut(number, ","); ok {
+		if strings.ContainsAny(fraction, ".,") || !thousands.MatchString(whole) {
+			return 0, fmt.Errorf("invalid number %q", number)
+		}
+		number = strings.ReplaceAll(whole, ".", "") + "." + fraction
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", e.s[start:e.pos])
+	}
+	return v, nil
+}
+
+// thousands matches the whole part of a number with a decimal comma: plain
+// digits, or dots between groups of three digits.
+var thousands = regexp.MustCompile(`^(\d+|\d{1,3}(\.\d{3})+)$`)
+
+type calculateArgs struct {
+	Expression string `json:"expression" jsonschema_description:"Arithmetic with + - * / ^ ( ) and %, using a decimal point and no thousands separators, e.g. (450.50 + 120) * 12"`
+	From       string `json:"from,omitempty" jsonschema_description:"Currency (KM, EUR, USD...) or unit (kg, l, km, m2, dunum...) of the expression, to convert the result"`
+	To         string `json:"to,omitempty" jsonschema_description:"Currency or unit to convert the result to"`
+}
+
+var calculateTool = FuncTool[calculateArgs]{
+	ToolName:        "calculate",
+	ToolDescription: "Calculate exactly and convert currencies and units. KM and EUR use the fixed rate 1 EUR = 1,95583 KM",
+	Run: func(ctx context.Context, env ToolEnv, args calculateArgs) (string, error) {
+		v, err := Evaluate(args.Expression)
+		if err != nil {
+			return "", err
+		}
+		decimals := -1
+		if args.From != "" && isCurrency(args.From) {
+			decimals = 2
+		}
+		result := fmt.Sprintf("%s = %s", args.Expression, FormatNumber(v, decimals))
+		if args.From != "" {
+			result += " " + currencyName(args.From)
+		}
+		if args.To != "" {
+			if args.From == "" {
+				return "", errors.New("'from' is needed to convert to " + args.To)
+			}
+			converted, note, err := convert(ctx, v, args.From, args.To)
+			if err != nil {
+				return "", err
+			}
+			decimals = -1
+			if isCurrency(args.To) {
+				decimals = 2
+			}
+			result += fmt.Sprintf(" = %s %s", FormatNumber(converted, decimals), currencyName(args.To))
+			if note != "" {
+				result += " (" + note + ")"
+			}
+		}
+		env.Logger.Info("Calculate tool used",
+			"expression", args.Expression,
+			"from", args.From,
+			"to", args.To)
+		return result, nil
+	},
+}
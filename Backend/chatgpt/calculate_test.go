@@ -0,0 +1,98 @@
+package chatgpt_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+	}{
+		{"(450.50 + 120) * 12", 6846},
+		{"2 + 3 * 4", 14},
+		{"-2^2", -4},
+		{"2^3^2", 512},
+		{"500 * 20%", 100},
+		{"100 + 20%", 120},
+		{"250 - 10%", 225},
+		{"100 + 20% * 2", 100.4},
+		{"20% + 5", 5.2},
+		{"(100 + 20%) * 2", 240},
+		{"1.234,50 + 0,5", 1235},
+		{"1.500.000,00 / 1.000,0", 1500},
+		{"1.125 * 8", 9},
+		{"3.141", 3.141},
+		{"0.125 * 8", 1},
+		{"2.5 * 1.25", 3.125},
+		{"10 ÷ 4 × 2", 5},
+	}
+	for _, tt := range tests {
+		got, err := chatgpt.Evaluate(tt.expression)
+		if err != nil || got != tt.want {
+			t.Errorf("Evaluate(%q) = %v, %v, want %v", tt.expression, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"1.500.000", "1,234.50", "2,5.5", "1,2,3", "12.34,5", "1.2.3,4", "1 / 0", "2 +", "(1 + 2", "os.Exit(1)", strings.Repeat("(", 30) + "1" + strings.Repeat(")", 30)} {
+		if _, err := chatgpt.Evaluate(bad); err == nil {
+			t.Errorf("Evaluate(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		v        float64
+		decimals int
+		want     string
+	}{
+		{1234567.891, 2, "1.234.567,89"},
+		{0.1 + 0.2, -1, "0,3"},
+		{-1500, -1, "-1.500"},
+		{999, 2, "999,00"},
+	}
+	for _, tt := range tests {
+		if got := chatgpt.FormatNumber(tt.v, tt.decimals); got != tt.want {
+			t.Errorf("FormatNumber(%v, %d) = %q, want %q", tt.v, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateTool(t *testing.T) {
+	defer func(rates chatgpt.RatesProvider) { chatgpt.Rates = rates }(chatgpt.Rates)
+	chatgpt.Rates = chatgpt.StaticRates{"USD": 1.80}
+
+	tool, ok := chatgpt.Tools.Get("calculate")
+	if !ok {
+		t.Fatal("calculate tool is not registered")
+	}
+	env := chatgpt.ToolEnv{Logger: slog.Default()}
+
+	tests := []struct {
+		arguments string
+		want      string
+	}{
+		{`{"expression":"850 * 12","from":"KM"}`, "850 * 12 = 10.200,00 KM"},
+		{`{"expression":"100","from":"EUR","to":"KM"}`, "= 195,58 KM (1 EUR = 1,95583 KM, fiksni kurs)"},
+		{`{"expression":"1000","from":"KM","to":"€"}`, "= 511,29 EUR"},
+		{`{"expression":"90","from":"USD","to":"KM"}`, "= 162,00 KM (1 USD = 1,8 KM)"},
+		{`{"expression":"2.5","from":"dunum","to":"m2"}`, "2.5 = 2,5 dunum = 2.500 m2"},
+	}
+	for _, tt := range tests {
+		got, err := tool.Execute(context.Background(), env, tt.arguments)
+		if err != nil || !strings.Contains(got, tt.want) {
+			t.Errorf("%s = %q, %v, want %q", tt.arguments, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{`{"expression":"10","from":"CHF","to":"KM"}`, `{"expression":"10","from":"kg","to":"l"}`, `{"expression":"10","to":"KM"}`} {
+		if _, err := tool.Execute(context.Background(), env, bad); err == nil {
+			t.Errorf("%s succeeded", bad)
+		}
+	}
+}
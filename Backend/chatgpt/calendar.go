@@ -0,0 +1,299 @@
+package chatgpt
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HolidayRule describes a holiday in Bosnia and Herzegovina. Exactly one
+// of Date, Easter or Dates is set.
+type HolidayRule struct {
+	Name string `json:"name"`
+	// Scope is BiH for the whole country or FBiH, RS or BD for an entity
+	// or the Brčko District.
+	Scope    string `json:"scope"`
+	Religion string `json:"religion,omitempty"`
+	// Date is the day of a fixed holiday as MM-DD.
+	Date string `json:"date,omitempty"`
+	// Easter is catholic or orthodox for holidays that move with Easter;
+	// Offset is the number of days from Easter Sunday.
+	Easter string `json:"easter,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	// Dates lists the days of holidays that follow the lunar calendar as
+	// YYYY-MM-DD.
+	Dates []string `json:"dates,omitempty"`
+}
+
+// Holiday is a holiday on a given day.
+type Holiday struct {
+	Date     time.Time
+	Name     string
+	Scope    string
+	Religion string
+}
+
+//go:embed holidays.json
+var defaultHolidays []byte
+
+// HolidayRules are the holidays known to the calendar tool. main replaces
+// them from the HOLIDAYS_FILE file.
+var HolidayRules = mustParseHolidays(defaultHolidays)
+
+func mustParseHolidays(data []byte) []HolidayRule {
+	rules, err := parseHolidays(data)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+func parseHolidays(data []byte) ([]HolidayRule, error) {
+	var rules []HolidayRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i, r := range rules {
+		kinds := 0
+		if r.Date != "" {
+			if _, err := time.Parse("01-02", r.Date); err != nil {
+				return nil, fmt.Errorf("holiday %d (%s): invalid date %q", i+1, r.Name, r.Date)
+			}
+			kinds++
+		}
+		if r.Easter != "" {
+			if r.Easter != "catholic" && r.Easter != "orthodox" {
+				return nil, fmt.Errorf("holiday %d (%s): easter must be catholic or orthodox", i+1, r.Name)
+			}
+			kinds++
+		}
+		if len(r.Dates) > 0 {
+			for _, d := range r.Dates {
+				if _, err := time.Parse("2006-01-02", d); err != nil {
+					return nil, fmt.Errorf("holiday %d (%s): invalid date %q", i+1, r.Name, d)
+				}
+			}
+			kinds++
+		}
+		if r.Name == "" || kinds != 1 {
+			return nil, fmt.Errorf("holiday %d: needs a name and one of date, easter or dates", i+1)
+		}
+	}
+	return rules, nil
+}
+
+// LoadHolidays replaces HolidayRules with the rules in a JSON file in the
+// format of holidays.json.
+func LoadHolidays(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules, err := parseHolidays(data)
+	if err != nil {
+		return err
+	}
+	HolidayRules = rules
+	return nil
+}
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// CatholicEaster returns the date of Easter in the Gregorian calendar.
+func CatholicEaster(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	return day(year, time.Month(month), (h+l-7*m+114)%31+1)
+}
+
+// OrthodoxEaster returns the date of Orthodox Easter, computed in the
+// Julian calendar and given as a Gregorian date.
+func OrthodoxEaster(year int) time.Time {
+	a, b, c := year%4, year%7, year%19
+	d := (19*c + 15) % 30
+	e := (2*a + 4*b - d + 34) % 7
+	month := (d + e + 114) / 31
+	julian := day(year, time.Month(month), (d+e+114)%31+1)
+	return julian.AddDate(0, 0, year/100-year/400-2)
+}
+
+// HolidaysIn returns the holidays of a year, sorted by date.
+func HolidaysIn(year int) []Holiday {
+	var holidays []Holiday
+	for _, r := range HolidayRules {
+		h := Holiday{Name: r.Name, Scope: r.Scope, Religion: r.Religion}
+		switch {
+		case r.Date != "":
+			md, _ := time.Parse("01-02", r.Date)
+			h.Date = day(year, md.Month(), md.Day())
+		case r.Easter == "catholic":
+			h.Date = CatholicEaster(year).AddDate(0, 0, r.Offset)
+		case r.Easter == "orthodox":
+			h.Date = OrthodoxEaster(year).AddDate(0, 0, r.Offset)
+		default:
+			for _, d := range r.Dates {
+				if t, _ := time.Parse("2006-01-02", d); t.Year() == year {
+					h.Date = t
+				}
+			}
+			if h.Date.IsZero() {
+				continue
+			}
+		}
+		holidays = append(holidays, h)
+	}
+	sort.SliceStable(holidays, func(i, j int) bool { return holidays[i].Date.Before(holidays[j].Date) })
+	return holidays
+}
+
+// HolidaysOn returns the holidays on a day.
+func HolidaysOn(date time.Time) []Holiday {
+	var holidays []Holiday
+	for _, h := range HolidaysIn(date.Year()) {
+		if h.Date.Equal(date) {
+			holidays = append(holidays, h)
+		}
+	}
+	return holidays
+}
+
+var weekdays = [...]string{"nedjelja", "ponedjeljak", "utorak", "srijeda", "četvrtak", "petak", "subota"}
+
+var scopes = map[string]string{
+	"BiH":  "BiH",
+	"FBiH": "Federacija BiH",
+	"RS":   "Republika Srpska",
+	"BD":   "Brčko distrikt",
+}
+
+func formatDay(t time.Time) string {
+	return fmt.Sprintf("%s (%s)", t.Format("02.01.2006."), weekdays[t.Weekday()])
+}
+
+func (h Holiday) String() string {
+	where := scopes[h.Scope]
+	if where == "" {
+		where = h.Scope
+	}
+	if h.Religion != "" {
+		where = "vjerski, " + h.Religion + ", " + where
+	}
+	return fmt.Sprintf("%s %s (%s)", formatDay(h.Date), h.Name, where)
+}
+
+var sarajevo = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Sarajevo")
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}()
+
+// parseDay parses a date as written by users or the model. An empty
+// string is today in Sarajevo.
+func parseDay(s string) (time.Time, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), ".")
+	if s == "" {
+		now := time.Now().In(sarajevo)
+		return day(now.Year(), now.Month(), now.Day()), nil
+	}
+	for _, layout := range []string{"2.1.2006", "2. 1. 2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, use DD.MM.YYYY.", s)
+}
+
+type calendarArgs struct {
+	Operation string `json:"operation" jsonschema:"enum=date,enum=add,enum=between,enum=holidays" jsonschema_description:"date: weekday and holidays of a day; add: the day a number of days later; between: days from date until another day; holidays: all holidays of a year with Catholic and Orthodox Easter"`
+	Date      string `json:"date,omitempty" jsonschema_description:"Day as DD.MM.YYYY., empty for today"`
+	Until     string `json:"until,omitempty" jsonschema_description:"End day for between, as DD.MM.YYYY."`
+	Days      int    `json:"days,omitempty" jsonschema_description:"Number of days for add, negative to go back"`
+	Year      int    `json:"year,omitempty" jsonschema_description:"Year for holidays, empty for the year of date"`
+}
+
+var calendarTool = FuncTool[calendarArgs]{
+	ToolName:        "calendar",
+	ToolDescription: "Weekdays, date arithmetic and public and religious holidays in Bosnia and Herzegovina, including Catholic and Orthodox Easter",
+	Run: func(ctx context.Context, env ToolEnv, args calendarArgs) (string, error) {
+		date, err := parseDay(args.Date)
+		if err != nil {
+			return "", err
+		}
+		var out strings.Builder
+		switch args.Operation {
+		case "", "date":
+			fmt.Fprintf(&out, "%s\n", formatDay(date))
+			writeHolidaysOn(&out, date)
+		case "add":
+			result := date.AddDate(0, 0, args.Days)
+			fmt.Fprintf(&out, "%s %+d dana = %s\n", date.Format("02.01.2006."), args.Days, formatDay(result))
+			writeHolidaysOn(&out, result)
+		case "between":
+			until, err := parseDay(args.Until)
+			if err != nil {
+				return "", err
+			}
+			days := int(until.Sub(date).Hours() / 24)
+			fmt.Fprintf(&out, "Od %s do %s: %d dana\n", formatDay(date), formatDay(until), days)
+		case "holidays":
+			year := args.Year
+			if year == 0 {
+				year = date.Year()
+			}
+			fmt.Fprintf(&out, "Praznici u %d. godini:\n", year)
+			for _, h := range HolidaysIn(year) {
+				fmt.Fprintf(&out, "%s\n", h)
+			}
+			for _, r := range HolidayRules {
+				if len(r.Dates) > 0 && !hasYear(r.Dates, year) {
+					fmt.Fprintf(&out, "%s: datum za %d. godinu nije poznat\n", r.Name, year)
+				}
+			}
+			fmt.Fprintf(&out, "Katolički Uskrs: %s, pravoslavni Vaskrs: %s\n",
+				formatDay(CatholicEaster(year)), formatDay(OrthodoxEaster(year)))
+		default:
+			return "", fmt.Errorf("unknown operation %q", args.Operation)
+		}
+		env.Logger.Info("Calendar tool used",
+			"operation", args.Operation,
+			"date", date.Format("2006-01-02"))
+		return out.String(), nil
+	},
+}
+
+func writeHolidaysOn(out *strings.Builder, date time.Time) {
+	holidays := HolidaysOn(date)
+	if len(holidays) == 0 {
+		out.WriteString("Nije praznik.\n")
+	}
+	for _, h := range holidays {
+		fmt.Fprintf(out, "Praznik: %s\n", h)
+	}
+}
+
+func hasYear(dates []string, year int) bool {
+	prefix := fmt.Sprintf("%d-", year)
+	for _, d := range dates {
+		if strings.HasPrefix(d, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package chatgpt_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+)
+
+func TestEaster(t *testing.T) {
+	tests := []struct {
+		year               int
+		catholic, orthodox string
+	}{
+		{2024, "2024-03-31", "2024-05-05"},
+		{2025, "2025-04-20", "2025-04-20"},
+		{2026, "2026-04-05", "2026-04-12"},
+		{2027, "2027-03-28", "2027-05-02"},
+	}
+	for _, tt := range tests {
+		if got := chatgpt.CatholicEaster(tt.year).Format("2006-01-02"); got != tt.catholic {
+			t.Errorf("CatholicEaster(%d) = %s, want %s", tt.year, got, tt.catholic)
+		}
+		if got := chatgpt.OrthodoxEaster(tt.year).Format("2006-01-02"); got != tt.orthodox {
+			t.Errorf("OrthodoxEaster(%d) = %s, want %s", tt.year, got, tt.orthodox)
+		}
+	}
+}
+
+func TestCalendarTool(t *testing.T) {
+	tool, ok := chatgpt.Tools.Get("calendar")
+	if !ok {
+		t.Fatal("calendar tool is not registered")
+	}
+	env := chatgpt.ToolEnv{Logger: slog.Default()}
+
+	tests := []struct {
+		arguments string
+		want      []string
+	}{
+		{`{"operation":"date","date":"25.11.2026."}`, []string{"25.11.2026. (srijeda)", "Dan državnosti (Federacija BiH)"}},
+		{`{"operation":"date","date":"2026-10-20"}`, []string{"(utorak)", "Nije praznik."}},
+		{`{"operation":"add","date":"30.12.2026.","days":3}`, []string{"= 02.01.2027. (subota)", "Nova godina (drugi dan)"}},
+		{`{"operation":"between","date":"19.10.2026.","until":"25.12.2026."}`, []string{": 67 dana"}},
+		{`{"operation":"holidays","year":2026}`, []string{"12.04.2026. (nedjelja) Pravoslavni Vaskrs", "Ramazanski bajram", "Katolički Uskrs: 05.04.2026."}},
+		{`{"operation":"holidays","year":2035}`, []string{"Kurban bajram: datum za 2035. godinu nije poznat"}},
+	}
+	for _, tt := range tests {
+		got, err := tool.Execute(context.Background(), env, tt.arguments)
+		if err != nil {
+			t.Errorf("%s: %v", tt.arguments, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: result does not contain %q:\n%s", tt.arguments, want, got)
+			}
+		}
+	}
+
+	if _, err := tool.Execute(context.Background(), env, `{"operation":"date","date":"sutra"}`); err == nil {
+		t.Error("invalid date accepted")
+	}
+}
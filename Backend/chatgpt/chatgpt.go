@@ -9,16 +9,23 @@ import (
 
 	"log/slog"
 
-	"code.com/webpagescraper"
 	"github.com/invopop/jsonschema"
 	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/shared"
 )
 
+// LogFile is the file the package writes its structured logs to.
+var LogFile = "/app/logs/logfile.log"
+
+// LogHandler, when set, receives the package's logs instead of LogFile.
+var LogHandler slog.Handler
+
 // initializeLogger sets up the logger for the application.
 func initializeLogger() (*slog.Logger, error) {
-	file, err := os.OpenFile("/app/logs/logfile.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if LogHandler != nil {
+		return slog.New(LogHandler), nil
+	}
+	file, err := os.OpenFile(LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		slog.Default().Error("Error opening log file", "error", err)
 		return nil, err
@@ -41,6 +48,16 @@ type chatResponseContent struct {
 type chatResponse struct {
 	Content        chatResponseContent `json:"content"`
 	InternetSearch bool                `json:"internet_search"`
+	Moderated      bool                `json:"moderated,omitempty"`
+	// Model is the model that wrote the answer, which differs from the
+	// requested one after a fallback.
+	Model string `json:"model,omitempty"`
+	// Language is the code of the language the answer was written in.
+	Language string `json:"language,omitempty"`
+	// Usage adds up the tokens and cost of every completion call.
+	Usage *Usage `json:"usage,omitempty"`
+	// Trace records every completion call and tool call, for admins.
+	Trace []TraceStep `json:"trace,omitempty"`
 }
 
 // GenerateSchema generates a JSON schema for the given type.
@@ -70,7 +87,7 @@ func GenerateSchema[T any]() interface{} {
 }
 
 // MakeChatCompletionCall calls the OpenAI Chat Completion API.
-func MakeChatCompletionCall(client *openai.Client, ctx context.Context, params *openai.ChatCompletionNewParams, logger *slog.Logger) (*openai.ChatCompletion, error) {
+func MakeChatCompletionCall(client CompletionClient, ctx context.Context, params *openai.ChatCompletionNewParams, logger *slog.Logger) (*openai.ChatCompletion, error) {
 	startTime := time.Now()
 	logger.Info("Starting ChatGPT request",
 		"model", params.Model.Value,
@@ -78,7 +95,7 @@ func MakeChatCompletionCall(client *openai.Client, ctx context.Context, params *
 		"has_tools", params.Tools.Value != nil,
 		"has_response_format", params.ResponseFormat.Value != nil)
 
-	result, err := client.Chat.Completions.New(ctx, *params)
+	result, err := client.Complete(ctx, *params)
 	if err != nil {
 		logger.Error("ChatGPT request failed",
 			"error", err,
@@ -100,76 +117,104 @@ func MakeChatCompletionCall(client *openai.Client, ctx context.Context, params *
 	return result, nil
 }
 
+// MakeStreamingChatCompletionCall is MakeChatCompletionCall over a streamed
+// response. Every content delta is passed to onDelta as it arrives and the
+// accumulated completion is returned at the end.
+func MakeStreamingChatCompletionCall(client CompletionClient, ctx context.Context, params *openai.ChatCompletionNewParams, logger *slog.Logger, onDelta func(string)) (*openai.ChatCompletion, error) {
+	startTime := time.Now()
+	logger.Info("Starting streaming ChatGPT request",
+		"model", params.Model.Value,
+		"message_count", len(params.Messages.Value))
+
+	result, err := client.CompleteStreaming(ctx, *params, onDelta)
+	if err != nil {
+		logger.Error("Streaming ChatGPT request failed",
+			"error", err,
+			"model", params.Model.Value,
+			"duration_ms", time.Since(startTime).Milliseconds())
+		return nil, err
+	}
+
+	logger.Info("Streaming ChatGPT request successful",
+		"duration_ms", time.Since(startTime).Milliseconds(),
+		"completion_tokens", result.Usage.CompletionTokens,
+		"prompt_tokens", result.Usage.PromptTokens,
+		"total_tokens", result.Usage.TotalTokens,
+		"finish_reason", result.Choices[0].FinishReason,
+		"response_length", len(result.Choices[0].Message.Content))
+
+	return result, nil
+}
+
 // ProcessToolCalls handles any tool calls returned by the assistant.
 func ProcessToolCalls(result *openai.ChatCompletion, logger *slog.Logger) ([]openai.ChatCompletionMessageParamUnion, bool, error) {
+	p := NewPipeline("")
+	messages, _, searchUsed, err := p.processToolCalls(context.Background(), result, p.tools(Options{}), logger)
+	return messages, searchUsed, err
+}
+
+// processToolCalls runs the tool calls of a completion with the offered
+// tools. Failing and unknown tools are reported back to the model, which
+// can try again or answer without them; only cancellation is an error.
+func (p *Pipeline) processToolCalls(ctx context.Context, result *openai.ChatCompletion, tools []Tool, logger *slog.Logger) ([]openai.ChatCompletionMessageParamUnion, []TraceToolCall, bool, error) {
 	startTime := time.Now()
 	searchUsed := false
 	toolMessages := []openai.ChatCompletionMessageParamUnion{}
+	var calls []TraceToolCall
+	env := ToolEnv{Search: p.Search, Fetch: p.Fetch, Logger: logger}
 
 	logger.Info("Starting tool calls processing",
 		"has_choices", len(result.Choices) > 0,
 		"first_choice_has_tool_calls", result.Choices[0].Message.ToolCalls != nil)
 
 	for choiceIndex, choice := range result.Choices {
-		if choice.Message.ToolCalls != nil {
-			for callIndex, toolCall := range choice.Message.ToolCalls {
-				logger.Info("Processing tool call",
-					"choice_index", choiceIndex,
-					"call_index", callIndex,
-					"tool_id", toolCall.ID,
-					"function_name", toolCall.Function.Name)
-
-				if toolCall.Function.Name == "search_google" {
-					searchStartTime := time.Now()
-					logger.Info("Processing search_google tool call",
-						"tool_id", toolCall.ID,
-						"raw_arguments", toolCall.Function.Arguments)
-
-					var args map[string]interface{}
-					if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-						logger.Error("Failed to parse tool call arguments",
-							"error", err,
-							"raw_arguments", toolCall.Function.Arguments,
-							"duration_ms", time.Since(searchStartTime).Milliseconds())
-						return nil, false, fmt.Errorf("error parsing tool call arguments: %v", err)
-					}
-
-					queryValue, ok := args["query"]
-					if !ok {
-						errMsg := "Error: 'query' field not found in tool call arguments"
-						logger.Error(errMsg,
-							"available_args", args,
-							"duration_ms", time.Since(searchStartTime).Milliseconds())
-						return nil, false, fmt.Errorf("%s", errMsg)
-					}
-
-					searchQuery, ok := queryValue.(string)
-					if !ok {
-						errMsg := "Error: 'query' field is not of type string"
-						logger.Error(errMsg,
-							"query_type", fmt.Sprintf("%T", queryValue),
-							"duration_ms", time.Since(searchStartTime).Milliseconds())
-						return nil, false, fmt.Errorf("%s", errMsg)
-					}
-
-					logger.Info("Executing Google search",
-						"query", searchQuery,
-						"max_results", 10)
-
-					// Perform the search using the webpage scraper
-					searchResults := webpagescraper.GoogleSearch(searchQuery, 10)
-					searchUsed = true
-
-					// Create a tool message response to pass back to the assistant
-					toolMessage := openai.ToolMessage(toolCall.ID, searchResults)
-					toolMessages = append(toolMessages, toolMessage)
+		for callIndex, toolCall := range choice.Message.ToolCalls {
+			toolStartTime := time.Now()
+			logger.Info("Processing tool call",
+				"choice_index", choiceIndex,
+				"call_index", callIndex,
+				"tool_id", toolCall.ID,
+				"function_name", toolCall.Function.Name,
+				"raw_arguments", toolCall.Function.Arguments)
+
+			var output string
+			var err error
+			tool, ok := findTool(tools, toolCall.Function.Name)
+			if !ok {
+				err = fmt.Errorf("unknown tool %q", toolCall.Function.Name)
+			} else {
+				output, err = tool.Execute(ctx, env, toolCall.Function.Arguments)
+				searchUsed = searchUsed || (err == nil && usesInternet(tool))
+			}
+			if ctx.Err() != nil {
+				return nil, nil, searchUsed, ctx.Err()
+			}
 
-					logger.Info("Search completed",
-						"tool_id", toolCall.ID,
-						"results_length", len(searchResults),
-						"duration_ms", time.Since(searchStartTime).Milliseconds())
-				}
+			call := TraceToolCall{
+				Name:        toolCall.Function.Name,
+				Arguments:   toolCall.Function.Arguments,
+				ResultChars: len(output),
+				DurationMS:  time.Since(toolStartTime).Milliseconds(),
+			}
+			if err != nil {
+				logger.Warn("Tool call failed",
+					"tool_id", toolCall.ID,
+					"function_name", toolCall.Function.Name,
+					"error", err,
+					"duration_ms", call.DurationMS)
+				call.Error = err.Error()
+				output = "Error: " + err.Error()
+			} else {
+				logger.Info("Tool call completed",
+					"tool_id", toolCall.ID,
+					"function_name", toolCall.Function.Name,
+					"results_length", len(output),
+					"duration_ms", call.DurationMS)
 			}
+
+			// Create a tool message response to pass back to the assistant
+			toolMessages = append(toolMessages, openai.ToolMessage(toolCall.ID, output))
+			calls = append(calls, call)
 		}
 	}
 
@@ -178,65 +223,299 @@ func ProcessToolCalls(result *openai.ChatCompletion, logger *slog.Logger) ([]ope
 		"tool_messages_count", len(toolMessages),
 		"search_used", searchUsed)
 
-	return toolMessages, searchUsed, nil
+	return toolMessages, calls, searchUsed, nil
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Options tunes how an answer is produced for a single request.
+type Options struct {
+	// Language is the language code of the answer: "bs", "hr", "sr",
+	// "sr-Cyrl" or "en". It is detected from the question when empty.
+	Language string `json:"language,omitempty"`
+	// AnswerLength is one of "short", "normal" or "long".
+	AnswerLength string `json:"answer_length,omitempty"`
+	// Model is the chat model to answer with. It defaults to DefaultModel.
+	Model string `json:"model,omitempty"`
+	// Tools narrows the tools enabled for the deployment down to these,
+	// e.g. for one API key. nil offers all enabled tools.
+	Tools []string `json:"tools"`
+	// DisableSearch withholds the tools that use the internet, e.g. to save money.
+	DisableSearch bool `json:"-"`
+	// History holds earlier turns of the same conversation, oldest first.
+	History []Turn `json:"history,omitempty"`
+	// Images are photos attached to the question, already passed through PrepareImage.
+	Images [][]byte `json:"-"`
+	// SystemPrompt replaces the answer rules of the system prompt (HTML,
+	// word limits, emergency numbers), e.g. to compare a variant in eval.
+	// The language, date, tool and photo instructions are kept.
+	SystemPrompt string `json:"-"`
+	// Moderator, when set, screens the question before any completion call.
+	Moderator Moderator `json:"-"`
+	// ModerationPolicy decides which moderation scores lead to a refusal.
+	// Nil means DefaultModerationPolicy.
+	ModerationPolicy *ModerationPolicy `json:"-"`
+	// Audit receives flagged requests. It is usually a logger that already
+	// carries the client's IP and API key.
+	Audit *slog.Logger `json:"-"`
+	// OnEvent, when set, receives progress events and streamed answer tokens.
+	OnEvent func(Event) `json:"-"`
+}
+
+// Turn is an earlier question and answer of the same conversation.
+type Turn struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// Event reports the progress of an analysis to interactive clients.
+type Event struct {
+	// Type is "progress" or "token".
+	Type string `json:"type"`
+	// Stage is "thinking", "searching" or "writing" for progress events.
+	Stage   string `json:"stage,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Delta is the next piece of the raw answer for token events.
+	Delta string `json:"delta,omitempty"`
+}
+
+func (o Options) emit(e Event) {
+	if o.OnEvent != nil {
+		o.OnEvent(e)
+	}
+}
+
+func (o Options) progress(stage, message string) {
+	o.emit(Event{Type: "progress", Stage: stage, Message: message})
+}
+
+// answerLimits holds the word limits for the long and short responses.
+type answerLimits struct {
+	long  int
+	short int
+}
+
+var answerLengths = map[string]answerLimits{
+	"short":  {long: 100, short: 30},
+	"normal": {long: 200, short: 50},
+	"long":   {long: 350, short: 80},
 }
 
+// limits returns the word limits for the requested answer length.
+func (o Options) limits() answerLimits {
+	if l, ok := answerLengths[o.AnswerLength]; ok {
+		return l
+	}
+	return answerLengths["normal"]
+}
+
+// ValidAnswerLength reports whether length is one of the answer lengths,
+// or empty for the default.
+func ValidAnswerLength(length string) bool {
+	_, ok := answerLengths[length]
+	return ok || length == ""
+}
+
+// WordLimits returns the word limits the prompt sets for the long and short
+// responses of an answer of the given length.
+func WordLimits(answerLength string) (long, short int) {
+	l := Options{AnswerLength: answerLength}.limits()
+	return l.long, l.short
+}
+
+// DefaultModel is the OpenAI chat model used when neither the request nor
+// the provider configuration names one.
+const DefaultModel = openai.ChatModelGPT4oMini
+
 // ChatGPTAnalyse processes the prompt using OpenAI's API and returns the response.
 func ChatGPTAnalyse(prompt, apikey string) string {
+	return ChatGPTAnalyseWithOptions(prompt, apikey, Options{})
+}
+
+// ChatGPTAnalyseWithOptions is ChatGPTAnalyse with per-request answer options.
+func ChatGPTAnalyseWithOptions(prompt, apikey string, opts Options) string {
+	return ChatGPTAnalyseContext(context.Background(), prompt, apikey, opts)
+}
+
+// ChatGPTAnalyseContext is ChatGPTAnalyseWithOptions bound to ctx, so that the
+// caller can cancel a question that is still being answered.
+func ChatGPTAnalyseContext(parent context.Context, prompt, apikey string, opts Options) string {
+	return NewPipeline(apikey).Analyse(parent, prompt, opts)
+}
+
+// ChatGPTAnalyseUsage is ChatGPTAnalyseContext that also returns the usage
+// of the answer, which error messages do not carry.
+func ChatGPTAnalyseUsage(parent context.Context, prompt, apikey string, opts Options) (string, Usage) {
+	return NewPipeline(apikey).AnalyseUsage(parent, prompt, opts)
+}
+
+// Analyse answers prompt and returns the chatResponse JSON, or a plain text
+// error message.
+func (p *Pipeline) Analyse(parent context.Context, prompt string, opts Options) string {
+	answer, _ := p.AnalyseUsage(parent, prompt, opts)
+	return answer
+}
+
+// AnalyseUsage is Analyse that also returns the tokens and cost of every
+// completion call, including those made before the answer failed.
+func (p *Pipeline) AnalyseUsage(parent context.Context, prompt string, opts Options) (string, Usage) {
 	startTime := time.Now()
 	logger, err := initializeLogger()
 	if err != nil {
-		return "Failed to initialize logger"
+		return "Failed to initialize logger", Usage{}
 	}
 
+	// Answer in the requested language, or in the one the question is written in
+	language, detected := resolveLanguage(opts.Language, questionText(prompt))
+	opts.Language = language
+
 	logger.Info("Starting ChatGPT analysis",
 		"prompt_length", len(prompt),
-		"api_key_length", len(apikey))
-
-	client := openai.NewClient(option.WithAPIKey(apikey))
-	ctx, cancel := context.WithCancel(context.Background())
+		"language", opts.Language,
+		"language_detected", detected,
+		"answer_length", opts.AnswerLength,
+		"model", p.model(opts),
+		"history_turns", len(opts.History),
+		"image_count", len(opts.Images),
+		"search_disabled", opts.DisableSearch)
+
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
 
+	model := p.model(opts)
+	caps := capabilitiesOf(ctx, p.Client, model)
+	logger.Info("Provider capabilities",
+		"model", model,
+		"json_schema", caps.JSONSchema,
+		"json_mode", caps.JSONMode,
+		"tools", caps.Tools,
+		"streaming", caps.Streaming,
+		"vision", caps.Vision)
+	if len(opts.Images) > 0 && !caps.Vision {
+		logger.Error("Model cannot read images", "model", model, "image_count", len(opts.Images))
+		return fmt.Sprintf("An error occurred: model %s cannot read photos", model), Usage{}
+	}
+
+	// Screen the question before spending any completion tokens on it
+	if opts.Moderator != nil {
+		policy := DefaultModerationPolicy
+		if opts.ModerationPolicy != nil {
+			policy = *opts.ModerationPolicy
+		}
+		question := questionText(prompt)
+		moderationStartTime := time.Now()
+		scores, err := opts.Moderator.Moderate(ctx, question, opts.Images)
+		if err != nil && policy.FailOpen {
+			logger.Error("Moderation failed, answering without screening",
+				"error", err,
+				"duration_ms", time.Since(moderationStartTime).Milliseconds())
+		} else if err != nil {
+			logger.Error("Moderation failed, not answering",
+				"error", err,
+				"duration_ms", time.Since(moderationStartTime).Milliseconds())
+			return fmt.Sprintf("An error occurred during moderation: %v", err.Error()), Usage{}
+		} else if result := policy.Evaluate(scores); len(result.Flagged) > 0 {
+			logger.Warn("Request flagged by moderation",
+				"flagged", result.Flagged,
+				"duration_ms", time.Since(moderationStartTime).Milliseconds())
+			if opts.Audit != nil {
+				opts.Audit.Warn("Request flagged by moderation",
+					"text", question,
+					"flagged", result.Flagged,
+					"scores", result.Scores,
+					"image_count", len(opts.Images))
+			}
+			finalJSON, err := json.Marshal(chatResponse{Content: refusalContent(result, opts.Language), Moderated: true, Language: opts.Language})
+			if err != nil {
+				return fmt.Sprintf("An error occurred during JSON Marshalling: %v", err.Error()), Usage{}
+			}
+			return string(finalJSON), Usage{}
+		}
+	}
+
 	// Prepare system message
-	currentDate := time.Now().Format("02.01.2006.")
+	currentDate := FormatDate(time.Now(), opts.Language)
 	logger.Info("Preparing system message",
 		"current_date", currentDate)
 
-	systemMessageContent := "You are an intelligent assistant that responds exclusively in Serbian/Bosnian. " +
-		fmt.Sprintf("Use Serbian month names (e.g., 'juni' instead of 'lipanj'). The current date is %s.", currentDate) +
-		"If exact data is needed, use the search_google function to retrieve additional information. " +
-		"\n\n" +
-		"1) In the response named 'longresponse', always use HTML for formatting. " +
-		"   - Use <br> instead of \\n for new lines. " +
-		"   - Use <b> for bold text and <em> for italics. " +
-		"   - Use HTML tags instead of markdown, and under no circumstances can you use markdown." +
-		"   - Add clickable sources using <a> tags with href attributes pointing to references found " +
-		"     via the search_google function. " +
-		"   - Ensure the domain is correct and does not include extra slashes. " +
-		"   - Make links open in a new tab and display in a blue color (VERY IMPORTANT). " +
-		"\n" +
-		"2) In the 'shortresponse', never use HTML. " +
-		"   - Use only plain text. " +
-		"   - Limit is 50 words. " +
-		"\n" +
-		"3) The 'longresponse' is limited to 200 words. " +
-		"\n" +
-		"4) If the user requests emergency service numbers (police, ambulance, fire brigade, or " +
-		"   domestic violence hotlines), always provide: " +
-		"   - Domestic violence helpline in FBIH: 1265 " +
-		"   - Civil Protection Operational Centers: 121 " +
-		"   - Police: 122 " +
-		"   - Fire Department: 123 " +
-		"   - Emergency Medical Services: 124 " +
-		"   - Roadside Assistance: 1282/1285/1288."
-
-	logger.Info("System message prepared",
-		"message_length", len(systemMessageContent),
-		"contains_html_instructions", true,
-		"contains_emergency_numbers", true)
+	limits := opts.limits()
+	systemMessageContent := Languages[opts.Language].Instruction +
+		fmt.Sprintf("The current date is %s. ", currentDate)
+	var tools []Tool
+	if caps.Tools {
+		tools = p.tools(opts)
+	}
+	useTools := len(tools) > 0
+	if _, ok := findTool(tools, "search_knowledge_base"); ok {
+		systemMessageContent += "For pensions, health insurance, social benefits and other official procedures, first use the search_knowledge_base function. " +
+			"Its vetted guides take precedence over web search results; name the guide you used. "
+	}
+	if _, ok := findTool(tools, "medication_info"); ok {
+		systemMessageContent += "For questions about medicines, use the medication_info function and state only the facts it returns. " +
+			"Never advise on doses or combining medicines, and always tell the user to consult their doctor or pharmacist. "
+	}
+	if _, ok := findTool(tools, "next_departures"); ok {
+		systemMessageContent += "For departures of trams, buses and trolleybuses, use the next_departures function and give the exact times it returns. "
+	}
+	if _, ok := findTool(tools, "search_google"); ok {
+		systemMessageContent += "If exact data is needed, use the search_google function to retrieve additional information. "
+	}
+	if _, ok := findTool(tools, "fetch_url"); ok {
+		systemMessageContent += "If the user gives a link, use the fetch_url function to read the page before answering. "
+	}
+	if _, ok := findTool(tools, "calendar"); ok {
+		systemMessageContent += "For weekdays, holidays, Easter dates or counting days, use the calendar function instead of guessing. "
+	}
+	if _, ok := findTool(tools, "calculate"); ok {
+		systemMessageContent += "For any arithmetic or conversion of amounts, currencies or units, use the calculate function and give its result. "
+	}
+	if opts.SystemPrompt != "" {
+		systemMessageContent += "\n\n" + opts.SystemPrompt
+	} else {
+		systemMessageContent += "\n\n" +
+			"1) In the response named 'longresponse', always use HTML for formatting. " +
+			"   - Use <br> instead of \\n for new lines. " +
+			"   - Use <b> for bold text and <em> for italics. " +
+			"   - Use HTML tags instead of markdown, and under no circumstances can you use markdown." +
+			"   - Add clickable sources using <a> tags with href attributes pointing to references found " +
+			"     via the search_google function. " +
+			"   - Ensure the domain is correct and does not include extra slashes. " +
+			"   - Make links open in a new tab and display in a blue color (VERY IMPORTANT). " +
+			"\n" +
+			"2) In the 'shortresponse', never use HTML. " +
+			"   - Use only plain text. " +
+			fmt.Sprintf("   - Limit is %d words. ", limits.short) +
+			"\n" +
+			fmt.Sprintf("3) The 'longresponse' is limited to %d words. ", limits.long) +
+			"\n" +
+			"4) If the user requests emergency service numbers (police, ambulance, fire brigade, or " +
+			"   domestic violence hotlines), always provide: " +
+			"   - Domestic violence helpline in FBIH: 1265 " +
+			"   - Civil Protection Operational Centers: 121 " +
+			"   - Police: 122 " +
+			"   - Fire Department: 123 " +
+			"   - Emergency Medical Services: 124 " +
+			"   - Roadside Assistance: 1282/1285/1288."
+	}
 
-	systemMessage := openai.SystemMessage(systemMessageContent)
-	userMessage := openai.UserMessage("User prompt: " + prompt)
+	if len(opts.Images) > 0 {
+		systemMessageContent += "\n\n" +
+			"5) The user attached photos (for example a letter, a bill or a medicine box). " +
+			"   - Read the text in the photos carefully and explain in simple words what they mean for the user. " +
+			"   - For medicines, never change the dosage written by a doctor or on the package and advise the user " +
+			"     to consult their doctor or pharmacist. " +
+			"   - If a photo is unreadable, say so and ask for a clearer photo."
+	}
 
 	// Generate schema
 	schemaStartTime := time.Now()
@@ -254,40 +533,105 @@ func ChatGPTAnalyse(prompt, apikey string) string {
 		"duration_ms", time.Since(schemaStartTime).Milliseconds(),
 		"schema_size", len(fmt.Sprintf("%v", chatgptResponseSchema)))
 
+	// Providers without strict structured output get the schema in the prompt instead
+	if !caps.JSONSchema {
+		schemaJSON, err := json.Marshal(chatgptResponseSchema)
+		if err != nil {
+			return fmt.Sprintf("An error occurred during JSON Marshalling: %v", err.Error()), Usage{}
+		}
+		systemMessageContent += "\n\n" +
+			"Respond only with a single JSON object, without code fences or any other text, " +
+			"that matches this JSON schema: " + string(schemaJSON)
+	}
+
+	logger.Info("System message prepared",
+		"message_length", len(systemMessageContent),
+		"contains_html_instructions", true,
+		"contains_emergency_numbers", true)
+
+	systemMessage := openai.SystemMessage(systemMessageContent)
+	userMessage := questionMessage(prompt, opts.Images)
+	messages := []openai.ChatCompletionMessageParamUnion{systemMessage}
+	for _, turn := range opts.History {
+		messages = append(messages,
+			openai.UserMessage("User prompt: "+turn.Question),
+			openai.AssistantMessage(turn.Answer))
+	}
+	messages = append(messages, userMessage)
+
 	// Prepare API parameters
 	params := openai.ChatCompletionNewParams{
-		ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+		Messages: openai.F(messages),
+		Model:    openai.F(model),
+	}
+	if caps.JSONSchema {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
 			shared.ResponseFormatJSONSchemaParam{
 				Type:       openai.F(shared.ResponseFormatJSONSchemaTypeJSONSchema),
 				JSONSchema: openai.F(schemaParam),
 			},
-		),
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{systemMessage, userMessage}),
-		Tools: openai.F([]openai.ChatCompletionToolParam{
-			{
-				Type: openai.F(openai.ChatCompletionToolTypeFunction),
-				Function: openai.F(openai.FunctionDefinitionParam{
-					Name:        openai.String("search_google"),
-					Description: openai.String("Search Google for additional information"),
-					Parameters: openai.F(openai.FunctionParameters{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"query": map[string]string{
-								"type": "string",
-							},
-						},
-						"required": []string{"query"},
-					}),
-				}),
+		)
+	} else if caps.JSONMode {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+			shared.ResponseFormatJSONObjectParam{
+				Type: openai.F(shared.ResponseFormatJSONObjectTypeJSONObject),
 			},
-		}),
-		Model: openai.F(openai.ChatModelGPT4oMini),
+		)
+	}
+	if useTools {
+		params.Tools = openai.F(toolParams(tools))
 	}
 
 	searchUsed := false
+	var notices []string
+	answerModel := model
+	var usage Usage
+	// failed returns an error message with the usage so far, so that the
+	// tokens spent before the failure are still accounted for
+	failed := func(message string) (string, Usage) {
+		if usage.Calls > 0 {
+			logger.Warn("ChatGPT analysis failed after completion calls",
+				"total_duration_ms", time.Since(startTime).Milliseconds(),
+				"calls", usage.Calls,
+				"total_tokens", usage.TotalTokens,
+				"cost_usd", usage.CostUSD)
+		}
+		return message, usage
+	}
+	var trace []TraceStep
 	var crContent chatResponseContent
 	maxAttempts := 3
 
+	// complete makes a completion call under policy. With a listener and a
+	// model that streams, the answer's tokens are sent as they arrive, so the
+	// first call streams too and any tool calls come from the streamed result.
+	// Otherwise an answer is sent as one token event. The "writing" stage is
+	// reported before the first token.
+	complete := func(policy RetryPolicy, site string, callParams *openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		writing := false
+		emit := func(delta string) {
+			if !writing {
+				writing = true
+				opts.progress("writing", "")
+			}
+			opts.emit(Event{Type: "token", Delta: delta})
+		}
+		if opts.OnEvent != nil && caps.Streaming {
+			// Tokens that reached the client cannot be taken back, so only
+			// retry while nothing has been streamed
+			return policy.do(ctx, logger, site, func() bool { return !writing }, func() (*openai.ChatCompletion, error) {
+				return MakeStreamingChatCompletionCall(p.Client, ctx, callParams, logger, emit)
+			})
+		}
+		result, err := policy.do(ctx, logger, site, nil, func() (*openai.ChatCompletion, error) {
+			return MakeChatCompletionCall(p.Client, ctx, callParams, logger)
+		})
+		if err == nil && len(result.Choices[0].Message.ToolCalls) == 0 {
+			emit(result.Choices[0].Message.Content)
+		}
+		return result, err
+	}
+
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		attemptStartTime := time.Now()
 		logger.Info("Starting API call attempt",
@@ -296,14 +640,17 @@ func ChatGPTAnalyse(prompt, apikey string) string {
 			"message_count", len(params.Messages.Value))
 
 		// First API call
-		result, err := MakeChatCompletionCall(client, ctx, &params, logger)
+		opts.progress("thinking", "")
+		result, err := complete(p.ToolCallRetry, "first_call", &params)
 		if err != nil {
 			logger.Error("First API call failed",
 				"attempt", attempt,
 				"duration_ms", time.Since(attemptStartTime).Milliseconds(),
 				"error", err)
-			return fmt.Sprintf("An error occurred: %v", err.Error())
+			return failed(fmt.Sprintf("An error occurred: %v", err.Error()))
 		}
+		usage.add(result, model)
+		trace = append(trace, traceStep(attempt, 1, model, useTools, result, attemptStartTime))
 
 		logger.Info("First API call completed",
 			"attempt", attempt,
@@ -312,56 +659,107 @@ func ChatGPTAnalyse(prompt, apikey string) string {
 			"prompt_tokens", result.Usage.PromptTokens,
 			"total_tokens", result.Usage.TotalTokens)
 
-		// Process tool calls
-		toolStartTime := time.Now()
-		logger.Info("Processing tool calls",
-			"attempt", attempt,
-			"has_tool_calls", result.Choices[0].Message.ToolCalls != nil)
-
-		toolMessages, toolSearchUsed, err := ProcessToolCalls(result, logger)
-		searchUsed = searchUsed || toolSearchUsed
-		if err != nil {
-			logger.Error("Tool calls processing failed",
+		// Run tool calls until the model answers, or until it runs out of
+		// rounds, tokens or time and has to answer with what it has
+		for round := 1; len(result.Choices[0].Message.ToolCalls) > 0; round++ {
+			toolStartTime := time.Now()
+			logger.Info("Processing tool calls",
 				"attempt", attempt,
-				"duration_ms", time.Since(toolStartTime).Milliseconds(),
-				"error", err)
-			return err.Error()
-		}
+				"round", round,
+				"has_tool_calls", result.Choices[0].Message.ToolCalls != nil)
 
-		logger.Info("Tool calls processed",
-			"attempt", attempt,
-			"duration_ms", time.Since(toolStartTime).Milliseconds(),
-			"tool_messages_count", len(toolMessages),
-			"search_used", toolSearchUsed)
-
-		// Update conversation with tool results
-		params.Messages.Value = append(params.Messages.Value, result.Choices[0].Message)
-		if len(toolMessages) > 0 {
-			params.Messages.Value = append(params.Messages.Value, toolMessages...)
-			logger.Info("Added tool messages to conversation",
+			for _, toolCall := range result.Choices[0].Message.ToolCalls {
+				if t, ok := findTool(tools, toolCall.Function.Name); ok && usesInternet(t) {
+					opts.progress("searching", toolCall.Function.Arguments)
+				}
+			}
+			toolMessages, calls, toolSearchUsed, err := p.processToolCalls(ctx, result, tools, logger)
+			searchUsed = searchUsed || toolSearchUsed
+			if err != nil {
+				logger.Error("Tool calls processing failed",
+					"attempt", attempt,
+					"round", round,
+					"duration_ms", time.Since(toolStartTime).Milliseconds(),
+					"error", err)
+				return failed(err.Error())
+			}
+			trace[len(trace)-1].ToolCalls = calls
+			for _, call := range calls {
+				if t, ok := findTool(tools, call.Name); ok && call.Error == "" {
+					if nt, ok := t.(NoticeTool); ok && !containsString(notices, nt.Notice(opts.Language)) {
+						notices = append(notices, nt.Notice(opts.Language))
+					}
+				}
+			}
+
+			logger.Info("Tool calls processed",
 				"attempt", attempt,
-				"new_message_count", len(params.Messages.Value))
-		}
+				"round", round,
+				"duration_ms", time.Since(toolStartTime).Milliseconds(),
+				"tool_messages_count", len(toolMessages),
+				"search_used", toolSearchUsed)
+
+			// Update conversation with tool results
+			params.Messages.Value = append(params.Messages.Value, result.Choices[0].Message)
+			if len(toolMessages) > 0 {
+				params.Messages.Value = append(params.Messages.Value, toolMessages...)
+				logger.Info("Added tool messages to conversation",
+					"attempt", attempt,
+					"new_message_count", len(params.Messages.Value))
+			}
 
-		// Second API call
-		secondCallStartTime := time.Now()
-		logger.Info("Starting second API call",
-			"attempt", attempt,
-			"message_count", len(params.Messages.Value))
+			// Next API call, which may answer or ask for more tools
+			answerParams := params
+			toolsOffered := false
+			if useTools {
+				more, reason := p.moreTools(ctx, round, usage.TotalTokens)
+				if more {
+					toolsOffered = true
+				} else {
+					answerParams.Tools.Value, answerParams.Tools.Present = nil, false
+					logger.Info("No more tool rounds",
+						"attempt", attempt,
+						"round", round,
+						"reason", reason,
+						"total_tokens", usage.TotalTokens)
+				}
+			}
 
-		result, err = MakeChatCompletionCall(client, ctx, &params, logger)
-		if err != nil {
-			logger.Error("Second API call failed",
+			followUpStartTime := time.Now()
+			logger.Info("Starting follow-up API call",
 				"attempt", attempt,
-				"duration_ms", time.Since(secondCallStartTime).Milliseconds(),
-				"error", err)
-			return fmt.Sprintf("An error occurred during reprocessing: %v", err.Error())
+				"round", round+1,
+				"tools_offered", toolsOffered,
+				"message_count", len(answerParams.Messages.Value))
+
+			result, err = complete(p.AnswerRetry, "follow_up_call", &answerParams)
+			if err != nil {
+				logger.Error("Follow-up API call failed",
+					"attempt", attempt,
+					"round", round+1,
+					"duration_ms", time.Since(followUpStartTime).Milliseconds(),
+					"error", err)
+				return failed(fmt.Sprintf("An error occurred during reprocessing: %v", err.Error()))
+			}
+			usage.add(result, model)
+			trace = append(trace, traceStep(attempt, round+1, model, toolsOffered, result, followUpStartTime))
+
+			if toolsOffered && len(result.Choices[0].Message.ToolCalls) > 0 {
+				logger.Info("Model asked for another tool round",
+					"attempt", attempt,
+					"round", round+1,
+					"tool_calls", len(result.Choices[0].Message.ToolCalls))
+				continue
+			}
+			break
 		}
 
 		responseContent := result.Choices[0].Message.Content
-		logger.Info("Second API call completed",
+		if result.Model != "" {
+			answerModel = result.Model
+		}
+		logger.Info("Answer call completed",
 			"attempt", attempt,
-			"duration_ms", time.Since(secondCallStartTime).Milliseconds(),
 			"response_length", len(responseContent),
 			"completion_tokens", result.Usage.CompletionTokens,
 			"prompt_tokens", result.Usage.PromptTokens,
@@ -372,19 +770,24 @@ func ChatGPTAnalyse(prompt, apikey string) string {
 			logger.Warn("Received empty response",
 				"attempt", attempt,
 				"will_retry", attempt < maxAttempts)
-			time.Sleep(1 * time.Second)
+			if err := sleepContext(ctx, p.RetryDelay); err != nil {
+				return failed(fmt.Sprintf("An error occurred: %v", err.Error()))
+			}
 			continue
 		}
 
 		// Parse JSON response
 		unmarshalStartTime := time.Now()
+		if !caps.JSONSchema {
+			responseContent = extractJSONObject(responseContent)
+		}
 		if err := json.Unmarshal([]byte(responseContent), &crContent); err != nil {
 			logger.Error("JSON unmarshalling failed",
 				"attempt", attempt,
 				"duration_ms", time.Since(unmarshalStartTime).Milliseconds(),
 				"error", err,
 				"response_content", responseContent)
-			return fmt.Sprintf("An error occurred during JSON Unmarshalling: %v Message content: %s", err.Error(), responseContent)
+			return failed(fmt.Sprintf("An error occurred during JSON Unmarshalling: %v Message content: %s", err.Error(), responseContent))
 		}
 
 		logger.Info("Response parsed successfully",
@@ -401,13 +804,20 @@ func ChatGPTAnalyse(prompt, apikey string) string {
 		logger.Warn("Received incomplete response",
 			"attempt", attempt,
 			"will_retry", attempt < maxAttempts)
-		time.Sleep(1 * time.Second)
+		if err := sleepContext(ctx, p.RetryDelay); err != nil {
+			return failed(fmt.Sprintf("An error occurred: %v", err.Error()))
+		}
 	}
 
 	// Prepare final response
+	crContent = addNotices(crContent, notices)
 	cr := chatResponse{
 		Content:        crContent,
 		InternetSearch: searchUsed,
+		Model:          answerModel,
+		Language:       opts.Language,
+		Usage:          &usage,
+		Trace:          trace,
 	}
 
 	finalJSON, err := json.Marshal(cr)
@@ -415,14 +825,18 @@ func ChatGPTAnalyse(prompt, apikey string) string {
 		logger.Error("JSON marshalling failed",
 			"error", err,
 			"content_size", len(fmt.Sprintf("%v", cr)))
-		return fmt.Sprintf("An error occurred during JSON Marshalling: %v", err.Error())
+		return failed(fmt.Sprintf("An error occurred during JSON Marshalling: %v", err.Error()))
 	}
 
 	logger.Info("ChatGPT analysis completed successfully",
 		"total_duration_ms", time.Since(startTime).Milliseconds(),
 		"prompt_length", len(prompt),
 		"response_length", len(finalJSON),
-		"internet_search_used", searchUsed)
+		"internet_search_used", searchUsed,
+		"model", answerModel,
+		"language", opts.Language,
+		"total_tokens", usage.TotalTokens,
+		"cost_usd", usage.CostUSD)
 
-	return string(finalJSON)
+	return string(finalJSON), usage
 }
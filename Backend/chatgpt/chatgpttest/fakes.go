@@ -0,0 +1,231 @@
+// Package chatgpttest provides in-memory fakes of the chat completion client
+// and the search and fetch backends, for testing the pipeline offline.
+package chatgpttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"code.com/chatgpt"
+	"github.com/openai/openai-go"
+)
+
+// Response is one scripted reply of Client.
+type Response struct {
+	Content   string
+	ToolCalls []ToolCall
+	// Err, when set, is returned instead of a completion.
+	Err error
+}
+
+// ToolCall is a function call requested by the fake model.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// Answer returns a response carrying a chatResponseContent JSON answer.
+func Answer(long, short, title string) Response {
+	content, _ := json.Marshal(map[string]string{
+		"longresponse":  long,
+		"shortresponse": short,
+		"title":         title,
+	})
+	return Response{Content: string(content)}
+}
+
+// SearchCall returns a response asking for a search_google tool call.
+func SearchCall(id, query string) Response {
+	args, _ := json.Marshal(map[string]string{"query": query})
+	return Response{ToolCalls: []ToolCall{{ID: id, Name: "search_google", Arguments: string(args)}}}
+}
+
+// ErrNoResponse is returned once the scripted responses are used up.
+var ErrNoResponse = errors.New("chatgpttest: no scripted response left")
+
+// Client is a CompletionClient that replays scripted responses in order and
+// records every request it receives.
+type Client struct {
+	// Caps, when set, limits what the fake model supports.
+	Caps *chatgpt.Capabilities
+
+	mu        sync.Mutex
+	responses []Response
+	requests  []openai.ChatCompletionNewParams
+	streamed  int
+}
+
+// NewClient returns a Client that answers with responses, in order.
+func NewClient(responses ...Response) *Client {
+	return &Client{responses: responses}
+}
+
+func (c *Client) Capabilities(ctx context.Context, model string) chatgpt.Capabilities {
+	if c.Caps != nil {
+		return *c.Caps
+	}
+	return chatgpt.FullCapabilities
+}
+
+func (c *Client) next(params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The pipeline keeps appending to the message slice, so record a copy
+	params.Messages = openai.F(append([]openai.ChatCompletionMessageParamUnion(nil), params.Messages.Value...))
+	c.requests = append(c.requests, params)
+	if len(c.responses) == 0 {
+		return nil, ErrNoResponse
+	}
+	r := c.responses[0]
+	c.responses = c.responses[1:]
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return completion(params, r), nil
+}
+
+func completion(params openai.ChatCompletionNewParams, r Response) *openai.ChatCompletion {
+	msg := openai.ChatCompletionMessage{
+		Role:    openai.ChatCompletionMessageRoleAssistant,
+		Content: r.Content,
+	}
+	finish := openai.ChatCompletionChoicesFinishReasonStop
+	for _, call := range r.ToolCalls {
+		msg.ToolCalls = append(msg.ToolCalls, openai.ChatCompletionMessageToolCall{
+			ID:   call.ID,
+			Type: openai.ChatCompletionMessageToolCallTypeFunction,
+			Function: openai.ChatCompletionMessageToolCallFunction{
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			},
+		})
+		finish = openai.ChatCompletionChoicesFinishReasonToolCalls
+	}
+	return &openai.ChatCompletion{
+		ID:      "chatcmpl-fake",
+		Model:   params.Model.Value,
+		Object:  openai.ChatCompletionObjectChatCompletion,
+		Choices: []openai.ChatCompletionChoice{{Message: msg, FinishReason: finish}},
+		Usage: openai.CompletionUsage{
+			PromptTokens:     10,
+			CompletionTokens: int64(len(strings.Fields(r.Content))),
+			TotalTokens:      10 + int64(len(strings.Fields(r.Content))),
+		},
+	}
+}
+
+func (c *Client) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.next(params)
+}
+
+// CompleteStreaming delivers the scripted content to onDelta in a few chunks.
+func (c *Client) CompleteStreaming(ctx context.Context, params openai.ChatCompletionNewParams, onDelta func(string)) (*openai.ChatCompletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result, err := c.next(params)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.streamed++
+	c.mu.Unlock()
+	content := result.Choices[0].Message.Content
+	for len(content) > 0 {
+		n := min(8, len(content))
+		onDelta(content[:n])
+		content = content[n:]
+	}
+	return result, nil
+}
+
+// Requests returns the requests received so far.
+func (c *Client) Requests() []openai.ChatCompletionNewParams {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]openai.ChatCompletionNewParams(nil), c.requests...)
+}
+
+// Streamed returns how many requests were answered through CompleteStreaming.
+func (c *Client) Streamed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streamed
+}
+
+// Remaining returns how many scripted responses have not been used.
+func (c *Client) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.responses)
+}
+
+// MessagesJSON returns the messages of a request encoded as JSON, which is
+// convenient for checking what the model was shown.
+func MessagesJSON(params openai.ChatCompletionNewParams) string {
+	data, err := json.Marshal(params.Messages.Value)
+	if err != nil {
+		return fmt.Sprintf("<invalid messages: %v>", err)
+	}
+	return string(data)
+}
+
+// Searcher is an in-memory webpagescraper.Searcher.
+type Searcher struct {
+	mu sync.Mutex
+	// Results maps a query to its result URLs.
+	Results map[string][]string
+	queries []string
+}
+
+func (s *Searcher) Search(ctx context.Context, query string, count int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, query)
+	urls := s.Results[query]
+	if len(urls) > count {
+		urls = urls[:count]
+	}
+	return urls, nil
+}
+
+// Queries returns the queries searched for so far.
+func (s *Searcher) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+// Fetcher is an in-memory webpagescraper.Fetcher.
+type Fetcher struct {
+	mu sync.Mutex
+	// Pages maps a URL to its HTML. Other URLs fail to fetch.
+	Pages   map[string]string
+	fetched []string
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetched = append(f.fetched, url)
+	page, ok := f.Pages[url]
+	if !ok {
+		return nil, fmt.Errorf("chatgpttest: no page for %s", url)
+	}
+	return []byte(page), nil
+}
+
+// Fetched returns the URLs fetched so far.
+func (f *Fetcher) Fetched() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.fetched...)
+}
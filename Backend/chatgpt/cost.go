@@ -0,0 +1,99 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// Price is the price of a model in US dollars per million tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Prices maps model names to their prices. A model also matches the entry
+// with the longest prefix of its name, so that dated snapshots such as
+// gpt-4o-mini-2024-07-18 use the gpt-4o-mini price. main extends it from
+// the PRICE_TABLE file.
+var Prices = map[string]Price{
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+	"gpt-4o":            {Input: 2.50, Output: 10.00},
+	"gpt-4.1-mini":      {Input: 0.40, Output: 1.60},
+	"gpt-4.1":           {Input: 2.00, Output: 8.00},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4.00},
+	"claude-3-5-sonnet": {Input: 3.00, Output: 15.00},
+}
+
+// LoadPrices adds the prices in a JSON file of the form
+// {"model": {"input": 0.15, "output": 0.6}} to Prices.
+func LoadPrices(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var prices map[string]Price
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return err
+	}
+	for model, price := range prices {
+		Prices[model] = price
+	}
+	return nil
+}
+
+// PriceOf returns the price of a model and whether it is known.
+func PriceOf(model string) (Price, bool) {
+	if price, ok := Prices[model]; ok {
+		return price, true
+	}
+	best := ""
+	for name := range Prices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return Prices[best], true
+}
+
+// Usage is the token usage and cost of one answer, summed over all of its
+// completion calls.
+type Usage struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	// Unpriced lists models used without an entry in Prices; their tokens
+	// are counted but cost nothing.
+	Unpriced []string `json:"unpriced,omitempty"`
+}
+
+// add counts a completion. model is the requested model, used when the
+// result does not say which model answered.
+func (u *Usage) add(result *openai.ChatCompletion, model string) {
+	if result.Model != "" {
+		model = result.Model
+	}
+	u.Calls++
+	u.PromptTokens += result.Usage.PromptTokens
+	u.CompletionTokens += result.Usage.CompletionTokens
+	u.TotalTokens += result.Usage.TotalTokens
+
+	price, ok := PriceOf(model)
+	if !ok {
+		for _, m := range u.Unpriced {
+			if m == model {
+				return
+			}
+		}
+		u.Unpriced = append(u.Unpriced, model)
+		return
+	}
+	u.CostUSD += (float64(result.Usage.PromptTokens)*price.Input + float64(result.Usage.CompletionTokens)*price.Output) / 1e6
+}
@@ -0,0 +1,75 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+func TestAnalyseRecordsUsage(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav"),
+	)
+	p, _, _ := newPipeline(client)
+
+	var a struct {
+		Usage chatgpt.Usage `json:"usage"`
+	}
+	result := p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{Model: "gpt-4o-mini-2024-07-18"})
+	if err := json.Unmarshal([]byte(result), &a); err != nil {
+		t.Fatalf("result is not a chatResponse: %v\n%s", err, result)
+	}
+
+	u := a.Usage
+	if u.Calls != 2 || u.PromptTokens != 20 {
+		t.Errorf("usage = %+v, want 2 calls with 20 prompt tokens", u)
+	}
+	price, ok := chatgpt.PriceOf("gpt-4o-mini-2024-07-18")
+	if !ok || price != chatgpt.Prices["gpt-4o-mini"] {
+		t.Fatalf("PriceOf did not match the gpt-4o-mini prefix: %+v", price)
+	}
+	want := (float64(u.PromptTokens)*price.Input + float64(u.CompletionTokens)*price.Output) / 1e6
+	if math.Abs(u.CostUSD-want) > 1e-12 || len(u.Unpriced) != 0 {
+		t.Errorf("cost = %v (unpriced %v), want %v", u.CostUSD, u.Unpriced, want)
+	}
+}
+
+func TestUsageListsUnpricedModels(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Answer("a", "b", "c"),
+	)
+	p, _, _ := newPipeline(client)
+
+	var a struct {
+		Usage chatgpt.Usage `json:"usage"`
+	}
+	json.Unmarshal([]byte(p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{Model: "llama3.1"})), &a)
+
+	if a.Usage.CostUSD != 0 || len(a.Usage.Unpriced) != 1 || a.Usage.Unpriced[0] != "llama3.1" {
+		t.Errorf("usage = %+v, want llama3.1 unpriced", a.Usage)
+	}
+}
+
+func TestAnalyseUsageOfFailedAnswer(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Response{Err: &chatgpt.StatusError{Provider: "test", StatusCode: http.StatusBadRequest}},
+	)
+	p, _, _ := newPipeline(client)
+
+	result, usage := p.AnalyseUsage(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{Model: "gpt-4o-mini"})
+
+	if json.Valid([]byte(result)) {
+		t.Fatalf("expected an error message, got %s", result)
+	}
+	if usage.Calls != 1 || usage.PromptTokens != 10 || usage.CostUSD <= 0 {
+		t.Errorf("usage = %+v, want the first call", usage)
+	}
+}
@@ -0,0 +1,172 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Embedder turns texts into vectors for the knowledge base.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model names the embedding model. An index can only be searched with
+	// the model that built it.
+	Model() string
+}
+
+// DefaultEmbeddingModel is the OpenAI model used when none is configured.
+const DefaultEmbeddingModel = "text-embedding-3-small"
+
+// embedBatch is the number of texts sent in one embeddings request.
+const embedBatch = 100
+
+// OpenAIEmbedder embeds texts with the OpenAI embeddings API or a
+// compatible server.
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+func (e *OpenAIEmbedder) Model() string { return e.model }
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatch {
+		batch := texts[start:min(start+embedBatch, len(texts))]
+		result, err := e.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(batch)),
+			Model: openai.F(openai.EmbeddingModel(e.model)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Data) != len(batch) {
+			return nil, fmt.Errorf("got %d embeddings for %d texts", len(result.Data), len(batch))
+		}
+		embedded := make([][]float32, len(batch))
+		for _, d := range result.Data {
+			if d.Index < 0 || int(d.Index) >= len(batch) {
+				return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+			}
+			v := make([]float32, len(d.Embedding))
+			for i, x := range d.Embedding {
+				v[i] = float32(x)
+			}
+			embedded[d.Index] = v
+		}
+		vectors = append(vectors, embedded...)
+	}
+	return vectors, nil
+}
+
+// HashEmbedder embeds texts locally by hashing their words and word pairs
+// into a fixed number of dimensions. It needs no API and finds passages
+// that share words with the query, which is enough for small curated
+// collections and for tests.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+func (e HashEmbedder) Model() string { return fmt.Sprintf("hash-%d", e.Dimensions) }
+
+func (e HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, e.Dimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for j, w := range words {
+			e.add(v, w, 1)
+			if j > 0 {
+				e.add(v, words[j-1]+" "+w, 0.5)
+			}
+		}
+		vectors[i] = normalize(v)
+	}
+	return vectors, nil
+}
+
+func (e HashEmbedder) add(v []float32, term string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(term))
+	sum := h.Sum64()
+	if sum&1 == 1 {
+		weight = -weight
+	}
+	v[(sum>>1)%uint64(len(v))] += weight
+}
+
+// normalize scales v to unit length, so that cosine similarity is a dot
+// product.
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// ParseEmbedder parses the embedder setting: "hash" for the local
+// embedder or provider:model@baseURL as in ParseFallbacks.
+func ParseEmbedder(s string) (ProviderConfig, error) {
+	if s = strings.TrimSpace(s); s == "hash" {
+		return ProviderConfig{Name: "hash"}, nil
+	}
+	if s == "" {
+		return ProviderConfig{Name: ProviderOpenAI, Model: DefaultEmbeddingModel}, nil
+	}
+	chain, err := ParseFallbacks(s)
+	if err != nil {
+		return ProviderConfig{}, err
+	}
+	if len(chain) != 1 {
+		return ProviderConfig{}, fmt.Errorf("expected one embedder, got %d", len(chain))
+	}
+	return chain[0], nil
+}
+
+// NewEmbedder returns the embedder for cfg. The hash provider embeds
+// locally; the others use the OpenAI embeddings API at cfg.BaseURL.
+// apikey is used when cfg has no key of its own.
+func NewEmbedder(cfg ProviderConfig, apikey string) (Embedder, error) {
+	if cfg.APIKey != "" {
+		apikey = cfg.APIKey
+	}
+	switch cfg.Name {
+	case "hash":
+		return HashEmbedder{Dimensions: 512}, nil
+	case "", ProviderOpenAI, ProviderOpenAICompatible:
+		if cfg.Name == ProviderOpenAICompatible && cfg.BaseURL == "" {
+			return nil, fmt.Errorf("provider %s needs a base URL", cfg.Name)
+		}
+		if apikey == "" {
+			// Local servers ignore the key, but the client insists on sending one
+			apikey = "none"
+		}
+		opts := []option.RequestOption{option.WithAPIKey(apikey)}
+		if cfg.BaseURL != "" {
+			opts = append(opts, option.WithBaseURL(cfg.BaseURL))
+		}
+		model := cfg.Model
+		if model == "" {
+			model = DefaultEmbeddingModel
+		}
+		return &OpenAIEmbedder{client: openai.NewClient(opts...), model: model}, nil
+	default:
+		return nil, fmt.Errorf("provider %s cannot embed texts", cfg.Name)
+	}
+}
@@ -0,0 +1,116 @@
+package chatgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Classes of provider errors that are worth retrying or falling back on.
+const (
+	ErrorRateLimit = "rate_limit"
+	ErrorServer    = "server_error"
+	ErrorTimeout   = "timeout"
+	ErrorNetwork   = "network"
+	ErrorRefusal   = "refusal"
+)
+
+// ErrRefused is returned when a model declines to answer.
+var ErrRefused = errors.New("model refused to answer")
+
+// StatusError is an HTTP error from a provider that is not called through
+// the OpenAI SDK.
+type StatusError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+	// RetryAfter is the delay the provider asked for, if any.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: request failed with status %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// ClassifyError returns the class of a completion error, or "" for errors
+// that will not go away by trying again, like a bad request or a wrong key.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrRefused) {
+		return ErrorRefusal
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return ""
+	}
+	if code := statusCode(err); code != 0 {
+		switch {
+		case code == http.StatusTooManyRequests:
+			return ErrorRateLimit
+		case code == http.StatusRequestTimeout:
+			return ErrorTimeout
+		case code >= 500:
+			return ErrorServer
+		}
+		return ""
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorNetwork
+	}
+	return ""
+}
+
+func statusCode(err error) int {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+// RetryAfter returns the delay a provider asked for in its error response,
+// or zero if it did not say.
+func RetryAfter(err error) time.Duration {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		return parseRetryAfter(apiErr.Response.Header, time.Now())
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,308 @@
+package chatgpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+// Fallbacks are tried in order when the primary Provider fails with a
+// timeout, a server error, a rate limit or a refusal. main sets them from
+// the environment.
+var Fallbacks []ProviderConfig
+
+// FallbackTimeout bounds each attempt of a fallback chain built by NewPipeline.
+var FallbackTimeout = 45 * time.Second
+
+// ParseFallbacks parses a comma separated chain of provider:model entries,
+// optionally followed by @baseURL, e.g.
+// "openai:gpt-4o,anthropic:claude-3-5-haiku-latest,openai-compatible:llama3.1@http://ollama:11434/v1".
+// A model without a provider uses OpenAI.
+func ParseFallbacks(s string) ([]ProviderConfig, error) {
+	var chain []ProviderConfig
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var cfg ProviderConfig
+		entry, cfg.BaseURL, _ = strings.Cut(entry, "@")
+		if name, model, ok := strings.Cut(entry, ":"); ok {
+			cfg.Name, cfg.Model = name, model
+		} else {
+			cfg.Name, cfg.Model = ProviderOpenAI, entry
+		}
+		if cfg.Model == "" {
+			return nil, fmt.Errorf("fallback %q has no model", entry)
+		}
+		chain = append(chain, cfg)
+	}
+	return chain, nil
+}
+
+// FallbackEntry is one link of a fallback chain.
+type FallbackEntry struct {
+	Client CompletionClient
+	// Model replaces the requested model when this entry is used as a
+	// fallback. The first entry keeps the requested model.
+	Model string
+}
+
+// FallbackClient tries a chain of clients in order until one answers.
+type FallbackClient struct {
+	Entries []FallbackEntry
+	// AttemptTimeout bounds each attempt, so that a hanging provider leaves
+	// time for the next one. Zero means no bound besides the request's own.
+	AttemptTimeout time.Duration
+}
+
+// fallbackReason returns why an attempt should be handed to the next entry,
+// or "" if its outcome is final.
+func fallbackReason(result *openai.ChatCompletion, err error) string {
+	if err != nil {
+		return ClassifyError(err)
+	}
+	if len(result.Choices) > 0 {
+		choice := result.Choices[0]
+		if choice.Message.Refusal != "" || choice.FinishReason == openai.ChatCompletionChoicesFinishReasonContentFilter {
+			return ErrorRefusal
+		}
+	}
+	return ""
+}
+
+func (c *FallbackClient) attempt(ctx context.Context, i int, params openai.ChatCompletionNewParams, call func(context.Context, CompletionClient, openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)) (*openai.ChatCompletion, error) {
+	if i > 0 {
+		params.Model = openai.F(c.Entries[i].Model)
+	}
+	if c.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.AttemptTimeout)
+		defer cancel()
+	}
+	result, err := call(ctx, c.Entries[i].Client, params)
+	if err == nil && result.Model == "" {
+		result.Model = params.Model.Value
+	}
+	return result, err
+}
+
+func (c *FallbackClient) run(ctx context.Context, params openai.ChatCompletionNewParams, canFallBack func() bool, call func(context.Context, CompletionClient, openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)) (*openai.ChatCompletion, error) {
+	if len(c.Entries) == 0 {
+		return nil, errors.New("empty fallback chain")
+	}
+	var (
+		result *openai.ChatCompletion
+		err    error
+		reason string
+		tried  int
+	)
+	for i := range c.Entries {
+		entryParams, extract := params, false
+		if i > 0 {
+			if reason == "" || ctx.Err() != nil || !canFallBack() {
+				break
+			}
+			var refusal string
+			entryParams, extract, refusal = c.fit(ctx, i, params)
+			if refusal != "" {
+				if logger, err2 := initializeLogger(); err2 == nil {
+					logger.Warn("Fallback model skipped",
+						"model", c.Entries[i].Model,
+						"reason", refusal)
+				}
+				continue
+			}
+			from, to := modelName(params, c.Entries, tried), c.Entries[i].Model
+			fallbackMetrics.fellBack(from, to, reason)
+			if logger, err2 := initializeLogger(); err2 == nil {
+				logger.Warn("Model fallback",
+					"from_model", from,
+					"to_model", to,
+					"reason", reason,
+					"error", err)
+			}
+		}
+		result, err = c.attempt(ctx, i, entryParams, call)
+		if err == nil && extract {
+			for j := range result.Choices {
+				if content := result.Choices[j].Message.Content; content != "" {
+					result.Choices[j].Message.Content = extractJSONObject(content)
+				}
+			}
+		}
+		reason = fallbackReason(result, err)
+		tried = i
+	}
+	fallbackMetrics.finished(modelName(params, c.Entries, tried), tried > 0, err == nil && reason == "")
+	return result, err
+}
+
+// fit adapts a request built for the primary model to fallback entry i. It
+// returns whether the answer must be extracted from surrounding text, or why
+// the entry cannot serve the request at all.
+func (c *FallbackClient) fit(ctx context.Context, i int, params openai.ChatCompletionNewParams) (openai.ChatCompletionNewParams, bool, string) {
+	caps := capabilitiesOf(ctx, c.Entries[i].Client, c.Entries[i].Model)
+	messages, err := json.Marshal(params.Messages.Value)
+	if err != nil {
+		return params, false, err.Error()
+	}
+	if !caps.Tools && (params.Tools.Present || bytes.Contains(messages, []byte(`"tool_call_id"`))) {
+		return params, false, "no tool support"
+	}
+	if !caps.Vision && bytes.Contains(messages, []byte(`"image_url"`)) {
+		return params, false, "no vision support"
+	}
+
+	format, ok := params.ResponseFormat.Value.(shared.ResponseFormatJSONSchemaParam)
+	if !ok || caps.JSONSchema {
+		return params, false, ""
+	}
+	// Without strict structured output the schema goes into the prompt, as
+	// Analyse does for such a primary model
+	schemaJSON, err := json.Marshal(format.JSONSchema.Value.Schema.Value)
+	if err != nil {
+		return params, false, err.Error()
+	}
+	instruction := openai.SystemMessage("Respond only with a single JSON object, without code fences or any other text, " +
+		"that matches this JSON schema: " + string(schemaJSON))
+	reshaped := append([]openai.ChatCompletionMessageParamUnion{}, params.Messages.Value[:1]...)
+	reshaped = append(reshaped, instruction)
+	reshaped = append(reshaped, params.Messages.Value[1:]...)
+	params.Messages = openai.F(reshaped)
+	if caps.JSONMode {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+			shared.ResponseFormatJSONObjectParam{
+				Type: openai.F(shared.ResponseFormatJSONObjectTypeJSONObject),
+			},
+		)
+	} else {
+		params.ResponseFormat = openai.ChatCompletionNewParams{}.ResponseFormat
+	}
+	return params, true, ""
+}
+
+func modelName(params openai.ChatCompletionNewParams, entries []FallbackEntry, i int) string {
+	if i == 0 {
+		return params.Model.Value
+	}
+	return entries[i].Model
+}
+
+func (c *FallbackClient) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	return c.run(ctx, params, func() bool { return true }, func(ctx context.Context, client CompletionClient, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		return client.Complete(ctx, params)
+	})
+}
+
+// CompleteStreaming falls back only while nothing has been streamed yet;
+// tokens that reached the client cannot be taken back. An entry that cannot
+// stream sends its whole answer as one delta.
+func (c *FallbackClient) CompleteStreaming(ctx context.Context, params openai.ChatCompletionNewParams, onDelta func(string)) (*openai.ChatCompletion, error) {
+	streamed := false
+	return c.run(ctx, params, func() bool { return !streamed }, func(ctx context.Context, client CompletionClient, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		if !capabilitiesOf(ctx, client, params.Model.Value).Streaming {
+			result, err := client.Complete(ctx, params)
+			if err == nil && len(result.Choices) > 0 && result.Choices[0].Message.Content != "" {
+				streamed = true
+				onDelta(result.Choices[0].Message.Content)
+			}
+			return result, err
+		}
+		return client.CompleteStreaming(ctx, params, func(delta string) {
+			streamed = true
+			onDelta(delta)
+		})
+	})
+}
+
+// Capabilities are those of the primary model, which every request is built
+// for. Fallbacks that support less get the request reshaped, or are skipped.
+func (c *FallbackClient) Capabilities(ctx context.Context, model string) Capabilities {
+	if len(c.Entries) == 0 {
+		return FullCapabilities
+	}
+	return capabilitiesOf(ctx, c.Entries[0].Client, model)
+}
+
+// FallbackCount is how often one model fell back to another for one reason.
+type FallbackCount struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// FallbackMetrics summarise the fallback chain since the process started.
+type FallbackMetrics struct {
+	// Calls is the number of completion calls made through a fallback chain.
+	Calls int64 `json:"calls"`
+	// FallbackCalls is how many of them were answered by a fallback model.
+	FallbackCalls int64 `json:"fallback_calls"`
+	// Failed is how many of them no model of the chain answered.
+	Failed int64 `json:"failed"`
+	// ServedBy counts the calls answered by each model.
+	ServedBy  map[string]int64 `json:"served_by"`
+	Fallbacks []FallbackCount  `json:"fallbacks"`
+}
+
+type fallbackCounters struct {
+	mu            sync.Mutex
+	calls         int64
+	fallbackCalls int64
+	failed        int64
+	servedBy      map[string]int64
+	fallbacks     map[[3]string]int64
+}
+
+var fallbackMetrics = &fallbackCounters{servedBy: map[string]int64{}, fallbacks: map[[3]string]int64{}}
+
+func (m *fallbackCounters) finished(model string, fallback, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if !ok {
+		m.failed++
+		return
+	}
+	if fallback {
+		m.fallbackCalls++
+	}
+	m.servedBy[model]++
+}
+
+func (m *fallbackCounters) fellBack(from, to, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallbacks[[3]string{from, to, reason}]++
+}
+
+// Metrics returns a snapshot of the fallback counters.
+func Metrics() FallbackMetrics {
+	fallbackMetrics.mu.Lock()
+	defer fallbackMetrics.mu.Unlock()
+	out := FallbackMetrics{
+		Calls:         fallbackMetrics.calls,
+		FallbackCalls: fallbackMetrics.fallbackCalls,
+		Failed:        fallbackMetrics.failed,
+		ServedBy:      map[string]int64{},
+		Fallbacks:     []FallbackCount{},
+	}
+	for model, n := range fallbackMetrics.servedBy {
+		out.ServedBy[model] = n
+	}
+	for k, n := range fallbackMetrics.fallbacks {
+		out.Fallbacks = append(out.Fallbacks, FallbackCount{From: k[0], To: k[1], Reason: k[2], Count: n})
+	}
+	sort.Slice(out.Fallbacks, func(i, j int) bool { return out.Fallbacks[i].Count > out.Fallbacks[j].Count })
+	return out
+}
@@ -0,0 +1,196 @@
+package chatgpt_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+func TestAnalyseFallsBackOnServerErrors(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusTooManyRequests} {
+		failure := chatgpttest.Response{Err: &chatgpt.StatusError{Provider: "primary", StatusCode: status}}
+		primary := chatgpttest.NewClient(failure, failure)
+		backup := chatgpttest.NewClient(
+			chatgpttest.Response{Content: ""},
+			chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav"),
+		)
+		p, _, _ := newPipeline(nil)
+		p.Client = &chatgpt.FallbackClient{Entries: []chatgpt.FallbackEntry{
+			{Client: primary, Model: "primary-model"},
+			{Client: backup, Model: "backup-model"},
+		}}
+
+		before := chatgpt.Metrics()
+		a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{Model: "primary-model"}))
+		after := chatgpt.Metrics()
+
+		if a.Content.Shortresponse != "Dobar dan" {
+			t.Errorf("status %d: unexpected answer %+v", status, a.Content)
+		}
+		if a.Model != "backup-model" {
+			t.Errorf("status %d: model = %q, want backup-model", status, a.Model)
+		}
+		if got := backup.Requests(); len(got) != 2 || got[0].Model.Value != "backup-model" {
+			t.Errorf("status %d: backup got %d requests", status, len(got))
+		}
+		if after.FallbackCalls != before.FallbackCalls+2 || after.ServedBy["backup-model"] != before.ServedBy["backup-model"]+2 {
+			t.Errorf("status %d: metrics not updated: before %+v, after %+v", status, before, after)
+		}
+	}
+}
+
+func TestFallbackClientKeepsFinalErrors(t *testing.T) {
+	badRequest := &chatgpt.StatusError{Provider: "primary", StatusCode: http.StatusBadRequest}
+	primary := chatgpttest.NewClient(chatgpttest.Response{Err: badRequest})
+	backup := chatgpttest.NewClient(chatgpttest.Answer("a", "b", "c"))
+	client := &chatgpt.FallbackClient{Entries: []chatgpt.FallbackEntry{
+		{Client: primary, Model: "primary-model"},
+		{Client: backup, Model: "backup-model"},
+	}}
+
+	_, err := client.Complete(context.Background(), openai.ChatCompletionNewParams{Model: openai.F("primary-model")})
+	if !errors.Is(err, badRequest) {
+		t.Errorf("err = %v, want the bad request error", err)
+	}
+	if backup.Remaining() != 1 {
+		t.Error("fell back on a bad request")
+	}
+}
+
+func TestFallbackClientReportsPrimaryCapabilities(t *testing.T) {
+	backup := chatgpttest.NewClient()
+	backup.Caps = &chatgpt.Capabilities{Tools: true}
+	client := &chatgpt.FallbackClient{Entries: []chatgpt.FallbackEntry{
+		{Client: chatgpttest.NewClient(), Model: "primary-model"},
+		{Client: backup, Model: "backup-model"},
+	}}
+	if got := client.Capabilities(context.Background(), "primary-model"); got != chatgpt.FullCapabilities {
+		t.Errorf("capabilities = %+v, want the primary's %+v", got, chatgpt.FullCapabilities)
+	}
+}
+
+func TestAnalyseFallbackGetsSchemaInPrompt(t *testing.T) {
+	failure := chatgpttest.Response{Err: &chatgpt.StatusError{Provider: "primary", StatusCode: http.StatusBadGateway}}
+	primary := chatgpttest.NewClient(failure)
+	answer := chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav")
+	answer.Content = "Evo odgovora:\n" + answer.Content
+	backup := chatgpttest.NewClient(answer)
+	backup.Caps = &chatgpt.Capabilities{Tools: true}
+	p, _, _ := newPipeline(nil)
+	p.Client = &chatgpt.FallbackClient{Entries: []chatgpt.FallbackEntry{
+		{Client: primary, Model: "primary-model"},
+		{Client: backup, Model: "backup-model"},
+	}}
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{Model: "primary-model"}))
+	if a.Content.Shortresponse != "Dobar dan" {
+		t.Fatalf("unexpected answer %+v", a.Content)
+	}
+	if primary.Requests()[0].ResponseFormat.Value == nil {
+		t.Error("primary request has no response format")
+	}
+	req := backup.Requests()[0]
+	if req.ResponseFormat.Present {
+		t.Errorf("backup got response format %+v", req.ResponseFormat.Value)
+	}
+	if !strings.Contains(chatgpttest.MessagesJSON(req), "matches this JSON schema") {
+		t.Error("backup prompt lacks the JSON schema")
+	}
+}
+
+func TestFallbackClientSkipsUnsuitableModels(t *testing.T) {
+	failure := chatgpttest.Response{Err: &chatgpt.StatusError{Provider: "primary", StatusCode: http.StatusServiceUnavailable}}
+	noTools := chatgpttest.NewClient(chatgpttest.Answer("a", "b", "c"))
+	noTools.Caps = &chatgpt.Capabilities{JSONSchema: true, Vision: true}
+	backup := chatgpttest.NewClient(chatgpttest.Answer("a", "b", "c"))
+	client := &chatgpt.FallbackClient{Entries: []chatgpt.FallbackEntry{
+		{Client: chatgpttest.NewClient(failure), Model: "primary-model"},
+		{Client: noTools, Model: "no-tools-model"},
+		{Client: backup, Model: "backup-model"},
+	}}
+	params := openai.ChatCompletionNewParams{
+		Model: openai.F("primary-model"),
+		Tools: openai.F([]openai.ChatCompletionToolParam{{
+			Type:     openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(shared.FunctionDefinitionParam{Name: openai.F("search_google")}),
+		}}),
+	}
+
+	before := chatgpt.Metrics()
+	result, err := client.Complete(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Model != "backup-model" || noTools.Remaining() != 1 {
+		t.Errorf("served by %q, want backup-model without asking no-tools-model", result.Model)
+	}
+	after := chatgpt.Metrics()
+	if after.ServedBy["backup-model"] != before.ServedBy["backup-model"]+1 {
+		t.Errorf("metrics not updated: before %+v, after %+v", before, after)
+	}
+}
+
+func TestFallbackClientCountsOnlySuccesses(t *testing.T) {
+	serverError := &chatgpt.StatusError{Provider: "backup", StatusCode: http.StatusInternalServerError}
+	failure := chatgpttest.Response{Err: serverError}
+	unsuitable := chatgpttest.NewClient(chatgpttest.Answer("a", "b", "c"))
+	unsuitable.Caps = &chatgpt.Capabilities{}
+	client := &chatgpt.FallbackClient{Entries: []chatgpt.FallbackEntry{
+		{Client: chatgpttest.NewClient(failure), Model: "failing-model"},
+		{Client: chatgpttest.NewClient(failure), Model: "failing-backup-model"},
+		{Client: unsuitable, Model: "unsuitable-model"},
+	}}
+	params := openai.ChatCompletionNewParams{
+		Model:    openai.F("failing-model"),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessageParts(openai.ImagePart("data:image/png;base64,AAAA"))}),
+	}
+
+	// The last entry cannot read images, so the chain runs out of models
+	// after the second failure
+	before := chatgpt.Metrics()
+	if _, err := client.Complete(context.Background(), params); !errors.Is(err, serverError) {
+		t.Errorf("err = %v, want the last server error", err)
+	}
+	if unsuitable.Remaining() != 1 {
+		t.Error("asked a model without vision about an image")
+	}
+	after := chatgpt.Metrics()
+	if after.Failed != before.Failed+1 || after.Calls != before.Calls+1 {
+		t.Errorf("failure not counted: before %+v, after %+v", before, after)
+	}
+	for _, model := range []string{"failing-model", "failing-backup-model", "unsuitable-model"} {
+		if after.ServedBy[model] != before.ServedBy[model] {
+			t.Errorf("%s counted as serving a failed call", model)
+		}
+	}
+}
+
+func TestParseFallbacks(t *testing.T) {
+	chain, err := chatgpt.ParseFallbacks("gpt-4o, anthropic:claude-3-5-haiku-latest,openai-compatible:llama3.1@http://ollama:11434/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []chatgpt.ProviderConfig{
+		{Name: chatgpt.ProviderOpenAI, Model: "gpt-4o"},
+		{Name: chatgpt.ProviderAnthropic, Model: "claude-3-5-haiku-latest"},
+		{Name: chatgpt.ProviderOpenAICompatible, Model: "llama3.1", BaseURL: "http://ollama:11434/v1"},
+	}
+	if len(chain) != len(want) {
+		t.Fatalf("got %+v, want %+v", chain, want)
+	}
+	for i := range want {
+		if chain[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, chain[i], want[i])
+		}
+	}
+	if _, err := chatgpt.ParseFallbacks("anthropic:"); err == nil {
+		t.Error("accepted an entry without a model")
+	}
+}
@@ -8,6 +8,8 @@ require (
 	code.com/webpagescraper v0.0.0-00010101000000-000000000000
 	github.com/invopop/jsonschema v0.12.0
 	github.com/openai/openai-go v0.1.0-alpha.41
+	golang.org/x/image v0.23.0
+	golang.org/x/net v0.33.0
 )
 
 require (
@@ -38,7 +40,6 @@ require (
 	github.com/wasilibs/wazero-helpers v0.0.0-20240620070341-3dff1577cd52 // indirect
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
 	golang.org/x/exp v0.0.0-20240909161429-701f63a606c0 // indirect
-	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
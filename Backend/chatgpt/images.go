@@ -0,0 +1,182 @@
+package chatgpt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+
+	"github.com/openai/openai-go"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Limits for photos attached to a question
+const (
+	MaxImages     = 3
+	MaxImageBytes = 8 << 20
+	maxImageSide  = 1568
+	maxImagePixel = 40_000_000
+	imageQuality  = 85
+)
+
+var (
+	ErrTooManyImages    = fmt.Errorf("at most %d images can be attached", MaxImages)
+	ErrImageTooLarge    = fmt.Errorf("images must be smaller than %d MB", MaxImageBytes>>20)
+	ErrUnsupportedImage = errors.New("unsupported image, use JPEG, PNG, GIF or WebP")
+)
+
+// PrepareImage turns an uploaded photo into a JPEG that is safe to send to the
+// model: the EXIF orientation is applied, the image is scaled down so that its
+// longer side is at most maxImageSide pixels and it is re-encoded, which drops
+// all metadata including EXIF location data.
+func PrepareImage(data []byte) ([]byte, error) {
+	if len(data) > MaxImageBytes {
+		return nil, ErrImageTooLarge
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	// Refuse decompression bombs before allocating the pixels
+	if cfg.Width*cfg.Height > maxImagePixel {
+		return nil, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	img = applyOrientation(img, exifOrientation(data))
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > maxImageSide || h > maxImageSide {
+		if w >= h {
+			h = h * maxImageSide / w
+			w = maxImageSide
+		} else {
+			w = w * maxImageSide / h
+			h = maxImageSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: imageQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// imageDataURL embeds a prepared JPEG in a data URL for an image content part.
+func imageDataURL(jpegData []byte) string {
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpegData)
+}
+
+// questionMessage builds the user message for prompt, with every prepared
+// photo attached as a high-detail image part.
+func questionMessage(prompt string, images [][]byte) openai.ChatCompletionMessageParamUnion {
+	parts := []openai.ChatCompletionContentPartUnionParam{openai.TextPart("User prompt: " + prompt)}
+	for _, img := range images {
+		part := openai.ImagePart(imageDataURL(img))
+		part.ImageURL.Value.Detail = openai.F(openai.ChatCompletionContentPartImageImageURLDetailHigh)
+		parts = append(parts, part)
+	}
+	return openai.UserMessageParts(parts...)
+}
+
+// exifOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 if unknown.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			break // start of scan, no more metadata
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[0:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8 : entry+10])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and flips img so that it is displayed upright.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
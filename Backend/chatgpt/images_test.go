@@ -0,0 +1,161 @@
+package chatgpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 200, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withPNGSize rewrites the size in a PNG header without touching the pixels.
+func withPNGSize(data []byte, w, h uint32) []byte {
+	out := append([]byte(nil), data...)
+	ihdr := out[12:29] // chunk type and data
+	binary.BigEndian.PutUint32(ihdr[4:8], w)
+	binary.BigEndian.PutUint32(ihdr[8:12], h)
+	binary.BigEndian.PutUint32(out[29:33], crc32.ChecksumIEEE(ihdr))
+	return out
+}
+
+func TestPrepareImage(t *testing.T) {
+	img, err := PrepareImage(testPNG(t, 2000, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" {
+		t.Errorf("format %q, want jpeg", format)
+	}
+	if cfg.Width != maxImageSide || cfg.Height != 1000*maxImageSide/2000 {
+		t.Errorf("size %dx%d, want %dx%d", cfg.Width, cfg.Height, maxImageSide, 1000*maxImageSide/2000)
+	}
+}
+
+func TestPrepareImageRejects(t *testing.T) {
+	small := testPNG(t, 16, 16)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"too many bytes", make([]byte, MaxImageBytes+1), ErrImageTooLarge},
+		{"not an image", []byte("%PDF-1.7 račun za struju"), ErrUnsupportedImage},
+		{"truncated", small[:len(small)-20], ErrUnsupportedImage},
+		{"too many pixels", withPNGSize(small, 10000, 10000), ErrImageTooLarge},
+	}
+	for _, tt := range tests {
+		if _, err := PrepareImage(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestQuestionMessageAttachesImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(questionMessage(`{"received":"Šta piše na računu?"}`, [][]byte{buf.Bytes()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg struct {
+		Role    string `json:"role"`
+		Content []struct {
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			ImageURL struct {
+				URL    string `json:"url"`
+				Detail string `json:"detail"`
+			} `json:"image_url"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Role != "user" || len(msg.Content) != 2 {
+		t.Fatalf("message = %s", data)
+	}
+	if msg.Content[0].Type != "text" || !strings.Contains(msg.Content[0].Text, "Šta piše na računu?") {
+		t.Errorf("text part = %+v", msg.Content[0])
+	}
+	image := msg.Content[1]
+	if image.Type != "image_url" || image.ImageURL.Detail != "high" || image.ImageURL.URL != imageDataURL(buf.Bytes()) {
+		t.Errorf("image part = %+v", image)
+	}
+	if !strings.HasPrefix(image.ImageURL.URL, "data:image/jpeg;base64,") {
+		t.Errorf("image URL %.40q is not a JPEG data URL", image.ImageURL.URL)
+	}
+}
+
+func TestOpenAIModeratorScreensEveryImage(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input []struct {
+				Type string `json:"type"`
+			} `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		var types []string
+		for _, in := range body.Input {
+			types = append(types, in.Type)
+		}
+		requests = append(requests, strings.Join(types, "+"))
+		// Only the last photo is violent
+		violence := 0.1
+		if len(requests) == 3 {
+			violence = 0.9
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"modr-1","model":"omni-moderation-latest","results":[{"flagged":false,"category_scores":{"harassment":%v,"violence":%v}}]}`, 0.5-0.1*float64(len(requests)), violence)
+	}))
+	defer srv.Close()
+
+	m := &OpenAIModerator{client: openai.NewClient(option.WithBaseURL(srv.URL), option.WithAPIKey("test"), option.WithMaxRetries(0))}
+	photo := testPNG(t, 4, 4)
+	scores, err := m.Moderate(context.Background(), "Šta je na slikama?", [][]byte{photo, photo, photo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "text+image_url,image_url,image_url"; strings.Join(requests, ",") != want {
+		t.Errorf("requests %v, want %s", requests, want)
+	}
+	if scores["violence"] != 0.9 || scores["harassment"] != 0.4 {
+		t.Errorf("scores %v, want the highest score of every request", scores)
+	}
+}
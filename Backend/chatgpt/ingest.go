@@ -0,0 +1,497 @@
+package chatgpt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// chunkChars is the size of a knowledge base passage, about 400 tokens.
+const chunkChars = 1600
+
+// IngestStats describes an ingestion run.
+type IngestStats struct {
+	Documents int `json:"documents"`
+	Chunks    int `json:"chunks"`
+	// Embedded chunks were sent to the embedder, Reused ones were unchanged
+	// since the previous index and kept their vectors.
+	Embedded int `json:"embedded"`
+	Reused   int `json:"reused"`
+	// Skipped lists the documents left out, each followed by the reason.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// IngestDocuments reads the Markdown, HTML and PDF documents under dirs,
+// splits them into passages and embeds them into a new index. Passages of
+// previous, if given, that did not change keep their vectors.
+func IngestDocuments(ctx context.Context, embedder Embedder, dirs []string, previous *KnowledgeIndex) (*KnowledgeIndex, IngestStats, error) {
+	logger, err := initializeLogger()
+	if err != nil {
+		return nil, IngestStats{}, err
+	}
+
+	var stats IngestStats
+	var chunks []KnowledgeChunk
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			rel = filepath.ToSlash(rel)
+			title, text, err := readDocument(path)
+			if err != nil {
+				logger.Warn("Skipping document", "document", rel, "error", err)
+				stats.Skipped = append(stats.Skipped, rel+": "+err.Error())
+				return nil
+			}
+			docChunks := chunkDocument(rel, title, text)
+			if len(docChunks) == 0 {
+				logger.Warn("Skipping document without text", "document", rel)
+				stats.Skipped = append(stats.Skipped, rel+": no text")
+				return nil
+			}
+			stats.Documents++
+			chunks = append(chunks, docChunks...)
+			return nil
+		})
+		if err != nil {
+			return nil, stats, err
+		}
+	}
+
+	reuse := map[string][]float32{}
+	if previous != nil && previous.Model == embedder.Model() {
+		for _, c := range previous.Chunks {
+			reuse[embeddingInput(c)] = c.Vector
+		}
+	}
+	var pending []int
+	var inputs []string
+	for i, c := range chunks {
+		if v, ok := reuse[embeddingInput(c)]; ok {
+			chunks[i].Vector = v
+			stats.Reused++
+			continue
+		}
+		pending = append(pending, i)
+		inputs = append(inputs, embeddingInput(c))
+	}
+	if len(inputs) > 0 {
+		vectors, err := embedder.Embed(ctx, inputs)
+		if err != nil {
+			return nil, stats, fmt.Errorf("error embedding passages: %v", err)
+		}
+		for j, i := range pending {
+			chunks[i].Vector = normalize(vectors[j])
+		}
+	}
+	stats.Embedded = len(inputs)
+	stats.Chunks = len(chunks)
+
+	logger.Info("Knowledge base ingested",
+		"model", embedder.Model(),
+		"documents", stats.Documents,
+		"chunks", stats.Chunks,
+		"embedded", stats.Embedded,
+		"reused", stats.Reused,
+		"skipped", len(stats.Skipped))
+	return &KnowledgeIndex{Model: embedder.Model(), Created: time.Now(), Chunks: chunks}, stats, nil
+}
+
+// embeddingInput is the text embedded for a chunk. The title and section
+// give passages that do not repeat the topic their context.
+func embeddingInput(c KnowledgeChunk) string {
+	return c.Title + "\n" + c.Section + "\n" + c.Text
+}
+
+// readDocument returns the title and the text of a document, with headings
+// written as Markdown headings.
+func readDocument(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".txt":
+		return markdownTitle(string(data), name), stripFrontMatter(string(data)), nil
+	case ".html", ".htm":
+		title, text := htmlText(data)
+		if title == "" {
+			title = markdownTitle(text, name)
+		}
+		return title, text, nil
+	case ".pdf":
+		text, err := pdfText(data)
+		if err == nil && !mostlyLetters(text) {
+			err = fmt.Errorf("extracted text is mostly not letters, convert the PDF to Markdown")
+		}
+		return name, text, err
+	default:
+		return "", "", fmt.Errorf("unsupported document type %s", filepath.Ext(path))
+	}
+}
+
+func stripFrontMatter(text string) string {
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		if _, body, ok := strings.Cut(rest, "\n---\n"); ok {
+			return body
+		}
+	}
+	return text
+}
+
+// markdownTitle returns the first level one heading of text, or name.
+func markdownTitle(text, name string) string {
+	for _, line := range strings.Split(stripFrontMatter(text), "\n") {
+		if title, ok := strings.CutPrefix(strings.TrimSpace(line), "# "); ok {
+			return strings.TrimSpace(title)
+		}
+	}
+	return name
+}
+
+// chunkDocument splits the text of a document into passages of about
+// chunkChars characters along paragraphs. Each passage remembers the
+// heading it is under.
+func chunkDocument(doc, title, text string) []KnowledgeChunk {
+	var chunks []KnowledgeChunk
+	section := ""
+	var cur strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			chunks = append(chunks, KnowledgeChunk{Document: doc, Title: title, Section: section, Text: s})
+		}
+		cur.Reset()
+	}
+	var para []string
+	addParagraph := func() {
+		text := strings.Join(para, " ")
+		para = nil
+		for _, piece := range splitLong(text, chunkChars) {
+			if cur.Len() > 0 && cur.Len()+len(piece) > chunkChars {
+				flush()
+			}
+			if cur.Len() > 0 {
+				cur.WriteString("\n\n")
+			}
+			cur.WriteString(piece)
+		}
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#"):
+			addParagraph()
+			flush()
+			section = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		case line == "":
+			addParagraph()
+		default:
+			para = append(para, line)
+		}
+	}
+	addParagraph()
+	flush()
+	return chunks
+}
+
+// splitLong splits text into pieces of at most limit bytes, preferring
+// sentence ends and then spaces.
+func splitLong(text string, limit int) []string {
+	var pieces []string
+	for len(text) > limit {
+		cut := strings.LastIndex(text[:limit], ". ")
+		if cut > 0 {
+			cut++
+		} else if cut = strings.LastIndex(text[:limit], " "); cut <= 0 {
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+		pieces = append(pieces, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+	if text != "" {
+		pieces = append(pieces, text)
+	}
+	return pieces
+}
+
+// htmlText returns the title and text of an HTML document, leaving out
+// scripts, styles and navigation. Headings become Markdown headings.
+func htmlText(data []byte) (string, string) {
+	var title string
+	var out strings.Builder
+	skip := 0
+	inTitle := false
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return title, out.String()
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch tag {
+			case "script", "style", "nav", "header", "footer", "noscript":
+				if tt == html.StartTagToken {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			case "title":
+				inTitle = tt == html.StartTagToken
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				out.WriteString("\n\n")
+				if tt == html.StartTagToken {
+					out.WriteString(strings.Repeat("#", int(tag[1]-'0')) + " ")
+				}
+			case "p", "div", "li", "tr", "br", "section", "article", "table", "ul", "ol":
+				out.WriteString("\n\n")
+			}
+		case html.TextToken:
+			text := strings.Join(strings.Fields(string(z.Text())), " ")
+			if inTitle {
+				title = text
+			} else if skip == 0 && text != "" {
+				out.WriteString(text + " ")
+			}
+		}
+	}
+}
+
+// pdfText extracts the text drawn by the content streams of a PDF. It
+// handles uncompressed and Flate compressed streams with simple fonts;
+// documents with embedded CID fonts yield glyph numbers instead of text and
+// are better converted to Markdown before ingestion.
+func pdfText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", fmt.Errorf("not a PDF file")
+	}
+	var out strings.Builder
+	rest := data
+	for {
+		i := bytes.Index(rest, []byte("stream"))
+		if i < 0 {
+			break
+		}
+		dict := rest[:i]
+		if j := bytes.LastIndex(dict, []byte("<<")); j >= 0 {
+			dict = dict[j:]
+		}
+		start := i + len("stream")
+		if bytes.HasPrefix(rest[start:], []byte("\r\n")) {
+			start += 2
+		} else if bytes.HasPrefix(rest[start:], []byte("\n")) {
+			start++
+		}
+		end := bytes.Index(rest[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		content := rest[start : start+end]
+		rest = rest[start+end+len("endstream"):]
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, err = io.ReadAll(r)
+			if err != nil && len(content) == 0 {
+				continue
+			}
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			// Images and other encodings hold no text
+			continue
+		}
+		if bytes.Contains(dict, []byte("/Length1")) || bytes.Contains(dict, []byte("/XRef")) || bytes.Contains(dict, []byte("/ObjStm")) {
+			continue
+		}
+		pdfContentText(content, &out)
+	}
+	return out.String(), nil
+}
+
+// pdfContentText writes the text shown by the operators of a content
+// stream. Line moves become new lines and wide gaps in TJ arrays spaces.
+func pdfContentText(content []byte, out *strings.Builder) {
+	var strs []string
+	var nums []float64
+	inText := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0:
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := pdfLiteralString(content[i:])
+			strs = append(strs, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			strs = append(strs, pdfHexString(content[i+1:i+end]))
+			i += end + 1
+		case c == '[' || c == ']' || c == '<' || c == '>' || c == '{' || c == '}':
+			i++
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(content) && (content[j] == '.' || (content[j] >= '0' && content[j] <= '9')) {
+				j++
+			}
+			var f float64
+			fmt.Sscan(string(content[i:j]), &f)
+			nums = append(nums, f)
+			// A wide negative gap in a TJ array separates words
+			if f < -200 && inText && len(strs) > 0 {
+				strs = append(strs, " ")
+			}
+			i = j
+		default:
+			j := i + 1
+			for j < len(content) && !bytes.ContainsRune([]byte(" \n\r\t\f()<>[]{}/%"), rune(content[j])) {
+				j++
+			}
+			if c == '/' {
+				i = j
+				continue
+			}
+			switch op := string(content[i:j]); op {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				out.WriteString("\n")
+			case "Tj", "TJ":
+				out.WriteString(strings.Join(strs, ""))
+			case "'", "\"":
+				out.WriteString("\n" + strings.Join(strs, ""))
+			case "T*":
+				out.WriteString("\n")
+			case "Td", "TD":
+				if len(nums) >= 2 && nums[len(nums)-1] != 0 {
+					out.WriteString("\n")
+				}
+			}
+			strs, nums = nil, nil
+			i = j
+		}
+	}
+}
+
+// pdfLiteralString decodes a (string) with balanced parentheses and
+// escapes, returning it and the number of bytes read.
+func pdfLiteralString(b []byte) (string, int) {
+	var s []byte
+	depth := 0
+	i := 0
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '\\' && i+1 < len(b):
+			i++
+			switch e := b[i]; e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r', 't', 'b', 'f':
+				s = append(s, ' ')
+			case '\r', '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v, n := 0, 0
+					for ; n < 3 && i+n < len(b) && b[i+n] >= '0' && b[i+n] <= '7'; n++ {
+						v = v*8 + int(b[i+n]-'0')
+					}
+					s = append(s, byte(v))
+					i += n - 1
+				} else {
+					s = append(s, e)
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				s = append(s, c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return pdfDecode(s), i + 1
+			}
+			s = append(s, c)
+		default:
+			s = append(s, c)
+		}
+	}
+	return pdfDecode(s), i
+}
+
+func pdfHexString(b []byte) string {
+	var s []byte
+	var digits []byte
+	for _, c := range b {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	for i := 0; i < len(digits); i += 2 {
+		var v byte
+		fmt.Sscanf(string(digits[i:i+2]), "%02x", &v)
+		s = append(s, v)
+	}
+	return pdfDecode(s)
+}
+
+// mostlyLetters reports whether at least half of the visible characters of
+// text are letters. Text extracted without a font's encoding is not.
+func mostlyLetters(text string) bool {
+	letters, visible := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		visible++
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	return letters*2 >= visible
+}
+
+// pdfDecode reads string bytes as UTF-8 if they are valid and as Latin-1
+// otherwise.
+func pdfDecode(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
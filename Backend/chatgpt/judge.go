@@ -0,0 +1,89 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+// Verdict is an LLM judge's assessment of one answer.
+type Verdict struct {
+	// Score is 1 (useless or harmful) to 5 (excellent).
+	Score  int    `json:"score"`
+	Reason string `json:"reason"`
+}
+
+const judgePrompt = "You review answers of an assistant for elderly people in Bosnia and Herzegovina. " +
+	"Rate the answer to the question from 1 to 5. A good answer is correct, simple to understand, " +
+	"answers exactly what was asked, uses the requested language and gives the correct local " +
+	"emergency numbers (police 122, fire department 123, ambulance 124) when they are relevant. " +
+	"Give a short reason in English."
+
+// Judge asks model to score an answer to a question. The answer is the
+// chatResponse JSON produced by ChatGPTAnalyse.
+func Judge(ctx context.Context, apikey, model, question, answer string) (Verdict, error) {
+	return JudgeWith(ctx, NewOpenAIClient(apikey), model, question, answer)
+}
+
+// JudgeWith is Judge with the completion client to ask.
+func JudgeWith(ctx context.Context, client CompletionClient, model, question, answer string) (Verdict, error) {
+	logger, err := initializeLogger()
+	if err != nil {
+		return Verdict{}, err
+	}
+	if model == "" {
+		model = DefaultModel
+	}
+
+	params := openai.ChatCompletionNewParams{
+		ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+			shared.ResponseFormatJSONSchemaParam{
+				Type: openai.F(shared.ResponseFormatJSONSchemaTypeJSONSchema),
+				JSONSchema: openai.F(shared.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   openai.F("Verdict"),
+					Schema: openai.F(GenerateSchema[Verdict]()),
+					Strict: openai.Bool(true),
+				}),
+			},
+		),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(judgePrompt),
+			openai.UserMessage(fmt.Sprintf("Question:\n%s\n\nAnswer:\n%s", question, answer)),
+		}),
+		Model: openai.F(model),
+	}
+
+	startTime := time.Now()
+	result, err := JudgeRetry.do(ctx, logger, "judge", nil, func() (*openai.ChatCompletion, error) {
+		return MakeChatCompletionCall(client, ctx, &params, logger)
+	})
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	v, err := parseVerdict(result.Choices[0].Message.Content)
+	if err != nil {
+		return Verdict{}, err
+	}
+	logger.Info("Answer judged",
+		"model", model,
+		"score", v.Score,
+		"duration_ms", time.Since(startTime).Milliseconds())
+	return v, nil
+}
+
+// parseVerdict reads the judge's JSON reply and checks the score range.
+func parseVerdict(content string) (Verdict, error) {
+	var v Verdict
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return Verdict{}, fmt.Errorf("invalid verdict: %v", err)
+	}
+	if v.Score < 1 || v.Score > 5 {
+		return Verdict{}, fmt.Errorf("invalid verdict: score %d is not between 1 and 5", v.Score)
+	}
+	return v, nil
+}
@@ -0,0 +1,46 @@
+package chatgpt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+func TestJudgeParsesVerdict(t *testing.T) {
+	tests := []struct {
+		content string
+		want    chatgpt.Verdict
+		wantErr bool
+	}{
+		{`{"score":4,"reason":"Correct and simple."}`, chatgpt.Verdict{Score: 4, Reason: "Correct and simple."}, false},
+		{`{"score":1,"reason":"Gives 911."}`, chatgpt.Verdict{Score: 1, Reason: "Gives 911."}, false},
+		{`{"score":0,"reason":"No score."}`, chatgpt.Verdict{}, true},
+		{`{"score":7,"reason":"Out of range."}`, chatgpt.Verdict{}, true},
+		{`{"score":"4","reason":"Score as a string."}`, chatgpt.Verdict{}, true},
+		{`{"score":4,"reason":"Cut off`, chatgpt.Verdict{}, true},
+		{`The answer deserves a 4.`, chatgpt.Verdict{}, true},
+		{``, chatgpt.Verdict{}, true},
+	}
+	for _, tt := range tests {
+		client := chatgpttest.NewClient(chatgpttest.Response{Content: tt.content})
+		got, err := chatgpt.JudgeWith(context.Background(), client, "", "Kada je penzija?", `{"content":{}}`)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("verdict %q: got %+v, %v; want %+v, error %v", tt.content, got, err, tt.want, tt.wantErr)
+		}
+		requests := client.Requests()
+		if len(requests) != 1 || requests[0].Model.Value != chatgpt.DefaultModel {
+			t.Errorf("verdict %q: judge requests %+v", tt.content, requests)
+		}
+	}
+}
+
+func TestJudgeReportsClientErrors(t *testing.T) {
+	failure := errors.New("503 Service Unavailable")
+	client := chatgpttest.NewClient(chatgpttest.Response{Err: failure})
+	if _, err := chatgpt.JudgeWith(context.Background(), client, "gpt-4o", "Kada je penzija?", `{"content":{}}`); !errors.Is(err, failure) {
+		t.Errorf("err = %v, want %v", err, failure)
+	}
+}
@@ -0,0 +1,170 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KnowledgeChunk is a passage of a vetted document in the knowledge base.
+type KnowledgeChunk struct {
+	// Document is the path of the document relative to the ingested
+	// directory, Title its title and Section the heading above the passage.
+	Document string
+	Title    string
+	Section  string
+	Text     string
+	Vector   []float32
+}
+
+// KnowledgeIndex is an on-disk vector index of the knowledge base. Search
+// compares the query with every chunk, which is fast enough for the few
+// thousand passages of a curated collection.
+type KnowledgeIndex struct {
+	Model   string
+	Created time.Time
+	Chunks  []KnowledgeChunk
+}
+
+// KnowledgeResult is a chunk found by Search and its cosine similarity to
+// the query.
+type KnowledgeResult struct {
+	KnowledgeChunk
+	Score float32
+}
+
+// Knowledge and KnowledgeEmbedder back the search_knowledge_base tool. main
+// loads the index from KNOWLEDGE_INDEX; without it the tool is not offered.
+var (
+	Knowledge         *KnowledgeIndex
+	KnowledgeEmbedder Embedder
+)
+
+// KnowledgeResults is the number of passages search_knowledge_base returns.
+const KnowledgeResults = 4
+
+// LoadKnowledgeIndex reads an index written by Save.
+func LoadKnowledgeIndex(path string) (*KnowledgeIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var idx KnowledgeIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("error reading knowledge index %s: %v", path, err)
+	}
+	return &idx, nil
+}
+
+// Save writes the index to path, replacing it atomically.
+func (idx *KnowledgeIndex) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Documents returns the number of distinct documents in the index.
+func (idx *KnowledgeIndex) Documents() int {
+	docs := map[string]bool{}
+	for _, c := range idx.Chunks {
+		docs[c.Document] = true
+	}
+	return len(docs)
+}
+
+// Search returns the k chunks most similar to the query vector.
+func (idx *KnowledgeIndex) Search(query []float32, k int) []KnowledgeResult {
+	query = normalize(append([]float32(nil), query...))
+	results := make([]KnowledgeResult, 0, len(idx.Chunks))
+	for _, c := range idx.Chunks {
+		if len(c.Vector) != len(query) {
+			continue
+		}
+		var score float32
+		for i, x := range c.Vector {
+			score += x * query[i]
+		}
+		if score > 0 {
+			results = append(results, KnowledgeResult{KnowledgeChunk: c, Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// SearchKnowledge embeds the query with embedder and searches idx.
+func SearchKnowledge(ctx context.Context, idx *KnowledgeIndex, embedder Embedder, query string, k int) ([]KnowledgeResult, error) {
+	if idx == nil || embedder == nil {
+		return nil, errors.New("the knowledge base is not available")
+	}
+	if embedder.Model() != idx.Model {
+		return nil, fmt.Errorf("the knowledge index was built with %s, not %s", idx.Model, embedder.Model())
+	}
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	return idx.Search(vectors[0], k), nil
+}
+
+// citation names the document and section a passage comes from.
+func (c KnowledgeChunk) citation() string {
+	s := c.Title + " (" + c.Document
+	if c.Section != "" && c.Section != c.Title {
+		s += ", " + c.Section
+	}
+	return s + ")"
+}
+
+type knowledgeArgs struct {
+	Query string `json:"query" jsonschema_description:"What to look up, in Bosnian"`
+}
+
+var knowledgeTool = FuncTool[knowledgeArgs]{
+	ToolName:        "search_knowledge_base",
+	ToolDescription: "Search our vetted guides on pensions, health insurance, social benefits and similar topics. Returns passages with the document they come from",
+	Ready: func() bool {
+		return Knowledge != nil && len(Knowledge.Chunks) > 0 &&
+			KnowledgeEmbedder != nil && KnowledgeEmbedder.Model() == Knowledge.Model
+	},
+	Run: func(ctx context.Context, env ToolEnv, args knowledgeArgs) (string, error) {
+		if strings.TrimSpace(args.Query) == "" {
+			return "", fmt.Errorf("'query' must not be empty")
+		}
+		results, err := SearchKnowledge(ctx, Knowledge, KnowledgeEmbedder, args.Query, KnowledgeResults)
+		if err != nil {
+			return "", err
+		}
+		env.Logger.Info("Knowledge base searched",
+			"query", args.Query,
+			"results", len(results))
+		if len(results) == 0 {
+			return "No passages found in the knowledge base.", nil
+		}
+		var out strings.Builder
+		for i, r := range results {
+			fmt.Fprintf(&out, "<PASSAGE %d>\nSource: %s\nScore: %.2f\n%s\n</PASSAGE %d>\n", i+1, r.citation(), r.Score, r.Text, i+1)
+		}
+		return out.String(), nil
+	},
+}
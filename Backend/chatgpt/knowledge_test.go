@@ -0,0 +1,149 @@
+package chatgpt_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pdf returns a minimal PDF whose page draws the given content stream.
+func pdf(content []byte, flate bool) []byte {
+	filter := ""
+	if flate {
+		var b bytes.Buffer
+		w := zlib.NewWriter(&b)
+		w.Write(content)
+		w.Close()
+		content, filter = b.Bytes(), " /Filter /FlateDecode"
+	}
+	return []byte(fmt.Sprintf("%%PDF-1.4\n1 0 obj\n<< /Length %d%s >>\nstream\n%s\nendstream\nendobj\n%%%%EOF\n", len(content), filter, content))
+}
+
+func knowledgeDocs(t *testing.T) string {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "penzije.md"), []byte("---\nsource: pio.ba\n---\n# Penzije u FBiH\n\n"+
+		"## Usklađivanje penzija\n\nPenzije se usklađuju svake godine od 1. januara prema rastu plata i cijena.\n\n"+
+		"## Najniža penzija\n\nNajniža penzija se isplaćuje korisnicima sa kratkim stažom.\n"))
+	writeFile(t, filepath.Join(dir, "zdravstvo", "kartica.html"), []byte("<html><head><title>Zdravstvena kartica</title><script>var x = 1;</script></head>"+
+		"<body><nav>Početna</nav><h2>Ovjera kartice</h2><p>Zdravstvena knjižica se ovjerava u filijali zavoda zdravstvenog osiguranja.</p></body></html>"))
+	writeFile(t, filepath.Join(dir, "socijalna.pdf"), pdf([]byte("BT /F1 12 Tf 72 700 Td (Socijalna pomo\\143 \\(novac\\) za starije) Tj 0 -14 Td [(se tra) 10 (ži u centru) -300 (za socijalni rad)] TJ ET"), true))
+	// Identity-H fonts show glyph numbers, which read as control characters
+	writeFile(t, filepath.Join(dir, "cid.pdf"), pdf([]byte("BT /F2 12 Tf 72 700 Td <002F0044004E0052001D0003005600E4> Tj ET"), true))
+	writeFile(t, filepath.Join(dir, "slika.png"), []byte{0x89, 'P', 'N', 'G'})
+	return dir
+}
+
+func TestIngestAndSearchKnowledge(t *testing.T) {
+	dir := knowledgeDocs(t)
+	embedder := chatgpt.HashEmbedder{Dimensions: 256}
+
+	idx, stats, err := chatgpt.IngestDocuments(context.Background(), embedder, []string{dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Documents != 3 || stats.Chunks != 4 || stats.Embedded != 4 || len(stats.Skipped) != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if skipped := strings.Join(stats.Skipped, "\n"); !strings.Contains(skipped, "cid.pdf: extracted text is mostly not letters") {
+		t.Errorf("skipped = %s", skipped)
+	}
+
+	path := filepath.Join(t.TempDir(), "knowledge.idx")
+	if err := idx.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := chatgpt.LoadKnowledgeIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query, document, section, text string
+	}{
+		{"kada se usklađuju penzije", "penzije.md", "Usklađivanje penzija", "svake godine"},
+		{"gdje se ovjerava zdravstvena knjižica", "zdravstvo/kartica.html", "Ovjera kartice", "filijali"},
+		{"socijalna pomoc za starije", "socijalna.pdf", "", "pomoc (novac) za starije se traži u centru za socijalni rad"},
+	}
+	for _, tt := range tests {
+		results, err := chatgpt.SearchKnowledge(context.Background(), loaded, embedder, tt.query, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) == 0 {
+			t.Errorf("%s: no results", tt.query)
+			continue
+		}
+		top := results[0]
+		if top.Document != tt.document || top.Section != tt.section || !strings.Contains(top.Text, tt.text) {
+			t.Errorf("%s: top result %s / %s: %q", tt.query, top.Document, top.Section, top.Text)
+		}
+	}
+
+	if _, err := chatgpt.SearchKnowledge(context.Background(), loaded, chatgpt.HashEmbedder{Dimensions: 64}, "penzije", 2); err == nil {
+		t.Error("searched an index with another embedding model")
+	}
+
+	_, stats, err = chatgpt.IngestDocuments(context.Background(), embedder, []string{dir}, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reused != 4 || stats.Embedded != 0 {
+		t.Errorf("re-ingesting unchanged documents: %+v", stats)
+	}
+}
+
+func TestAnalyseSearchesKnowledgeBase(t *testing.T) {
+	embedder := chatgpt.HashEmbedder{Dimensions: 256}
+	idx, _, err := chatgpt.IngestDocuments(context.Background(), embedder, []string{knowledgeDocs(t)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := chatgpttest.NewClient(chatgpttest.Response{Content: ""}, chatgpttest.Answer("a", "b", "c"))
+	p, _, _ := newPipeline(client)
+	if names := strings.Join(toolNames(t, p, client, chatgpt.Options{}), ","); strings.Contains(names, "search_knowledge_base") {
+		t.Errorf("search_knowledge_base offered without an index: %s", names)
+	}
+
+	defer func() { chatgpt.Knowledge, chatgpt.KnowledgeEmbedder = nil, nil }()
+	chatgpt.Knowledge, chatgpt.KnowledgeEmbedder = idx, embedder
+
+	client = chatgpttest.NewClient(
+		chatgpttest.Response{ToolCalls: []chatgpttest.ToolCall{
+			{ID: "call_1", Name: "search_knowledge_base", Arguments: `{"query":"usklađivanje penzija"}`},
+		}},
+		chatgpttest.Answer("Penzije se usklađuju od 1. januara.", "Od 1. januara", "Penzije"),
+	)
+	p, _, _ = newPipeline(client)
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Kada rastu penzije?"}`, chatgpt.Options{}))
+
+	if a.InternetSearch {
+		t.Error("internet_search is set for a knowledge base search")
+	}
+	first := chatgpttest.MessagesJSON(client.Requests()[0])
+	if !strings.Contains(first, "search_knowledge_base function") {
+		t.Error("system prompt does not mention the knowledge base")
+	}
+	second := chatgpttest.MessagesJSON(client.Requests()[1])
+	if !strings.Contains(second, "Penzije u FBiH (penzije.md, Usklađivanje penzija)") {
+		t.Errorf("second request does not cite the guide:\n%s", second)
+	}
+}
@@ -0,0 +1,202 @@
+package chatgpt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// languageProfile holds the prompt template and date names of an answer
+// language.
+type languageProfile struct {
+	// Instruction opens the system prompt and fixes language, script and
+	// vocabulary of the answer.
+	Instruction string
+	Months      [12]string
+	Weekdays    [7]string
+}
+
+// DefaultLanguage is used when a question's language cannot be told.
+const DefaultLanguage = "bs"
+
+// Languages are the supported answer languages by code. sr is Serbian in
+// the Latin script, sr-Cyrl in the Cyrillic script.
+var Languages = map[string]languageProfile{
+	"bs": {
+		Instruction: "You are an intelligent assistant that responds exclusively in Bosnian, in the Latin script with ijekavian forms " +
+			"(e.g., 'mlijeko', 'vrijeme', 'ljekar') and Bosnian words (e.g., 'historija', 'hljeb', 'sedmica'). " +
+			"Use Bosnian month names (e.g., 'juni' instead of 'lipanj' or 'jun'). ",
+		Months:   [12]string{"januar", "februar", "mart", "april", "maj", "juni", "juli", "august", "septembar", "oktobar", "novembar", "decembar"},
+		Weekdays: [7]string{"nedjelja", "ponedjeljak", "utorak", "srijeda", "četvrtak", "petak", "subota"},
+	},
+	"hr": {
+		Instruction: "You are an intelligent assistant that responds exclusively in Croatian, with Croatian words " +
+			"(e.g., 'liječnik', 'kruh', 'tjedan', 'tisuća') and Croatian month names (e.g., 'lipanj' instead of 'juni'). ",
+		Months:   [12]string{"siječnja", "veljače", "ožujka", "travnja", "svibnja", "lipnja", "srpnja", "kolovoza", "rujna", "listopada", "studenoga", "prosinca"},
+		Weekdays: [7]string{"nedjelja", "ponedjeljak", "utorak", "srijeda", "četvrtak", "petak", "subota"},
+	},
+	"sr": {
+		Instruction: "You are an intelligent assistant that responds exclusively in Serbian, in the Latin script. " +
+			"Use ijekavian forms as in Republika Srpska (e.g., 'mlijeko', 'vrijeme'), or ekavian forms if the user writes ekavian, " +
+			"and Serbian month names (e.g., 'jun', 'avgust'). ",
+		Months:   [12]string{"januar", "februar", "mart", "april", "maj", "jun", "jul", "avgust", "septembar", "oktobar", "novembar", "decembar"},
+		Weekdays: [7]string{"nedjelja", "ponedjeljak", "utorak", "srijeda", "četvrtak", "petak", "subota"},
+	},
+	"sr-Cyrl": {
+		Instruction: "You are an intelligent assistant that responds exclusively in Serbian, written in the Cyrillic script (e.g., 'Добар дан'). " +
+			"Never switch to the Latin script, except for links, e-mail addresses and names of websites. " +
+			"Use ijekavian forms as in Republika Srpska, or ekavian forms if the user writes ekavian, and Serbian month names (e.g., 'јун'). ",
+		Months:   [12]string{"јануар", "фебруар", "март", "април", "мај", "јун", "јул", "август", "септембар", "октобар", "новембар", "децембар"},
+		Weekdays: [7]string{"недјеља", "понедјељак", "уторак", "сриједа", "четвртак", "петак", "субота"},
+	},
+	"en": {
+		Instruction: "You are an intelligent assistant that responds exclusively in plain, simple English. " +
+			"The user may be a relative living abroad: when you name local institutions, documents or offices, " +
+			"add their local name in parentheses (e.g., 'pension fund (PIO/MIO)'). ",
+		Months:   [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		Weekdays: [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+	},
+}
+
+// NormalizeLanguage returns the supported language code for a code sent
+// by a client, e.g. "sr-cyrl" or "sr_Cyrl" for sr-Cyrl and "en-GB" for en.
+// It reports false for languages we do not answer in.
+func NormalizeLanguage(code string) (string, bool) {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "_", "-"))
+	switch code {
+	case "sr-cyrl", "sr-cyrl-ba", "sr-cyrl-rs":
+		return "sr-Cyrl", true
+	case "sr-latn", "sr-latn-ba", "sr-latn-rs":
+		return "sr", true
+	}
+	base, _, _ := strings.Cut(code, "-")
+	if _, ok := Languages[base]; ok && base != "" {
+		return base, true
+	}
+	return "", false
+}
+
+// FormatDate formats t in the given language, e.g. "ponedjeljak, 19. oktobar
+// 2026." in Bosnian and "Monday, 19 October 2026" in English.
+func FormatDate(t time.Time, language string) string {
+	profile, ok := Languages[language]
+	if !ok {
+		language, profile = DefaultLanguage, Languages[DefaultLanguage]
+	}
+	weekday, month := profile.Weekdays[t.Weekday()], profile.Months[t.Month()-1]
+	if language == "en" {
+		return fmt.Sprintf("%s, %d %s %d", weekday, t.Day(), month, t.Year())
+	}
+	return fmt.Sprintf("%s, %d. %s %d.", weekday, t.Day(), month, t.Year())
+}
+
+// Words that tell the languages apart. English is told from the South
+// Slavic languages by its function words. Croatian vocabulary is weighed
+// against words Croatian does not use, and ekavian forms against ijekavian
+// ones. Prefixes also match inflected forms, e.g. "liječnik" matches
+// "liječnika". A prefix ending in a space only matches the whole word, for
+// ekavian forms whose inflections are ijekavian too, such as vreme and
+// vremena.
+var (
+	englishWords = wordSet("the an is are was what how when where which who why my your can could does should would will " +
+		"have has there this that it please with for from about and or not you our their")
+	slavicWords = wordSet("je li da na se za od kako koji koja koje šta što sta sto gdje gde kada kad ima mogu može moze " +
+		"sam su nije ne koliko treba jer ako ili sa iz bi bih mi vi oni")
+	croatianPrefixes = []string{"što", "tko", "liječni", "ljekarn", "mirovin", "umirovljen", "tjed", "kruh", "tisuć",
+		"sveučiliš", "kazališ", "zrakoplov", "obitelj", "povijes", "siječ", "veljač", "ožuj", "travan", "travnj", "svib",
+		"lipan", "lipnj", "srpan", "srpnj", "kolovoz", "rujan", "rujn", "listopad", "studeni", "studenog", "prosin"}
+	nonCroatianPrefixes = []string{"šta", "ljekara", "ljekaru", "ljekarom", "ljekari", "lekar", "hljeb", "hleb",
+		"historij", "istorij", "hiljad", "penzij", "sedmic", "opštin", "kahv", "sahat", "juni", "juli", "avgust"}
+	ekavianPrefixes = []string{"lekar", "lekov", "mlek", "dete ", "detet", "deca ", "dece ", "vreme ", "gde", "hleb", "nedelj", "posle",
+		"pesm", "ponedeljak", "sreda", "sredu", "hteo", "htela", "lepo"}
+	ijekavianPrefixes = []string{"ljekar", "lijek", "mlijek", "dijete", "djeca", "djece", "vrijeme", "gdje", "hljeb",
+		"nedjelj", "poslije", "prije", "rijek", "pjesm", "ponedjeljak", "srijed", "htio", "htjel", "lijep"}
+)
+
+func wordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// countPrefixes returns the number of words that start with one of the
+// prefixes.
+func countPrefixes(words []string, prefixes []string) int {
+	n := 0
+	for _, w := range words {
+		for _, p := range prefixes {
+			if word, whole := strings.CutSuffix(p, " "); whole && w == word || !whole && strings.HasPrefix(w, p) {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// DetectLanguage guesses the language of a question: sr-Cyrl for text
+// mostly in the Cyrillic script, en for English, hr for Croatian
+// vocabulary, sr for ekavian forms and bs otherwise.
+func DetectLanguage(text string) string {
+	var cyrillic, latin int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	if cyrillic > latin {
+		return "sr-Cyrl"
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	english, slavic := 0, 0
+	for _, w := range words {
+		if englishWords[w] {
+			english++
+		}
+		if slavicWords[w] {
+			slavic++
+		}
+	}
+	if english > slavic {
+		return "en"
+	}
+
+	croatian := countPrefixes(words, croatianPrefixes)
+	ekavian := countPrefixes(words, ekavianPrefixes)
+	switch {
+	case croatian > countPrefixes(words, nonCroatianPrefixes) && croatian >= ekavian:
+		return "hr"
+	case ekavian > countPrefixes(words, ijekavianPrefixes):
+		return "sr"
+	}
+	return DefaultLanguage
+}
+
+// ResolveLanguage returns the supported code of the requested language,
+// or the language detected in the question when none was requested.
+// Unsupported codes resolve to DefaultLanguage.
+func ResolveLanguage(requested, question string) string {
+	code, _ := resolveLanguage(requested, question)
+	return code
+}
+
+// resolveLanguage is ResolveLanguage that also reports whether the
+// language was detected.
+func resolveLanguage(requested, question string) (code string, detected bool) {
+	if requested == "" {
+		return DetectLanguage(question), true
+	}
+	if code, ok := NormalizeLanguage(requested); ok {
+		return code, false
+	}
+	return DefaultLanguage, false
+}
@@ -0,0 +1,100 @@
+package chatgpt_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"Kada je sljedeći praznik?", "bs"},
+		{"Gdje mogu naći ljekara u nedjelju?", "bs"},
+		{"Koliko košta kruh i gdje je najbliža ljekarna?", "hr"},
+		{"Tko mi može objasniti mirovinu?", "hr"},
+		{"Gde mogu da kupim lekove posle podne?", "sr"},
+		{"Kakvo će biti vreme sutra u Novom Sadu?", "sr"},
+		{"Kakva je vremenska prognoza za Sarajevo?", "bs"},
+		{"Koliko vremena treba za novi pasoš?", "bs"},
+		{"Kada se isplaćuje penzija za decembar?", "bs"},
+		{"Када полази аутобус за Бањалуку?", "sr-Cyrl"},
+		{"What documents does my mother need for her pension?", "en"},
+		{"Is Brufen ibuprofen?", "en"},
+		{"", "bs"},
+	}
+	for _, tt := range tests {
+		if got := chatgpt.DetectLanguage(tt.text); got != tt.want {
+			t.Errorf("DetectLanguage(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := []struct {
+		code, want string
+		ok         bool
+	}{
+		{"bs", "bs", true},
+		{"HR", "hr", true},
+		{"sr-Latn", "sr", true},
+		{"sr_cyrl", "sr-Cyrl", true},
+		{"en-GB", "en", true},
+		{"de", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := chatgpt.NormalizeLanguage(tt.code)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeLanguage(%q) = %q, %v, want %q, %v", tt.code, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	day := time.Date(2026, time.June, 15, 12, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"bs":      "ponedjeljak, 15. juni 2026.",
+		"hr":      "ponedjeljak, 15. lipnja 2026.",
+		"sr":      "ponedjeljak, 15. jun 2026.",
+		"sr-Cyrl": "понедјељак, 15. јун 2026.",
+		"en":      "Monday, 15 June 2026",
+		"xx":      "ponedjeljak, 15. juni 2026.",
+	}
+	for language, want := range tests {
+		if got := chatgpt.FormatDate(day, language); got != want {
+			t.Errorf("FormatDate(%s) = %q, want %q", language, got, want)
+		}
+	}
+}
+
+func TestAnalyseLanguage(t *testing.T) {
+	tests := []struct {
+		prompt, language  string
+		want, instruction string
+	}{
+		{`{"received":"Када је Ускрс?"}`, "", "sr-Cyrl", "Cyrillic script"},
+		{`{"received":"Kada je Uskrs?"}`, "hr", "hr", "exclusively in Croatian"},
+		{`{"received":"When is Easter?"}`, "", "en", "simple English"},
+		{`{"received":"Kada je Bajram?"}`, "", "bs", "ijekavian"},
+	}
+	for _, tt := range tests {
+		client := chatgpttest.NewClient(
+			chatgpttest.Response{Content: ""},
+			chatgpttest.Answer("Odgovor", "Odgovor", "Naslov"),
+		)
+		p, _, _ := newPipeline(client)
+		a := parseAnswer(t, p.Analyse(context.Background(), tt.prompt, chatgpt.Options{Language: tt.language}))
+		if a.Language != tt.want {
+			t.Errorf("%s: answer language %q, want %q", tt.prompt, a.Language, tt.want)
+		}
+		if messages := chatgpttest.MessagesJSON(client.Requests()[0]); !strings.Contains(messages, tt.instruction) {
+			t.Errorf("%s: system prompt does not contain %q:\n%s", tt.prompt, tt.instruction, messages)
+		}
+	}
+}
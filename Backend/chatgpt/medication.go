@@ -0,0 +1,290 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Medicine is a medicine registered in Bosnia and Herzegovina.
+type Medicine struct {
+	Name      string `json:"name"`
+	Substance string `json:"substance"`
+	Form      string `json:"form,omitempty"`
+	Strength  string `json:"strength,omitempty"`
+	// Holder is the marketing authorisation holder.
+	Holder string `json:"holder,omitempty"`
+	// Regime tells whether the medicine needs a prescription, e.g. Rp or BRp.
+	Regime   string   `json:"regime,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Medicines is the dataset of the medication_info tool. main loads it from
+// MEDICATIONS_FILE; without it the tool is not offered.
+var Medicines []Medicine
+
+// MedicineMatches is the number of medicines medication_info returns.
+const MedicineMatches = 5
+
+// medicationNotices are added to every answer that used medication_info.
+var medicationNotices = map[string]string{
+	"bs":      "Ove informacije ne zamjenjuju savjet ljekara. Prije uzimanja ili kombinovanja lijekova posavjetujte se sa svojim ljekarom ili farmaceutom.",
+	"sr":      "Ove informacije ne zamjenjuju savjet ljekara. Prije uzimanja ili kombinovanja lijekova posavjetujte se sa svojim ljekarom ili farmaceutom.",
+	"sr-Cyrl": "Ове информације не замјењују савјет љекара. Прије узимања или комбиновања лијекова посавјетујте се са својим љекаром или фармацеутом.",
+	"hr":      "Ove informacije ne zamjenjuju savjet liječnika. Prije uzimanja ili kombiniranja lijekova posavjetujte se sa svojim liječnikom ili ljekarnikom.",
+	"en":      "This information does not replace medical advice. Consult your doctor or pharmacist before taking or combining medicines.",
+}
+
+// LoadMedicines reads a medicines dataset, either a JSON array of Medicine
+// or a CSV export with a header row naming the columns name, substance,
+// form, strength, holder, regime and warnings. Warnings in CSV are
+// separated by |, columns by commas or semicolons. Empty CSV rows are
+// skipped, rows with a substance but no name are rejected.
+func LoadMedicines(path string) ([]Medicine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var medicines []Medicine
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		medicines, err = readMedicinesCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(&medicines)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading medicines from %s: %v", path, err)
+	}
+	for i, m := range medicines {
+		if strings.TrimSpace(m.Name) == "" {
+			return nil, fmt.Errorf("medicine %d in %s has no name", i+1, path)
+		}
+	}
+	return medicines, nil
+}
+
+// SaveMedicines writes medicines as a JSON dataset. It writes a temporary
+// file and renames it, so that a running server never reads half a dataset.
+func SaveMedicines(path string, medicines []Medicine) error {
+	data, err := json.MarshalIndent(medicines, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readMedicinesCSV(r io.Reader) ([]Medicine, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	cr := csv.NewReader(strings.NewReader(text))
+	if header, _, _ := strings.Cut(text, "\n"); strings.Count(header, ";") > strings.Count(header, ",") {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("no name column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var medicines []Medicine
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := Medicine{
+			Name:      field(row, "name"),
+			Substance: field(row, "substance"),
+			Form:      field(row, "form"),
+			Strength:  field(row, "strength"),
+			Holder:    field(row, "holder"),
+			Regime:    field(row, "regime"),
+		}
+		for _, w := range strings.Split(field(row, "warnings"), "|") {
+			if w = strings.TrimSpace(w); w != "" {
+				m.Warnings = append(m.Warnings, w)
+			}
+		}
+		if m.Name == "" && m.Substance == "" {
+			continue
+		}
+		if m.Name == "" {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d has a substance but no name", line)
+		}
+		medicines = append(medicines, m)
+	}
+	return medicines, nil
+}
+
+var diacritics = strings.NewReplacer("č", "c", "ć", "c", "š", "s", "ž", "z", "đ", "dj")
+
+// foldName lowercases a medicine name, folds Bosnian diacritics and keeps
+// only letters and digits, so that "Brufen® 400 mg" and "brufen 400 mg"
+// compare equal.
+func foldName(s string) string {
+	s = diacritics.Replace(strings.ToLower(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// nameScore tells how well a folded query matches a folded name or
+// substance: exact, as a prefix, contained, or words with a typo.
+func nameScore(query, name string) int {
+	switch {
+	case name == "":
+		return 0
+	case query == name:
+		return 100
+	case strings.HasPrefix(name, query+" ") || strings.HasPrefix(query, name+" "):
+		return 80
+	case strings.Contains(name, query) || strings.Contains(query, name):
+		return 60
+	}
+	best := 0
+	for _, q := range strings.Fields(query) {
+		if len(q) < 4 {
+			continue
+		}
+		for _, w := range strings.Fields(name) {
+			allowed := 1
+			if len(q) >= 7 {
+				allowed = 2
+			}
+			if d := levenshtein(q, w); d <= allowed && 40-d > best {
+				best = 40 - d
+			}
+		}
+	}
+	return best
+}
+
+// FindMedicines returns the medicines whose name or active substance best
+// match the query.
+func FindMedicines(medicines []Medicine, query string, limit int) []Medicine {
+	query = foldName(query)
+	if query == "" {
+		return nil
+	}
+	type scored struct {
+		Medicine
+		score int
+	}
+	var matches []scored
+	for _, m := range medicines {
+		score := max(nameScore(query, foldName(m.Name)), nameScore(query, foldName(m.Substance)))
+		if score > 0 {
+			matches = append(matches, scored{m, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	var result []Medicine
+	for i := 0; i < len(matches) && i < limit; i++ {
+		result = append(result, matches[i].Medicine)
+	}
+	return result
+}
+
+type medicationArgs struct {
+	Name string `json:"name" jsonschema_description:"Brand name or active substance of the medicine, as the user wrote it"`
+}
+
+var medicationTool = FuncTool[medicationArgs]{
+	ToolName:        "medication_info",
+	ToolDescription: "Look up a medicine registered in Bosnia and Herzegovina: active substance, form, strength, prescription regime and standard warnings",
+	Ready:           func() bool { return len(Medicines) > 0 },
+	Notices:         medicationNotices,
+	Run: func(ctx context.Context, env ToolEnv, args medicationArgs) (string, error) {
+		if strings.TrimSpace(args.Name) == "" {
+			return "", fmt.Errorf("'name' must not be empty")
+		}
+		matches := FindMedicines(Medicines, args.Name, MedicineMatches)
+		env.Logger.Info("Medication looked up",
+			"name", args.Name,
+			"matches", len(matches))
+
+		result := struct {
+			Query   string     `json:"query"`
+			Matches []Medicine `json:"matches"`
+			Note    string     `json:"note,omitempty"`
+			Notice  string     `json:"notice"`
+		}{Query: args.Name, Matches: matches, Notice: medicationNotices["bs"]}
+		if len(matches) == 0 {
+			result.Matches = []Medicine{}
+			result.Note = "No registered medicine matches this name. Do not guess its contents or warnings."
+		}
+		data, err := json.Marshal(result)
+		return string(data), err
+	},
+}
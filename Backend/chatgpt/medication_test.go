@@ -0,0 +1,132 @@
+package chatgpt_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+const medicinesCSV = "\ufeffname;substance;form;strength;regime;warnings\n" +
+	"Brufen;ibuprofen;film tableta;400 mg;BRp;Može povisiti krvni pritisak|Uzimati uz obrok\n" +
+	"Febricet;paracetamol;tableta;500 mg;BRp;Ne prekoračiti 4 g dnevno\n" +
+	"Enap;enalapril;tableta;10 mg;Rp;\n" +
+	"Diklofen;diklofenak;tableta;50 mg;Rp;Rizik od krvarenja u želucu\n"
+
+func loadMedicines(t *testing.T) []chatgpt.Medicine {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "lijekovi.csv")
+	writeFile(t, path, []byte(medicinesCSV))
+	medicines, err := chatgpt.LoadMedicines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return medicines
+}
+
+func TestLoadMedicinesCSV(t *testing.T) {
+	medicines := loadMedicines(t)
+	if len(medicines) != 4 {
+		t.Fatalf("loaded %d medicines, want 4", len(medicines))
+	}
+	brufen := medicines[0]
+	if brufen.Name != "Brufen" || brufen.Substance != "ibuprofen" || brufen.Regime != "BRp" || len(brufen.Warnings) != 2 {
+		t.Errorf("first medicine = %+v", brufen)
+	}
+	if len(medicines[2].Warnings) != 0 {
+		t.Errorf("Enap has warnings %q", medicines[2].Warnings)
+	}
+}
+
+func TestLoadMedicinesCSVRequiresNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lijekovi.csv")
+	writeFile(t, path, []byte(medicinesCSV+";;;;;\n;ibuprofen;tableta;200 mg;BRp;\n"))
+	_, err := chatgpt.LoadMedicines(path)
+	if err == nil || !strings.Contains(err.Error(), "line 7 has a substance but no name") {
+		t.Errorf("err = %v, want the line of the medicine without a name", err)
+	}
+}
+
+func TestSaveMedicines(t *testing.T) {
+	medicines := loadMedicines(t)
+	path := filepath.Join(t.TempDir(), "medications.json")
+	writeFile(t, path, []byte("[{"))
+	if err := chatgpt.SaveMedicines(path, medicines); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := chatgpt.LoadMedicines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(medicines) || saved[0].Name != "Brufen" || len(saved[0].Warnings) != 2 {
+		t.Errorf("saved %+v", saved)
+	}
+	if files, _ := filepath.Glob(path + ".*"); len(files) != 0 {
+		t.Errorf("left temporary files %q", files)
+	}
+}
+
+func TestFindMedicines(t *testing.T) {
+	medicines := loadMedicines(t)
+	tests := []struct {
+		query, want string
+	}{
+		{"Brufen", "Brufen"},
+		{"IBUPROFEN", "Brufen"},
+		{"brufen 400", "Brufen"},
+		{"ibuprofren", "Brufen"},
+		{"diklofenak", "Diklofen"},
+		{"Diklofenač", "Diklofen"},
+		{"febricet tablete", "Febricet"},
+	}
+	for _, tt := range tests {
+		matches := chatgpt.FindMedicines(medicines, tt.query, 3)
+		if len(matches) == 0 || matches[0].Name != tt.want {
+			t.Errorf("FindMedicines(%q) = %v, want %s first", tt.query, matches, tt.want)
+		}
+	}
+	if matches := chatgpt.FindMedicines(medicines, "aspirin", 3); len(matches) != 0 {
+		t.Errorf("FindMedicines(aspirin) = %v", matches)
+	}
+}
+
+func TestAnalyseAddsMedicationNotice(t *testing.T) {
+	defer func() { chatgpt.Medicines = nil }()
+	chatgpt.Medicines = loadMedicines(t)
+
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{ToolCalls: []chatgpttest.ToolCall{
+			{ID: "call_1", Name: "medication_info", Arguments: `{"name":"brufen"}`},
+		}},
+		chatgpttest.Answer("Brufen sadrži ibuprofen.", "Brufen je ibuprofen.", "Brufen"),
+	)
+	p, _, _ := newPipeline(client)
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Smijem li piti brufen uz lijek za pritisak?"}`, chatgpt.Options{}))
+
+	second := chatgpttest.MessagesJSON(client.Requests()[1])
+	for _, want := range []string{`\"substance\":\"ibuprofen\"`, "Može povisiti krvni pritisak", "posavjetujte se sa svojim ljekarom"} {
+		if !strings.Contains(second, want) {
+			t.Errorf("tool result does not contain %s:\n%s", want, second)
+		}
+	}
+	for _, response := range []string{a.Content.Longresponse, a.Content.Shortresponse} {
+		if !strings.Contains(response, "posavjetujte se sa svojim ljekarom ili farmaceutom") {
+			t.Errorf("answer has no notice: %q", response)
+		}
+	}
+
+	client = chatgpttest.NewClient(
+		chatgpttest.Response{ToolCalls: []chatgpttest.ToolCall{
+			{ID: "call_1", Name: "medication_info", Arguments: `{"name":"brufen"}`},
+		}},
+		chatgpttest.Answer("Brufen contains ibuprofen.", "Ibuprofen.", "Brufen"),
+	)
+	p, _, _ = newPipeline(client)
+	a = parseAnswer(t, p.Analyse(context.Background(), `{"received":"Is Brufen ibuprofen?"}`, chatgpt.Options{Language: "en"}))
+	if !strings.Contains(a.Content.Shortresponse, "Consult your doctor or pharmacist") {
+		t.Errorf("English answer has no notice: %q", a.Content.Shortresponse)
+	}
+}
@@ -0,0 +1,289 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// ModerationResult holds the category scores of a screened question.
+type ModerationResult struct {
+	Scores map[string]float64 `json:"scores"`
+	// Flagged lists the categories whose score reached the policy threshold.
+	Flagged []string `json:"flagged,omitempty"`
+}
+
+// Moderator screens a question (and its photos) before it is answered.
+type Moderator interface {
+	Moderate(ctx context.Context, text string, images [][]byte) (map[string]float64, error)
+}
+
+// OpenAIModerator uses the OpenAI moderation endpoint.
+type OpenAIModerator struct {
+	client *openai.Client
+}
+
+func NewOpenAIModerator(apiKey string) *OpenAIModerator {
+	return &OpenAIModerator{client: openai.NewClient(option.WithAPIKey(apiKey))}
+}
+
+// Moderate screens the text and every image. The moderation model accepts a
+// single image per request, so the text goes with the first image and each
+// further image is screened on its own. A category scores the highest score
+// of any request.
+func (m *OpenAIModerator) Moderate(ctx context.Context, text string, images [][]byte) (map[string]float64, error) {
+	inputs := openai.ModerationNewParamsInputModerationMultiModalArray{
+		openai.ModerationTextInputParam{
+			Type: openai.F(openai.ModerationTextInputTypeText),
+			Text: openai.F(text),
+		},
+	}
+	if len(images) == 0 {
+		return m.moderate(ctx, inputs)
+	}
+
+	scores := map[string]float64{}
+	for i, img := range images {
+		if i > 0 {
+			inputs = nil
+		}
+		inputs = append(inputs, openai.ModerationImageURLInputParam{
+			Type: openai.F(openai.ModerationImageURLInputTypeImageURL),
+			ImageURL: openai.F(openai.ModerationImageURLInputImageURLParam{
+				URL: openai.F(imageDataURL(img)),
+			}),
+		})
+		imageScores, err := m.moderate(ctx, inputs)
+		if err != nil {
+			return nil, fmt.Errorf("image %d: %v", i+1, err)
+		}
+		for category, score := range imageScores {
+			scores[category] = max(scores[category], score)
+		}
+	}
+	return scores, nil
+}
+
+func (m *OpenAIModerator) moderate(ctx context.Context, inputs openai.ModerationNewParamsInputModerationMultiModalArray) (map[string]float64, error) {
+	res, err := m.client.Moderations.New(ctx, openai.ModerationNewParams{
+		Input: openai.F[openai.ModerationNewParamsInputUnion](inputs),
+		Model: openai.F(openai.ModerationModelOmniModerationLatest),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("moderation request failed: %v", err)
+	}
+	if len(res.Results) == 0 {
+		return nil, fmt.Errorf("moderation response contained no results")
+	}
+
+	scores := map[string]float64{}
+	if err := json.Unmarshal([]byte(res.Results[0].CategoryScores.JSON.RawJSON()), &scores); err != nil {
+		return nil, fmt.Errorf("error parsing moderation scores: %v", err)
+	}
+	return scores, nil
+}
+
+// StubModerator scores a question 1.0 in the "harassment" category when it
+// contains one of the blocked words. It is meant for local development and tests.
+type StubModerator struct {
+	Blocked []string
+}
+
+func (m StubModerator) Moderate(ctx context.Context, text string, images [][]byte) (map[string]float64, error) {
+	lower := strings.ToLower(text)
+	for _, word := range m.Blocked {
+		if strings.Contains(lower, strings.ToLower(word)) {
+			return map[string]float64{"harassment": 1}, nil
+		}
+	}
+	return map[string]float64{"harassment": 0}, nil
+}
+
+// ModerationPolicy decides which scores lead to a refusal.
+type ModerationPolicy struct {
+	// Default is the threshold for categories without an explicit threshold.
+	Default float64
+	// Thresholds overrides the threshold per category, e.g. "self-harm": 0.3.
+	Thresholds map[string]float64
+	// FailOpen answers questions without screening when the moderator
+	// fails. By default such questions are not answered.
+	FailOpen bool
+}
+
+// DefaultModerationPolicy flags anything the model is fairly sure about.
+var DefaultModerationPolicy = ModerationPolicy{Default: 0.7}
+
+// ParseModerationPolicy reads thresholds in the form "default=0.7,violence=0.5".
+// Categories not listed use DefaultModerationPolicy; a threshold of 0 never
+// flags, so "default=0" turns flagging off except for listed categories.
+func ParseModerationPolicy(spec string) (ModerationPolicy, error) {
+	policy := ModerationPolicy{Default: DefaultModerationPolicy.Default, Thresholds: map[string]float64{}}
+	for category, threshold := range DefaultModerationPolicy.Thresholds {
+		policy.Thresholds[category] = threshold
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return policy, fmt.Errorf("invalid moderation threshold %q", pair)
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return policy, fmt.Errorf("invalid moderation threshold %q", pair)
+		}
+		if name = strings.TrimSpace(name); name == "default" {
+			policy.Default = threshold
+		} else {
+			policy.Thresholds[name] = threshold
+		}
+	}
+	return policy, nil
+}
+
+// Evaluate applies the policy to a set of scores.
+func (p ModerationPolicy) Evaluate(scores map[string]float64) ModerationResult {
+	result := ModerationResult{Scores: scores}
+	for category, score := range scores {
+		threshold, ok := p.Thresholds[category]
+		if !ok {
+			threshold = p.Default
+		}
+		if threshold > 0 && score >= threshold {
+			result.Flagged = append(result.Flagged, category)
+		}
+	}
+	sort.Strings(result.Flagged)
+	return result
+}
+
+// questionText returns the user's question from the {"received": ...}
+// envelope main sends, or the prompt itself.
+func questionText(prompt string) string {
+	var envelope struct {
+		Received string `json:"received"`
+	}
+	if err := json.Unmarshal([]byte(prompt), &envelope); err == nil && envelope.Received != "" {
+		return envelope.Received
+	}
+	return prompt
+}
+
+// refusal holds the polite answers given to flagged questions in one language.
+type refusal struct {
+	general  chatResponseContent
+	selfHarm chatResponseContent
+}
+
+// refusals are the refusals by answer language. Languages without one get
+// the Bosnian refusal.
+var refusals = map[string]refusal{
+	"bs": {
+		general: chatResponseContent{
+			Title:         "Ne mogu odgovoriti",
+			Shortresponse: "Izvinite, na ovo pitanje ne mogu odgovoriti. Rado ću Vam pomoći s nekim drugim pitanjem.",
+			Longresponse: "Izvinite, na ovo pitanje ne mogu odgovoriti.<br>" +
+				"Tu sam da Vam pomognem sa svakodnevnim pitanjima, na primjer o zdravlju, penzijama, " +
+				"tehnologiji ili javnim uslugama. Slobodno me pitajte nešto drugo.",
+		},
+		selfHarm: chatResponseContent{
+			Title: "Niste sami",
+			Shortresponse: "Žao mi je što se tako osjećate. Molim Vas, razgovarajte s nekim kome vjerujete " +
+				"ili odmah nazovite hitnu pomoć na broj 124.",
+			Longresponse: "Žao mi je što se tako osjećate.<br>Na ovo pitanje ne mogu odgovoriti, " +
+				"ali niste sami. Molim Vas, razgovarajte s nekim kome vjerujete, sa svojim ljekarom, " +
+				"ili odmah nazovite <b>hitnu pomoć na broj 124</b>.",
+		},
+	},
+	"hr": {
+		general: chatResponseContent{
+			Title:         "Ne mogu odgovoriti",
+			Shortresponse: "Oprostite, na ovo pitanje ne mogu odgovoriti. Rado ću Vam pomoći s nekim drugim pitanjem.",
+			Longresponse: "Oprostite, na ovo pitanje ne mogu odgovoriti.<br>" +
+				"Tu sam da Vam pomognem sa svakodnevnim pitanjima, na primjer o zdravlju, mirovinama, " +
+				"tehnologiji ili javnim uslugama. Slobodno me pitajte nešto drugo.",
+		},
+		selfHarm: chatResponseContent{
+			Title: "Niste sami",
+			Shortresponse: "Žao mi je što se tako osjećate. Molim Vas, razgovarajte s nekim komu vjerujete " +
+				"ili odmah nazovite hitnu pomoć na broj 124.",
+			Longresponse: "Žao mi je što se tako osjećate.<br>Na ovo pitanje ne mogu odgovoriti, " +
+				"ali niste sami. Molim Vas, razgovarajte s nekim komu vjerujete, sa svojim liječnikom, " +
+				"ili odmah nazovite <b>hitnu pomoć na broj 124</b>.",
+		},
+	},
+	"sr": {
+		general: chatResponseContent{
+			Title:         "Ne mogu da odgovorim",
+			Shortresponse: "Izvinite, na ovo pitanje ne mogu da odgovorim. Rado ću Vam pomoći sa nekim drugim pitanjem.",
+			Longresponse: "Izvinite, na ovo pitanje ne mogu da odgovorim.<br>" +
+				"Tu sam da Vam pomognem sa svakodnevnim pitanjima, na primjer o zdravlju, penzijama, " +
+				"tehnologiji ili javnim uslugama. Slobodno me pitajte nešto drugo.",
+		},
+		selfHarm: chatResponseContent{
+			Title: "Niste sami",
+			Shortresponse: "Žao mi je što se tako osjećate. Molim Vas, razgovarajte sa nekim kome vjerujete " +
+				"ili odmah pozovite hitnu pomoć na broj 124.",
+			Longresponse: "Žao mi je što se tako osjećate.<br>Na ovo pitanje ne mogu da odgovorim, " +
+				"ali niste sami. Molim Vas, razgovarajte sa nekim kome vjerujete, sa svojim ljekarom, " +
+				"ili odmah pozovite <b>hitnu pomoć na broj 124</b>.",
+		},
+	},
+	"sr-Cyrl": {
+		general: chatResponseContent{
+			Title:         "Не могу да одговорим",
+			Shortresponse: "Извините, на ово питање не могу да одговорим. Радо ћу Вам помоћи са неким другим питањем.",
+			Longresponse: "Извините, на ово питање не могу да одговорим.<br>" +
+				"Ту сам да Вам помогнем са свакодневним питањима, на примјер о здрављу, пензијама, " +
+				"технологији или јавним услугама. Слободно ме питајте нешто друго.",
+		},
+		selfHarm: chatResponseContent{
+			Title: "Нисте сами",
+			Shortresponse: "Жао ми је што се тако осјећате. Молим Вас, разговарајте са неким коме вјерујете " +
+				"или одмах позовите хитну помоћ на број 124.",
+			Longresponse: "Жао ми је што се тако осјећате.<br>На ово питање не могу да одговорим, " +
+				"али нисте сами. Молим Вас, разговарајте са неким коме вјерујете, са својим љекаром, " +
+				"или одмах позовите <b>хитну помоћ на број 124</b>.",
+		},
+	},
+	"en": {
+		general: chatResponseContent{
+			Title:         "I cannot answer this",
+			Shortresponse: "Sorry, I cannot answer this question. I will gladly help you with another question.",
+			Longresponse: "Sorry, I cannot answer this question.<br>" +
+				"I am here to help with everyday questions, for example about health, pensions, " +
+				"technology or public services. Feel free to ask me something else.",
+		},
+		selfHarm: chatResponseContent{
+			Title: "You are not alone",
+			Shortresponse: "I am sorry you feel this way. Please talk to someone you trust " +
+				"or call the emergency medical service on 124 right away.",
+			Longresponse: "I am sorry you feel this way.<br>I cannot answer this question, " +
+				"but you are not alone. Please talk to someone you trust or to your doctor, " +
+				"or call the <b>emergency medical service on 124</b> right away.",
+		},
+	},
+}
+
+// refusalContent is the polite answer given to flagged questions, in the
+// requested answer language.
+func refusalContent(result ModerationResult, language string) chatResponseContent {
+	r, ok := refusals[language]
+	if !ok {
+		r = refusals["bs"]
+	}
+	for _, category := range result.Flagged {
+		if strings.HasPrefix(category, "self-harm") {
+			return r.selfHarm
+		}
+	}
+	return r.general
+}
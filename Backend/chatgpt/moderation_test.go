@@ -0,0 +1,118 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+func TestModerationPolicy(t *testing.T) {
+	scores := map[string]float64{"harassment": 0.9, "self-harm": 0.4}
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "harassment"},
+		{"default=0", ""},
+		{"default=0,self-harm=0.3", "self-harm"},
+		{"harassment=0.95,self-harm=0.3", "self-harm"},
+		{"default=0.3", "harassment,self-harm"},
+	}
+	for _, tt := range tests {
+		policy, err := chatgpt.ParseModerationPolicy(tt.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		if got := strings.Join(policy.Evaluate(scores).Flagged, ","); got != tt.want {
+			t.Errorf("%q: flagged %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+	if _, err := chatgpt.ParseModerationPolicy("violence=2"); err == nil {
+		t.Error("a threshold above 1 was accepted")
+	}
+}
+
+// recordingModerator remembers the text it was asked to screen.
+type recordingModerator struct {
+	text *string
+}
+
+func (m recordingModerator) Moderate(ctx context.Context, text string, images [][]byte) (map[string]float64, error) {
+	*m.text = text
+	return map[string]float64{"harassment": 0.9}, nil
+}
+
+func TestAnalyseModeratesTheQuestion(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav"),
+	)
+	p, _, _ := newPipeline(client)
+
+	var screened string
+	off, _ := chatgpt.ParseModerationPolicy("default=0")
+	opts := chatgpt.Options{Moderator: recordingModerator{text: &screened}, ModerationPolicy: &off}
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo, kako ste?"}`, opts))
+
+	if screened != "Zdravo, kako ste?" {
+		t.Errorf("moderated %q, want the question text", screened)
+	}
+	if a.Moderated {
+		t.Error("flagged although default=0 turns flagging off")
+	}
+}
+
+func TestAnalyseRefusesFlaggedQuestions(t *testing.T) {
+	client := chatgpttest.NewClient()
+	p, _, _ := newPipeline(client)
+
+	var screened string
+	opts := chatgpt.Options{Language: "en", Moderator: recordingModerator{text: &screened}}
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo, kako ste?"}`, opts))
+
+	if !a.Moderated || a.Language != "en" || !strings.HasPrefix(a.Content.Shortresponse, "Sorry, I cannot answer this question.") {
+		t.Errorf("answer = %+v, want the English refusal", a)
+	}
+	if n := len(client.Requests()); n != 0 {
+		t.Errorf("%d completion requests for a flagged question", n)
+	}
+}
+
+// failingModerator is a moderator that is down.
+type failingModerator struct{}
+
+func (failingModerator) Moderate(ctx context.Context, text string, images [][]byte) (map[string]float64, error) {
+	return nil, errors.New("moderation request failed: 503 Service Unavailable")
+}
+
+func TestAnalyseWhenModerationFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		failOpen bool
+		requests int
+	}{
+		{"closed by default", false, 0},
+		{"fail open", true, 1},
+	}
+	for _, tt := range tests {
+		client := chatgpttest.NewClient(chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav"))
+		p, _, _ := newPipeline(client)
+
+		policy := chatgpt.DefaultModerationPolicy
+		policy.FailOpen = tt.failOpen
+		opts := chatgpt.Options{Moderator: failingModerator{}, ModerationPolicy: &policy}
+		result := p.Analyse(context.Background(), `{"received":"Zdravo, kako ste?"}`, opts)
+
+		if n := len(client.Requests()); n != tt.requests {
+			t.Errorf("%s: %d completion requests, want %d", tt.name, n, tt.requests)
+		}
+		if answered := json.Valid([]byte(result)); answered != tt.failOpen {
+			t.Errorf("%s: result %q", tt.name, result)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.com/webpagescraper"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// CompletionClient creates chat completions.
+type CompletionClient interface {
+	Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)
+	// CompleteStreaming passes every content delta to onDelta as it arrives
+	// and returns the accumulated completion.
+	CompleteStreaming(ctx context.Context, params openai.ChatCompletionNewParams, onDelta func(string)) (*openai.ChatCompletion, error)
+}
+
+// OpenAIClient is a CompletionClient backed by the OpenAI API.
+type OpenAIClient struct {
+	client *openai.Client
+}
+
+// NewOpenAIClient returns a CompletionClient for the given API key.
+func NewOpenAIClient(apikey string) *OpenAIClient {
+	return newOpenAIClient(option.WithAPIKey(apikey))
+}
+
+// newOpenAIClient returns an OpenAIClient that leaves retrying to the
+// pipeline's RetryPolicy, which also respects the request deadline.
+func newOpenAIClient(opts ...option.RequestOption) *OpenAIClient {
+	opts = append(opts, option.WithMaxRetries(0))
+	return &OpenAIClient{client: openai.NewClient(opts...)}
+}
+
+func (c *OpenAIClient) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	return c.client.Chat.Completions.New(ctx, params)
+}
+
+func (c *OpenAIClient) CompleteStreaming(ctx context.Context, params openai.ChatCompletionNewParams, onDelta func(string)) (*openai.ChatCompletion, error) {
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{
+		IncludeUsage: openai.F(true),
+	})
+	stream := c.client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	if len(acc.Choices) == 0 {
+		return nil, fmt.Errorf("streaming response contained no choices")
+	}
+	return &acc.ChatCompletion, nil
+}
+
+// Pipeline answers questions with a completion client and the search and
+// fetch backends used by the search_google tool.
+type Pipeline struct {
+	Client CompletionClient
+	Search webpagescraper.Searcher
+	Fetch  webpagescraper.Fetcher
+	// Model is used when a request does not name one. Empty means DefaultModel.
+	Model string
+	// RetryDelay is the pause before retrying an empty or incomplete answer.
+	RetryDelay time.Duration
+	// ToolCallRetry and AnswerRetry retry the first and the follow-up
+	// completion calls on transient provider errors. The zero value does
+	// not retry.
+	ToolCallRetry RetryPolicy
+	AnswerRetry   RetryPolicy
+	// MaxToolRounds and ToolTokenBudget bound the tool-calling loop. Zero
+	// means DefaultMaxToolRounds and DefaultToolTokenBudget.
+	MaxToolRounds   int
+	ToolTokenBudget int64
+	// Tools is the registry tools are taken from. nil means the package's Tools.
+	Tools *Registry
+	// EnabledTools limits the tools of the registry; nil enables all.
+	EnabledTools []string
+}
+
+// NewPipeline returns the production pipeline: the configured Provider,
+// SearXNG and plain HTTP. apikey is the OpenAI key.
+func NewPipeline(apikey string) *Pipeline {
+	p := &Pipeline{
+		Search:        webpagescraper.SearXNG{BaseURL: webpagescraper.SearchURL},
+		Fetch:         webpagescraper.DefaultFetcher,
+		RetryDelay:    time.Second,
+		ToolCallRetry: ToolCallRetry,
+		AnswerRetry:   AnswerRetry,
+		EnabledTools:  EnabledTools,
+	}
+	client, model, err := NewProvider(Provider, apikey)
+	if err != nil {
+		p.Client = failingClient{err}
+		return p
+	}
+	p.Client, p.Model = client, model
+	if len(Fallbacks) == 0 {
+		return p
+	}
+
+	chain := &FallbackClient{Entries: []FallbackEntry{{Client: client, Model: model}}, AttemptTimeout: FallbackTimeout}
+	for _, cfg := range Fallbacks {
+		client, model, err := NewProvider(cfg, apikey)
+		if err != nil {
+			p.Client = failingClient{err}
+			return p
+		}
+		chain.Entries = append(chain.Entries, FallbackEntry{Client: client, Model: model})
+	}
+	p.Client = chain
+	return p
+}
+
+// failingClient reports a provider configuration error on every call.
+type failingClient struct {
+	err error
+}
+
+func (c failingClient) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	return nil, c.err
+}
+
+func (c failingClient) CompleteStreaming(ctx context.Context, params openai.ChatCompletionNewParams, onDelta func(string)) (*openai.ChatCompletion, error) {
+	return nil, c.err
+}
+
+// model returns the model that answers a request.
+func (p *Pipeline) model(opts Options) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	if p.Model != "" {
+		return p.Model
+	}
+	return DefaultModel
+}
+
+// extractJSONObject returns the outermost JSON object in s. Models without
+// structured output sometimes wrap the answer in code fences or prose.
+func extractJSONObject(s string) string {
+	start := strings.Index(s, "{")
+	end := strings.LastIndex(s, "}")
+	if start < 0 || end < start {
+		return s
+	}
+	return s[start : end+1]
+}
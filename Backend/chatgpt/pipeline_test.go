@@ -0,0 +1,336 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+	"code.com/webpagescraper"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "chatgpt-test")
+	if err != nil {
+		panic(err)
+	}
+	chatgpt.LogFile = filepath.Join(dir, "logfile.log")
+	webpagescraper.LogFile = filepath.Join(dir, "logfile.log")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type answer struct {
+	Content struct {
+		Longresponse  string `json:"longresponse"`
+		Shortresponse string `json:"shortresponse"`
+		Title         string `json:"title"`
+	} `json:"content"`
+	InternetSearch bool   `json:"internet_search"`
+	Moderated      bool   `json:"moderated"`
+	Model          string `json:"model"`
+	Language       string `json:"language"`
+}
+
+func parseAnswer(t *testing.T, result string) answer {
+	t.Helper()
+	var a answer
+	if err := json.Unmarshal([]byte(result), &a); err != nil {
+		t.Fatalf("result is not a chatResponse: %v\n%s", err, result)
+	}
+	return a
+}
+
+func newPipeline(client *chatgpttest.Client) (*chatgpt.Pipeline, *chatgpttest.Searcher, *chatgpttest.Fetcher) {
+	search := &chatgpttest.Searcher{Results: map[string][]string{}}
+	fetch := &chatgpttest.Fetcher{Pages: map[string]string{}}
+	return &chatgpt.Pipeline{Client: client, Search: search, Fetch: fetch}, search, fetch
+}
+
+func TestAnalyseWithoutTools(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav"),
+	)
+	p, search, _ := newPipeline(client)
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{}))
+
+	if a.Content.Shortresponse != "Dobar dan" || a.Content.Title != "Pozdrav" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+	if a.InternetSearch {
+		t.Error("internet_search is set although no search was made")
+	}
+	if len(search.Queries()) != 0 {
+		t.Errorf("searched for %v", search.Queries())
+	}
+	requests := client.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d completion requests, want 2", len(requests))
+	}
+	if requests[0].Model.Value != chatgpt.DefaultModel {
+		t.Errorf("model = %q, want %q", requests[0].Model.Value, chatgpt.DefaultModel)
+	}
+	if !strings.Contains(chatgpttest.MessagesJSON(requests[0]), "Zdravo") {
+		t.Error("first request does not contain the question")
+	}
+}
+
+func TestAnalyseToolCallRoundTrip(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.SearchCall("call_1", "dežurna ljekarna Sarajevo"),
+		chatgpttest.Answer("Dežurna je ljekarna Baščaršija.", "Ljekarna Baščaršija", "Dežurna ljekarna"),
+	)
+	p, search, fetch := newPipeline(client)
+	search.Results["dežurna ljekarna Sarajevo"] = []string{"https://apotekesarajevo.ba/dezurna"}
+	fetch.Pages["https://apotekesarajevo.ba/dezurna"] = "<html><body><p>Dežurna ljekarna: Baščaršija</p></body></html>"
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Koja ljekarna radi noćas?"}`, chatgpt.Options{Model: "gpt-4o"}))
+
+	if !a.InternetSearch {
+		t.Error("internet_search is not set after a search_google call")
+	}
+	if a.Content.Shortresponse != "Ljekarna Baščaršija" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+	if got := search.Queries(); len(got) != 1 || got[0] != "dežurna ljekarna Sarajevo" {
+		t.Errorf("searched for %v", got)
+	}
+	if got := fetch.Fetched(); len(got) != 1 || got[0] != "https://apotekesarajevo.ba/dezurna" {
+		t.Errorf("fetched %v", got)
+	}
+
+	requests := client.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d completion requests, want 2", len(requests))
+	}
+	if requests[1].Model.Value != "gpt-4o" {
+		t.Errorf("model = %q, want gpt-4o", requests[1].Model.Value)
+	}
+	// The second call must see the assistant's tool call and the scraped page as the tool result
+	second := chatgpttest.MessagesJSON(requests[1])
+	for _, want := range []string{`"tool_call_id":"call_1"`, "Dežurna ljekarna: Baščaršija", "apotekesarajevo.ba/dezurna", `"search_google"`} {
+		if !strings.Contains(second, want) {
+			t.Errorf("second request does not contain %s:\n%s", want, second)
+		}
+	}
+}
+
+func TestAnalyseRetriesIncompleteAnswers(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Answer("", "", "Bez odgovora"),
+		chatgpttest.Answer("Odgovor", "Odgovor", "Naslov"),
+	)
+	p, _, _ := newPipeline(client)
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Pitanje"}`, chatgpt.Options{}))
+
+	if a.Content.Shortresponse != "Odgovor" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+	if n := len(client.Requests()); n != 3 {
+		t.Errorf("got %d completion requests, want 3", n)
+	}
+}
+
+func TestAnalyseGivesUpAfterMaxAttempts(t *testing.T) {
+	var responses []chatgpttest.Response
+	for i := 0; i < 3; i++ {
+		responses = append(responses, chatgpttest.Response{})
+	}
+	client := chatgpttest.NewClient(responses...)
+	p, _, _ := newPipeline(client)
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Pitanje"}`, chatgpt.Options{}))
+
+	if a.Content.Longresponse != "" || a.Content.Shortresponse != "" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+	if client.Remaining() != 0 {
+		t.Errorf("%d responses left, want all 3 attempts used", client.Remaining())
+	}
+}
+
+func TestAnalyseReportsClientErrors(t *testing.T) {
+	client := chatgpttest.NewClient(chatgpttest.Response{Err: errors.New("rate limited")})
+	p, _, _ := newPipeline(client)
+
+	result := p.Analyse(context.Background(), `{"received":"Pitanje"}`, chatgpt.Options{})
+
+	if result != "An error occurred: rate limited" {
+		t.Errorf("result = %q", result)
+	}
+}
+
+func TestAnalyseStreamsTokens(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.SearchCall("call_1", "penzije"),
+		chatgpttest.Answer("Dugi odgovor", "Kratki odgovor", "Naslov"),
+	)
+	p, _, _ := newPipeline(client)
+
+	var streamed strings.Builder
+	var stages []string
+	opts := chatgpt.Options{OnEvent: func(e chatgpt.Event) {
+		switch e.Type {
+		case "token":
+			streamed.WriteString(e.Delta)
+		case "progress":
+			stages = append(stages, e.Stage)
+		}
+	}}
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Pitanje"}`, opts))
+
+	if client.Streamed() != 2 {
+		t.Errorf("%d streamed requests, want both", client.Streamed())
+	}
+	if !strings.Contains(streamed.String(), "Kratki odgovor") {
+		t.Errorf("streamed %q", streamed.String())
+	}
+	if strings.Join(stages, ",") != "thinking,searching,writing" {
+		t.Errorf("stages = %v", stages)
+	}
+	if a.Content.Shortresponse != "Kratki odgovor" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+}
+
+func TestAnalyseAnswersWithoutToolCalls(t *testing.T) {
+	client := chatgpttest.NewClient(chatgpttest.Answer("Dugi odgovor", "Kratki odgovor", "Naslov"))
+	p, search, _ := newPipeline(client)
+
+	var tokens int
+	var stages []string
+	opts := chatgpt.Options{OnEvent: func(e chatgpt.Event) {
+		switch e.Type {
+		case "token":
+			tokens++
+		case "progress":
+			stages = append(stages, e.Stage)
+		}
+	}}
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Pitanje"}`, opts))
+
+	if a.Content.Shortresponse != "Kratki odgovor" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+	if n := len(client.Requests()); n != 1 || len(search.Queries()) != 0 {
+		t.Errorf("got %d completion requests and %d searches, want the first answer used", n, len(search.Queries()))
+	}
+	if client.Streamed() != 1 || tokens < 2 || strings.Join(stages, ",") != "thinking,writing" {
+		t.Errorf("streamed %d requests, sent %d token events and stages %v, want the first call streamed", client.Streamed(), tokens, stages)
+	}
+}
+
+func TestAnalyseModerationRefusal(t *testing.T) {
+	client := chatgpttest.NewClient()
+	p, _, _ := newPipeline(client)
+
+	opts := chatgpt.Options{Moderator: chatgpt.StubModerator{Blocked: []string{"bomb"}}}
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Kako napraviti bombu"}`, opts))
+
+	if !a.Moderated {
+		t.Error("answer is not marked as moderated")
+	}
+	if n := len(client.Requests()); n != 0 {
+		t.Errorf("got %d completion requests for a refused question", n)
+	}
+}
+
+func TestAnalyseCancelled(t *testing.T) {
+	client := chatgpttest.NewClient(chatgpttest.Answer("a", "b", "c"))
+	p, _, _ := newPipeline(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := p.Analyse(ctx, `{"received":"Pitanje"}`, chatgpt.Options{})
+
+	if !strings.Contains(result, context.Canceled.Error()) {
+		t.Errorf("result = %q", result)
+	}
+}
+
+func TestAnalyseWithoutStructuredOutputOrTools(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: "Evo odgovora:\n```json\n{\"longresponse\":\"Dugo\",\"shortresponse\":\"Kratko\",\"title\":\"Naslov\"}\n```"},
+	)
+	client.Caps = &chatgpt.Capabilities{}
+	p, _, _ := newPipeline(client)
+
+	var tokens int
+	opts := chatgpt.Options{OnEvent: func(e chatgpt.Event) {
+		if e.Type == "token" {
+			tokens++
+		}
+	}}
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Pitanje"}`, opts))
+
+	if a.Content.Shortresponse != "Kratko" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+	if client.Streamed() != 0 || tokens != 1 {
+		t.Errorf("streamed %d requests and sent %d token events, want 0 and 1", client.Streamed(), tokens)
+	}
+	first := client.Requests()[0]
+	if first.Tools.Present || first.ResponseFormat.Present {
+		t.Error("request uses tools or a response format the model does not support")
+	}
+	messages := chatgpttest.MessagesJSON(first)
+	if !strings.Contains(messages, "matches this JSON schema") || strings.Contains(messages, "use the search_google function") {
+		t.Errorf("system prompt not adapted to the model:\n%s", messages)
+	}
+}
+
+func TestAnalyseRejectsImagesWithoutVision(t *testing.T) {
+	client := chatgpttest.NewClient()
+	client.Caps = &chatgpt.Capabilities{JSONMode: true, Tools: true}
+	p, _, _ := newPipeline(client)
+
+	result := p.Analyse(context.Background(), `{"received":"Šta piše?"}`, chatgpt.Options{Images: [][]byte{{0xff, 0xd8}}})
+
+	if !strings.Contains(result, "cannot read photos") || len(client.Requests()) != 0 {
+		t.Errorf("result = %q after %d requests", result, len(client.Requests()))
+	}
+}
+
+func TestAnalyseDisableSearch(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav"),
+	)
+	p, _, _ := newPipeline(client)
+
+	parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{DisableSearch: true}))
+
+	for i, r := range client.Requests() {
+		for _, tool := range r.Tools.Value {
+			if name := tool.Function.Value.Name.Value; name == "search_google" || name == "fetch_url" {
+				t.Errorf("request %d offers %s although search is disabled", i, name)
+			}
+		}
+	}
+}
+
+func TestAnalyseWithSystemPromptVariant(t *testing.T) {
+	client := chatgpttest.NewClient(chatgpttest.Answer("Dugo", "Kratko", "Naslov"))
+	p, _, _ := newPipeline(client)
+
+	opts := chatgpt.Options{Language: "bs", SystemPrompt: "Odgovaraj kratko i bez linkova."}
+	parseAnswer(t, p.Analyse(context.Background(), `{"received":"Pitanje"}`, opts))
+
+	messages := chatgpttest.MessagesJSON(client.Requests()[0])
+	if !strings.Contains(messages, "Odgovaraj kratko i bez linkova.") || strings.Contains(messages, "always use HTML for formatting") {
+		t.Errorf("answer rules not replaced by the variant:\n%s", messages)
+	}
+	if !strings.Contains(messages, "The current date is") {
+		t.Errorf("variant dropped the date:\n%s", messages)
+	}
+}
@@ -0,0 +1,238 @@
+package chatgpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/option"
+)
+
+// Provider names accepted by ProviderConfig.Name.
+const (
+	ProviderOpenAI           = "openai"
+	ProviderOpenAICompatible = "openai-compatible"
+	ProviderAnthropic        = "anthropic"
+)
+
+// ProviderConfig selects the LLM provider that answers questions.
+type ProviderConfig struct {
+	// Name is one of the Provider* constants. Empty means OpenAI.
+	Name string
+	// BaseURL overrides the provider's API endpoint. It is required for
+	// OpenAI-compatible servers, e.g. http://ollama:11434/v1.
+	BaseURL string
+	// APIKey is the provider's key. For OpenAI it defaults to the key passed
+	// to ChatGPTAnalyse.
+	APIKey string
+	// Model is used when a request does not ask for a specific model.
+	Model string
+	// Capabilities, when set, overrides what the provider reports or detects,
+	// e.g. "tools,json_mode". See ParseCapabilities.
+	Capabilities string
+}
+
+// Provider is the provider used by NewPipeline. main sets it from the environment.
+var Provider ProviderConfig
+
+// Capabilities describes which request features a provider and model support.
+// The pipeline works around missing ones instead of failing.
+type Capabilities struct {
+	// JSONSchema is strict structured output via a json_schema response format.
+	JSONSchema bool
+	// JSONMode is the weaker json_object response format.
+	JSONMode  bool
+	Tools     bool
+	Streaming bool
+	Vision    bool
+}
+
+// FullCapabilities is what the OpenAI API supports.
+var FullCapabilities = Capabilities{JSONSchema: true, JSONMode: true, Tools: true, Streaming: true, Vision: true}
+
+// CapabilityReporter is implemented by clients that do not support every feature.
+type CapabilityReporter interface {
+	Capabilities(ctx context.Context, model string) Capabilities
+}
+
+// capabilitiesOf returns the capabilities of client for model. Clients that
+// do not report any are assumed to behave like OpenAI.
+func capabilitiesOf(ctx context.Context, client CompletionClient, model string) Capabilities {
+	if r, ok := client.(CapabilityReporter); ok {
+		return r.Capabilities(ctx, model)
+	}
+	return FullCapabilities
+}
+
+// ParseCapabilities parses a comma separated list of json_schema, json_mode,
+// tools, streaming and vision. "none" is an empty list.
+func ParseCapabilities(s string) (Capabilities, error) {
+	var c Capabilities
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "json_schema":
+			c.JSONSchema = true
+		case "json_mode":
+			c.JSONMode = true
+		case "tools":
+			c.Tools = true
+		case "streaming":
+			c.Streaming = true
+		case "vision":
+			c.Vision = true
+		default:
+			return c, fmt.Errorf("unknown capability %q", name)
+		}
+	}
+	return c, nil
+}
+
+// fixedCapabilities overrides the capabilities of the wrapped client.
+type fixedCapabilities struct {
+	CompletionClient
+	caps Capabilities
+}
+
+func (f fixedCapabilities) Capabilities(ctx context.Context, model string) Capabilities {
+	return f.caps
+}
+
+// NewProvider returns the client for cfg and the model to use by default.
+// apikey is used when cfg has no key of its own.
+func NewProvider(cfg ProviderConfig, apikey string) (CompletionClient, string, error) {
+	if cfg.APIKey != "" {
+		apikey = cfg.APIKey
+	}
+
+	var client CompletionClient
+	model := cfg.Model
+	switch cfg.Name {
+	case "", ProviderOpenAI:
+		opts := []option.RequestOption{option.WithAPIKey(apikey)}
+		if cfg.BaseURL != "" {
+			opts = append(opts, option.WithBaseURL(cfg.BaseURL))
+		}
+		client = newOpenAIClient(opts...)
+		if model == "" {
+			model = DefaultModel
+		}
+	case ProviderOpenAICompatible:
+		if cfg.BaseURL == "" {
+			return nil, "", fmt.Errorf("provider %s needs a base URL", cfg.Name)
+		}
+		if model == "" {
+			return nil, "", fmt.Errorf("provider %s needs a model", cfg.Name)
+		}
+		client = NewCompatibleClient(cfg.BaseURL, apikey)
+	case ProviderAnthropic:
+		client = NewAnthropicClient(cfg.BaseURL, apikey)
+		if model == "" {
+			model = DefaultAnthropicModel
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown provider %q", cfg.Name)
+	}
+
+	if cfg.Capabilities != "" {
+		caps, err := ParseCapabilities(cfg.Capabilities)
+		if err != nil {
+			return nil, "", err
+		}
+		client = fixedCapabilities{CompletionClient: client, caps: caps}
+	}
+	return client, model, nil
+}
+
+// CompatibleClient talks to an OpenAI-compatible server such as Ollama,
+// llama.cpp server or vLLM.
+type CompatibleClient struct {
+	*OpenAIClient
+	baseURL string
+
+	mu       sync.Mutex
+	detected map[string]Capabilities
+}
+
+// NewCompatibleClient returns a client for the OpenAI-compatible API at baseURL.
+func NewCompatibleClient(baseURL, apikey string) *CompatibleClient {
+	if apikey == "" {
+		// Local servers ignore the key, but the client insists on sending one
+		apikey = "none"
+	}
+	return &CompatibleClient{
+		OpenAIClient: newOpenAIClient(option.WithAPIKey(apikey), option.WithBaseURL(baseURL)),
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		detected:     map[string]Capabilities{},
+	}
+}
+
+// compatibleDefaults is what most OpenAI-compatible servers handle: JSON mode,
+// tool calls and streaming, but not strict schemas.
+var compatibleDefaults = Capabilities{JSONMode: true, Tools: true, Streaming: true}
+
+// Capabilities asks an Ollama server what model supports and falls back to
+// compatibleDefaults for other servers. Detected capabilities are cached per
+// model; the defaults are not, so a server that is still starting is asked
+// again on the next request.
+func (c *CompatibleClient) Capabilities(ctx context.Context, model string) Capabilities {
+	c.mu.Lock()
+	caps, ok := c.detected[model]
+	c.mu.Unlock()
+	if ok {
+		return caps
+	}
+
+	caps = compatibleDefaults
+	reported, err := c.ollamaCapabilities(ctx, model)
+	if err != nil {
+		return caps
+	}
+	caps.Tools = reported["tools"]
+	caps.Vision = reported["vision"]
+
+	c.mu.Lock()
+	c.detected[model] = caps
+	c.mu.Unlock()
+	return caps
+}
+
+// ollamaCapabilities queries Ollama's /api/show, which lists what a model can do.
+func (c *CompatibleClient) ollamaCapabilities(ctx context.Context, model string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"model": model})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.baseURL, "/v1")+"/api/show", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("model info request failed with status %d", resp.StatusCode)
+	}
+
+	var info struct {
+		Capabilities []string `json:"capabilities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if len(info.Capabilities) == 0 {
+		return nil, fmt.Errorf("server did not report capabilities")
+	}
+	reported := map[string]bool{}
+	for _, name := range info.Capabilities {
+		reported[name] = true
+	}
+	return reported, nil
+}
@@ -0,0 +1,41 @@
+package chatgpt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRefusalContent(t *testing.T) {
+	harassment := ModerationResult{Flagged: []string{"harassment"}}
+	selfHarm := ModerationResult{Flagged: []string{"harassment", "self-harm/intent"}}
+
+	tests := []struct {
+		language string
+		result   ModerationResult
+		want     string
+	}{
+		{"", harassment, "Izvinite, na ovo pitanje ne mogu odgovoriti."},
+		{"bs", selfHarm, "Žao mi je što se tako osjećate."},
+		{"hr", harassment, "Oprostite, na ovo pitanje ne mogu odgovoriti."},
+		{"hr", selfHarm, "Žao mi je što se tako osjećate. Molim Vas, razgovarajte s nekim komu"},
+		{"sr", harassment, "Izvinite, na ovo pitanje ne mogu da odgovorim."},
+		{"sr-Cyrl", selfHarm, "Жао ми је што се тако осјећате."},
+		{"en", harassment, "Sorry, I cannot answer this question."},
+		{"en", selfHarm, "I am sorry you feel this way."},
+		{"de", harassment, "Izvinite, na ovo pitanje ne mogu odgovoriti."},
+	}
+	for _, tt := range tests {
+		if got := refusalContent(tt.result, tt.language).Shortresponse; !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%q %v: %q, want it to start with %q", tt.language, tt.result.Flagged, got, tt.want)
+		}
+	}
+}
+
+func TestQuestionText(t *testing.T) {
+	if got := questionText(`{"received":"Kada je penzija?"}`); got != "Kada je penzija?" {
+		t.Errorf("envelope: %q", got)
+	}
+	if got := questionText("Kada je penzija?"); got != "Kada je penzija?" {
+		t.Errorf("plain prompt: %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package chatgpt
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// RetryPolicy decides how a completion call is retried after a transient
+// failure: a rate limit, a server error, a network error or a timeout.
+// The zero value makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry. It doubles with
+	// every further retry, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Retry policies of the completion call sites.
+var (
+	// ToolCallRetry covers the first call, which may ask for tools.
+	ToolCallRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second}
+	// AnswerRetry covers the calls after a tool round, which write the
+	// answer or ask for more tools.
+	AnswerRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second}
+	// JudgeRetry covers the eval judge, which has no user waiting on it.
+	JudgeRetry = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+)
+
+// backoff returns the jittered delay before retry number n (starting at 1).
+// A Retry-After from the provider is a lower bound.
+func (p RetryPolicy) backoff(n int, retryAfter time.Duration) time.Duration {
+	d := p.BaseDelay << (n - 1)
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d <= 0) {
+		d = p.MaxDelay
+	}
+	// Equal jitter: half fixed, half random, so concurrent requests spread out
+	d = d/2 + rand.N(d/2+1)
+	return max(d, retryAfter)
+}
+
+// retryable reports whether an error class is worth another attempt.
+// Refusals are not; the fallback chain handles them.
+func retryable(class string) bool {
+	switch class {
+	case ErrorRateLimit, ErrorServer, ErrorNetwork, ErrorTimeout:
+		return true
+	}
+	return false
+}
+
+// do runs call until it succeeds, fails with an error that is not transient,
+// runs out of attempts, or the next backoff would pass the deadline of ctx.
+// canRetry, if set, can veto a retry, e.g. once tokens have been streamed.
+func (p RetryPolicy) do(ctx context.Context, logger *slog.Logger, site string, canRetry func() bool, call func() (*openai.ChatCompletion, error)) (*openai.ChatCompletion, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := call()
+		if err == nil {
+			return result, nil
+		}
+		class := ClassifyError(err)
+		if attempt >= p.MaxAttempts || !retryable(class) || ctx.Err() != nil || (canRetry != nil && !canRetry()) {
+			return result, err
+		}
+
+		delay := p.backoff(attempt, RetryAfter(err))
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			logger.Warn("Not retrying completion call, deadline too close",
+				"call", site,
+				"attempt", attempt,
+				"reason", class,
+				"delay_ms", delay.Milliseconds(),
+				"remaining_ms", time.Until(deadline).Milliseconds())
+			return result, err
+		}
+		logger.Warn("Retrying completion call",
+			"call", site,
+			"attempt", attempt,
+			"max_attempts", p.MaxAttempts,
+			"reason", class,
+			"delay_ms", delay.Milliseconds(),
+			"error", err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
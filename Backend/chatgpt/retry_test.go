@@ -0,0 +1,100 @@
+package chatgpt_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+	"github.com/openai/openai-go"
+)
+
+var quickRetry = chatgpt.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestAnalyseRetriesTransientErrors(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Err: &chatgpt.StatusError{Provider: "test", StatusCode: http.StatusServiceUnavailable}},
+		chatgpttest.Response{Content: ""},
+		chatgpttest.Response{Err: &chatgpt.StatusError{Provider: "test", StatusCode: http.StatusTooManyRequests}},
+		chatgpttest.Answer("Dobar dan<br>", "Dobar dan", "Pozdrav"),
+	)
+	p, _, _ := newPipeline(client)
+	p.ToolCallRetry, p.AnswerRetry = quickRetry, quickRetry
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{}))
+
+	if a.Content.Shortresponse != "Dobar dan" {
+		t.Errorf("unexpected answer %+v", a.Content)
+	}
+	if n := len(client.Requests()); n != 4 {
+		t.Errorf("got %d completion requests, want 4", n)
+	}
+}
+
+func TestAnalyseDoesNotRetryBadRequests(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{Err: &chatgpt.StatusError{Provider: "test", StatusCode: http.StatusBadRequest}},
+		chatgpttest.Answer("a", "b", "c"),
+	)
+	p, _, _ := newPipeline(client)
+	p.ToolCallRetry = quickRetry
+
+	result := p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{})
+
+	if client.Remaining() != 1 {
+		t.Errorf("retried a bad request: %s", result)
+	}
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+	limited := &chatgpt.StatusError{Provider: "test", StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}
+	client := chatgpttest.NewClient(chatgpttest.Response{Err: limited}, chatgpttest.Answer("a", "b", "c"))
+	p, _, _ := newPipeline(client)
+	p.ToolCallRetry = quickRetry
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	p.Analyse(ctx, `{"received":"Zdravo"}`, chatgpt.Options{})
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waited %v for a Retry-After past the deadline", elapsed)
+	}
+	if client.Remaining() != 1 {
+		t.Error("retried although Retry-After is past the deadline")
+	}
+}
+
+func TestOpenAIClientsLeaveRetriesToThePolicy(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	for _, cfg := range []chatgpt.ProviderConfig{
+		{Name: chatgpt.ProviderOpenAI, BaseURL: srv.URL + "/"},
+		{Name: chatgpt.ProviderOpenAICompatible, BaseURL: srv.URL + "/", Model: "llama3.1", Capabilities: "tools"},
+	} {
+		hits.Store(0)
+		client, model, err := chatgpt.NewProvider(cfg, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := openai.ChatCompletionNewParams{
+			Model:    openai.F(model),
+			Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("Zdravo")}),
+		}
+		if _, err := client.Complete(context.Background(), params); err == nil {
+			t.Fatalf("%s: expected an error", cfg.Name)
+		}
+		if n := hits.Load(); n != 1 {
+			t.Errorf("%s: %d requests, want 1", cfg.Name, n)
+		}
+	}
+}
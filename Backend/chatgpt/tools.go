@@ -0,0 +1,312 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.com/webpagescraper"
+	"github.com/openai/openai-go"
+)
+
+// Tool is a function the model can call while answering.
+type Tool interface {
+	Name() string
+	Description() string
+	// Parameters is the JSON schema of the arguments.
+	Parameters() openai.FunctionParameters
+	// Execute runs the tool with the JSON arguments chosen by the model and
+	// returns the text handed back to it.
+	Execute(ctx context.Context, env ToolEnv, arguments string) (string, error)
+}
+
+// InternetTool is implemented by tools that reach out to the internet.
+// They set internet_search on the answer and are withheld when
+// Options.DisableSearch is set.
+type InternetTool interface {
+	UsesInternet() bool
+}
+
+// AvailableTool is implemented by tools that depend on data a deployment
+// may not have, such as the knowledge base. They are only offered when
+// Available reports true.
+type AvailableTool interface {
+	Available() bool
+}
+
+// NoticeTool is implemented by tools whose answers must carry a notice,
+// such as advice to see a doctor. The notice in the answer language is
+// added to every answer that used the tool, unless the model included it.
+type NoticeTool interface {
+	Notice(language string) string
+}
+
+// ToolEnv gives a tool the backends of the pipeline that runs it.
+type ToolEnv struct {
+	Search webpagescraper.Searcher
+	Fetch  webpagescraper.Fetcher
+	Logger *slog.Logger
+}
+
+// FuncTool is a Tool whose JSON arguments are decoded into A. Its parameter
+// schema is generated from A with GenerateSchema.
+type FuncTool[A any] struct {
+	ToolName        string
+	ToolDescription string
+	Internet        bool
+	// Ready, if set, reports whether the tool can be offered.
+	Ready func() bool
+	// Notices are the notices of the tool by answer language, with bs
+	// used for other languages.
+	Notices map[string]string
+	Run     func(ctx context.Context, env ToolEnv, args A) (string, error)
+}
+
+func (t FuncTool[A]) Name() string        { return t.ToolName }
+func (t FuncTool[A]) Description() string { return t.ToolDescription }
+func (t FuncTool[A]) UsesInternet() bool  { return t.Internet }
+
+func (t FuncTool[A]) Available() bool {
+	return t.Ready == nil || t.Ready()
+}
+
+func (t FuncTool[A]) Notice(language string) string {
+	if notice, ok := t.Notices[language]; ok {
+		return notice
+	}
+	return t.Notices["bs"]
+}
+
+func (t FuncTool[A]) Parameters() openai.FunctionParameters {
+	return schemaParameters(GenerateSchema[A]())
+}
+
+func (t FuncTool[A]) Execute(ctx context.Context, env ToolEnv, arguments string) (string, error) {
+	var args A
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	return t.Run(ctx, env, args)
+}
+
+// schemaParameters converts a generated schema to function parameters.
+func schemaParameters(schema interface{}) openai.FunctionParameters {
+	params := openai.FunctionParameters{}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return params
+	}
+	json.Unmarshal(data, &params)
+	delete(params, "$schema")
+	delete(params, "$id")
+	return params
+}
+
+func usesInternet(t Tool) bool {
+	it, ok := t.(InternetTool)
+	return ok && it.UsesInternet()
+}
+
+func available(t Tool) bool {
+	at, ok := t.(AvailableTool)
+	return !ok || at.Available()
+}
+
+// Registry holds the tools a deployment offers.
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+// NewRegistry returns a registry with the given tools.
+func NewRegistry(tools ...Tool) *Registry {
+	r := &Registry{tools: map[string]Tool{}}
+	for _, t := range tools {
+		r.Register(t)
+	}
+	return r
+}
+
+// Register adds a tool, replacing one with the same name.
+func (r *Registry) Register(t Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[t.Name()] = t
+}
+
+// Get returns the tool with the given name.
+func (r *Registry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// Names returns the names of all tools, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the tools named in enabled, or all tools if enabled is
+// nil. Unknown names are ignored.
+func (r *Registry) Select(enabled []string) []Tool {
+	if enabled == nil {
+		enabled = r.Names()
+	}
+	var tools []Tool
+	for _, name := range enabled {
+		if t, ok := r.Get(name); ok {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+// Tools is the registry of every tool the assistant knows.
+var Tools = NewRegistry(searchTool, fetchURLTool, calendarTool, calculateTool, knowledgeTool, medicationTool, departuresTool)
+
+// EnabledTools limits the tools of this deployment. nil enables all of
+// them. main sets it from the TOOLS environment variable.
+var EnabledTools []string
+
+// ParseToolList parses a comma separated list of tool names. "all" means
+// every registered tool and "none" no tool at all.
+func ParseToolList(s string) ([]string, error) {
+	switch s = strings.TrimSpace(s); s {
+	case "", "all":
+		return nil, nil
+	case "none":
+		return []string{}, nil
+	}
+	names := []string{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := Tools.Get(name); !ok {
+			return nil, fmt.Errorf("unknown tool %q (known: %s)", name, strings.Join(Tools.Names(), ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// tools returns the tools offered for a request: those enabled for the
+// deployment, narrowed down by Options.Tools, without internet tools when
+// search is disabled and without tools that are not available.
+func (p *Pipeline) tools(opts Options) []Tool {
+	registry := p.Tools
+	if registry == nil {
+		registry = Tools
+	}
+	var tools []Tool
+	for _, t := range registry.Select(p.EnabledTools) {
+		if opts.Tools != nil && !containsString(opts.Tools, t.Name()) {
+			continue
+		}
+		if opts.DisableSearch && usesInternet(t) || !available(t) {
+			continue
+		}
+		tools = append(tools, t)
+	}
+	return tools
+}
+
+// addNotices appends the notices of the tools an answer used, unless the
+// model already included them.
+func addNotices(c chatResponseContent, notices []string) chatResponseContent {
+	if c.Longresponse == "" && c.Shortresponse == "" {
+		return c
+	}
+	for _, notice := range notices {
+		if notice == "" {
+			continue
+		}
+		if !strings.Contains(c.Longresponse, notice) {
+			c.Longresponse += "<br><br><em>" + notice + "</em>"
+		}
+		if !strings.Contains(c.Shortresponse, notice) {
+			c.Shortresponse = strings.TrimSpace(c.Shortresponse + " " + notice)
+		}
+	}
+	return c
+}
+
+func findTool(tools []Tool, name string) (Tool, bool) {
+	for _, t := range tools {
+		if t.Name() == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// toolParams returns the tool definitions sent to the model.
+func toolParams(tools []Tool) []openai.ChatCompletionToolParam {
+	params := make([]openai.ChatCompletionToolParam, 0, len(tools))
+	for _, t := range tools {
+		params = append(params, openai.ChatCompletionToolParam{
+			Type: openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(openai.FunctionDefinitionParam{
+				Name:        openai.String(t.Name()),
+				Description: openai.String(t.Description()),
+				Parameters:  openai.F(t.Parameters()),
+			}),
+		})
+	}
+	return params
+}
+
+type searchArgs struct {
+	Query string `json:"query" jsonschema_description:"What to search for, in the language of the sources"`
+}
+
+var searchTool = FuncTool[searchArgs]{
+	ToolName:        "search_google",
+	ToolDescription: "Search Google for additional information",
+	Internet:        true,
+	Run: func(ctx context.Context, env ToolEnv, args searchArgs) (string, error) {
+		if strings.TrimSpace(args.Query) == "" {
+			return "", fmt.Errorf("'query' must not be empty")
+		}
+		env.Logger.Info("Executing Google search",
+			"query", args.Query,
+			"max_results", 10)
+		return webpagescraper.SearchAndScrape(ctx, env.Search, env.Fetch, args.Query, 10), nil
+	},
+}
+
+type fetchURLArgs struct {
+	URL string `json:"url" jsonschema_description:"The address of the page, as given by the user or found in search results"`
+}
+
+var fetchURLTool = FuncTool[fetchURLArgs]{
+	ToolName:        "fetch_url",
+	ToolDescription: "Open a web page and read its text, published date and URL",
+	Internet:        true,
+	Run: func(ctx context.Context, env ToolEnv, args fetchURLArgs) (string, error) {
+		env.Logger.Info("Fetching page for the model", "url", args.URL)
+		return webpagescraper.FetchPage(ctx, env.Fetch, args.URL)
+	},
+}
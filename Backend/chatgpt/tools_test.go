@@ -0,0 +1,124 @@
+package chatgpt_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/chatgpt/chatgpttest"
+)
+
+type echoArgs struct {
+	Text string `json:"text"`
+}
+
+var echoTool = chatgpt.FuncTool[echoArgs]{
+	ToolName:        "echo",
+	ToolDescription: "Repeat a text",
+	Run: func(ctx context.Context, env chatgpt.ToolEnv, args echoArgs) (string, error) {
+		return "echo: " + args.Text, nil
+	},
+}
+
+func toolNames(t *testing.T, p *chatgpt.Pipeline, client *chatgpttest.Client, opts chatgpt.Options) []string {
+	t.Helper()
+	p.Analyse(context.Background(), `{"received":"Zdravo"}`, opts)
+	var names []string
+	for _, tool := range client.Requests()[0].Tools.Value {
+		names = append(names, tool.Function.Value.Name.Value)
+	}
+	return names
+}
+
+func TestToolsAreDerivedFromTheRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled []string
+		opts    chatgpt.Options
+		want    string
+	}{
+		{"all", nil, chatgpt.Options{}, "echo,search_google"},
+		{"deployment", []string{"echo"}, chatgpt.Options{}, "echo"},
+		{"api key", nil, chatgpt.Options{Tools: []string{"search_google"}}, "search_google"},
+		{"no search", nil, chatgpt.Options{DisableSearch: true}, "echo"},
+		{"none", []string{}, chatgpt.Options{}, ""},
+	}
+	for _, tt := range tests {
+		client := chatgpttest.NewClient(chatgpttest.Response{Content: ""}, chatgpttest.Answer("a", "b", "c"))
+		p, _, _ := newPipeline(client)
+		p.Tools = chatgpt.NewRegistry(echoTool, chatgpt.Tools.Select([]string{"search_google"})[0])
+		p.EnabledTools = tt.enabled
+
+		if got := strings.Join(toolNames(t, p, client, tt.opts), ","); got != tt.want {
+			t.Errorf("%s: tools = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyseDispatchesRegisteredTools(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{ToolCalls: []chatgpttest.ToolCall{
+			{ID: "call_1", Name: "echo", Arguments: `{"text":"zdravo"}`},
+			{ID: "call_2", Name: "missing", Arguments: `{}`},
+		}},
+		chatgpttest.Answer("a", "b", "c"),
+	)
+	p, _, _ := newPipeline(client)
+	p.Tools = chatgpt.NewRegistry(echoTool)
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Zdravo"}`, chatgpt.Options{}))
+
+	if a.InternetSearch {
+		t.Error("internet_search is set for a tool that does not use the internet")
+	}
+	second := chatgpttest.MessagesJSON(client.Requests()[1])
+	for _, want := range []string{"echo: zdravo", `unknown tool \"missing\"`} {
+		if !strings.Contains(second, want) {
+			t.Errorf("second request does not contain %s:\n%s", want, second)
+		}
+	}
+	params := echoTool.Parameters()
+	if props, ok := params["properties"].(map[string]any); !ok || props["text"] == nil {
+		t.Errorf("schema has no text property: %v", params)
+	}
+}
+
+func TestParseToolList(t *testing.T) {
+	if got, err := chatgpt.ParseToolList("all"); err != nil || got != nil {
+		t.Errorf(`"all" = %v, %v`, got, err)
+	}
+	if got, err := chatgpt.ParseToolList("none"); err != nil || got == nil || len(got) != 0 {
+		t.Errorf(`"none" = %v, %v`, got, err)
+	}
+	if _, err := chatgpt.ParseToolList("search_google, nonsense"); err == nil {
+		t.Error("accepted an unknown tool")
+	}
+}
+
+func TestAnalyseFetchesURL(t *testing.T) {
+	client := chatgpttest.NewClient(
+		chatgpttest.Response{ToolCalls: []chatgpttest.ToolCall{
+			{ID: "call_1", Name: "fetch_url", Arguments: `{"url":"klix.ba/clanak"}`},
+			{ID: "call_2", Name: "fetch_url", Arguments: `{"url":"http://localhost:8468/logfile"}`},
+		}},
+		chatgpttest.Answer("Članak govori o penzijama.", "O penzijama", "Članak"),
+	)
+	p, _, fetch := newPipeline(client)
+	fetch.Pages["https://klix.ba/clanak"] = "<html><body><p>Penzije rastu za 5 posto.</p></body></html>"
+
+	a := parseAnswer(t, p.Analyse(context.Background(), `{"received":"Šta piše u klix.ba/clanak?"}`, chatgpt.Options{}))
+
+	if !a.InternetSearch {
+		t.Error("internet_search is not set after fetch_url")
+	}
+	if got := fetch.Fetched(); len(got) != 1 || got[0] != "https://klix.ba/clanak" {
+		t.Errorf("fetched %v, want only the public page", got)
+	}
+	second := chatgpttest.MessagesJSON(client.Requests()[1])
+	for _, want := range []string{"Penzije rastu za 5 posto.", "invalid url"} {
+		if !strings.Contains(second, want) {
+			t.Errorf("second request does not contain %s:\n%s", want, second)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package chatgpt
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransitIndex holds the stops and departures of one or more GTFS feeds.
+type TransitIndex struct {
+	location *time.Location
+	// stops maps folded stop names to the stops of that name, which are
+	// usually the platforms of both directions.
+	stops    map[string][]*transitStop
+	trips    int
+	services map[string]*transitService
+}
+
+type transitStop struct {
+	name       string
+	departures []stopDeparture
+}
+
+type stopDeparture struct {
+	// seconds since the start of the service day; may exceed 24 hours
+	seconds int
+	trip    *transitTrip
+}
+
+type transitTrip struct {
+	route    *transitRoute
+	service  string
+	headsign string
+}
+
+type transitRoute struct {
+	name string
+	kind string
+}
+
+// transitService is a GTFS service: the days its trips run.
+type transitService struct {
+	weekdays   [7]bool
+	start, end string
+	added      map[string]bool
+	removed    map[string]bool
+}
+
+func (s *transitService) runsOn(day time.Time) bool {
+	date := day.Format("20060102")
+	if s.removed[date] {
+		return false
+	}
+	if s.added[date] {
+		return true
+	}
+	return s.weekdays[day.Weekday()] && s.start <= date && date <= s.end
+}
+
+// Departure is a departure from a stop.
+type Departure struct {
+	Time     time.Time
+	Route    string
+	Kind     string
+	Headsign string
+}
+
+// Transit backs the next_departures tool. main loads it from GTFS_FEEDS;
+// without it the tool is not offered.
+var Transit *TransitIndex
+
+// Departures is the number of departures next_departures returns.
+const Departures = 8
+
+// LoadGTFS builds a TransitIndex from GTFS feeds, each a zip file or a
+// directory with the feed's text files.
+func LoadGTFS(paths ...string) (*TransitIndex, error) {
+	idx := &TransitIndex{
+		location: sarajevo,
+		stops:    map[string][]*transitStop{},
+		services: map[string]*transitService{},
+	}
+	for i, path := range paths {
+		if err := idx.load(fmt.Sprintf("%d:", i), path); err != nil {
+			return nil, fmt.Errorf("error loading GTFS feed %s: %v", path, err)
+		}
+	}
+	return idx, nil
+}
+
+// Stops returns the number of distinct stop names.
+func (idx *TransitIndex) Stops() int { return len(idx.stops) }
+
+// Trips returns the number of trips.
+func (idx *TransitIndex) Trips() int { return idx.trips }
+
+// gtfsFeed opens the files of a feed.
+type gtfsFeed func(name string) (io.ReadCloser, error)
+
+func openGTFS(path string) (gtfsFeed, func() error, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return func(name string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(path, name))
+		}, func() error { return nil }, nil
+	}
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(name string) (io.ReadCloser, error) {
+		for _, f := range z.File {
+			if filepath.Base(f.Name) == name {
+				return f.Open()
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}, z.Close, nil
+}
+
+// readTable calls each for every row of a feed file, with a function that
+// returns a column of the row by name. Missing optional files are skipped.
+func (feed gtfsFeed) readTable(name string, required bool, each func(field func(string) string) error) error {
+	f, err := feed(name)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))] = i
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		if err := each(field); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// load adds a feed to the index, prefixing its ids so that feeds do not clash.
+func (idx *TransitIndex) load(prefix, path string) error {
+	feed, closeFeed, err := openGTFS(path)
+	if err != nil {
+		return err
+	}
+	defer closeFeed()
+
+	err = feed.readTable("agency.txt", false, func(field func(string) string) error {
+		if loc, err := time.LoadLocation(field("agency_timezone")); err == nil && field("agency_timezone") != "" {
+			idx.location = loc
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	routes := map[string]*transitRoute{}
+	err = feed.readTable("routes.txt", true, func(field func(string) string) error {
+		kind, _ := strconv.Atoi(field("route_type"))
+		name := field("route_short_name")
+		if name == "" {
+			name = field("route_long_name")
+		}
+		routes[field("route_id")] = &transitRoute{name: name, kind: routeKind(kind)}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	trips := map[string]*transitTrip{}
+	err = feed.readTable("trips.txt", true, func(field func(string) string) error {
+		route, ok := routes[field("route_id")]
+		if !ok {
+			return fmt.Errorf("trip %s has unknown route %s", field("trip_id"), field("route_id"))
+		}
+		trips[field("trip_id")] = &transitTrip{route: route, service: prefix + field("service_id"), headsign: field("trip_headsign")}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	idx.trips += len(trips)
+
+	service := func(id string) *transitService {
+		s, ok := idx.services[prefix+id]
+		if !ok {
+			s = &transitService{added: map[string]bool{}, removed: map[string]bool{}}
+			idx.services[prefix+id] = s
+		}
+		return s
+	}
+	err = feed.readTable("calendar.txt", false, func(field func(string) string) error {
+		s := service(field("service_id"))
+		for i, day := range []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"} {
+			s.weekdays[i] = field(day) == "1"
+		}
+		s.start, s.end = field("start_date"), field("end_date")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = feed.readTable("calendar_dates.txt", false, func(field func(string) string) error {
+		s := service(field("service_id"))
+		switch field("exception_type") {
+		case "1":
+			s.added[field("date")] = true
+		case "2":
+			s.removed[field("date")] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Platforms take the name of their parent station, which is what people
+	// ask for. Stations, entrances and other nodes have no departures of
+	// their own and are left out.
+	type stopRow struct {
+		id, name, locationType, parent string
+	}
+	var rows []stopRow
+	names := map[string]string{}
+	err = feed.readTable("stops.txt", true, func(field func(string) string) error {
+		row := stopRow{field("stop_id"), field("stop_name"), field("location_type"), field("parent_station")}
+		rows = append(rows, row)
+		names[row.id] = row.name
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	stops := map[string]*transitStop{}
+	for _, row := range rows {
+		if row.locationType != "" && row.locationType != "0" {
+			continue
+		}
+		stop := &transitStop{name: row.name}
+		if parent := names[row.parent]; parent != "" {
+			stop.name = parent
+		}
+		stops[row.id] = stop
+		key := foldName(stop.name)
+		idx.stops[key] = append(idx.stops[key], stop)
+	}
+
+	// The last stop of a trip is only an arrival; it names the destination
+	// of trips without a headsign.
+	type lastStop struct {
+		sequence int
+		stop     *transitStop
+		index    int
+	}
+	last := map[*transitTrip]lastStop{}
+	err = feed.readTable("stop_times.txt", true, func(field func(string) string) error {
+		trip, ok := trips[field("trip_id")]
+		stop, known := stops[field("stop_id")]
+		if !ok || !known {
+			return nil
+		}
+		departure := field("departure_time")
+		if departure == "" {
+			departure = field("arrival_time")
+		}
+		seconds, err := gtfsSeconds(departure)
+		if err != nil {
+			// Stops without a time are passed without a timetabled departure
+			return nil
+		}
+		stop.departures = append(stop.departures, stopDeparture{seconds: seconds, trip: trip})
+		sequence, _ := strconv.Atoi(field("stop_sequence"))
+		if l, ok := last[trip]; !ok || sequence > l.sequence {
+			last[trip] = lastStop{sequence: sequence, stop: stop, index: len(stop.departures) - 1}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for trip, l := range last {
+		if trip.headsign == "" {
+			trip.headsign = l.stop.name
+		}
+		// Mark arrivals at the last stop so they are not offered as departures
+		l.stop.departures[l.index].trip = nil
+	}
+	for _, stop := range stops {
+		departures := stop.departures[:0]
+		for _, d := range stop.departures {
+			if d.trip != nil {
+				departures = append(departures, d)
+			}
+		}
+		sort.Slice(departures, func(i, j int) bool { return departures[i].seconds < departures[j].seconds })
+		stop.departures = departures
+	}
+	return nil
+}
+
+// gtfsSeconds parses a GTFS time such as 25:10:00.
+func gtfsSeconds(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var v [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		v[i] = n
+	}
+	return v[0]*3600 + v[1]*60 + v[2], nil
+}
+
+// routeKind names a GTFS route type, including the extended types.
+func routeKind(t int) string {
+	if t >= 100 {
+		switch t / 100 {
+		case 1, 3, 4:
+			return "voz"
+		case 2, 7:
+			return "autobus"
+		case 8:
+			return "trolejbus"
+		case 9:
+			return "tramvaj"
+		case 13:
+			return "žičara"
+		}
+		return "prevoz"
+	}
+	switch t {
+	case 0:
+		return "tramvaj"
+	case 1:
+		return "metro"
+	case 2:
+		return "voz"
+	case 3:
+		return "autobus"
+	case 4:
+		return "trajekt"
+	case 6:
+		return "žičara"
+	case 7:
+		return "uspinjača"
+	case 11:
+		return "trolejbus"
+	}
+	return "prevoz"
+}
+
+// MatchStop returns the stop name that best matches the query and other
+// stop names that match it too. Stops without departures are not matched.
+func (idx *TransitIndex) MatchStop(query string) (string, []string) {
+	query = foldName(query)
+	type scored struct {
+		key   string
+		score int
+	}
+	var matches []scored
+	for key, stops := range idx.stops {
+		if !slices.ContainsFunc(stops, func(s *transitStop) bool { return len(s.departures) > 0 }) {
+			continue
+		}
+		if score := nameScore(query, key); score > 0 {
+			matches = append(matches, scored{key, score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if len(matches[i].key) != len(matches[j].key) {
+			return len(matches[i].key) < len(matches[j].key)
+		}
+		return matches[i].key < matches[j].key
+	})
+	if len(matches) == 0 {
+		return "", nil
+	}
+	var others []string
+	for _, m := range matches[1:min(len(matches), 4)] {
+		others = append(others, idx.stops[m.key][0].name)
+	}
+	return idx.stops[matches[0].key][0].name, others
+}
+
+// NextDepartures returns the first departures from the stops named stop at
+// or after at, optionally only of one route. It looks up to a week ahead,
+// so that lines that only run on weekdays are found on a Saturday.
+func (idx *TransitIndex) NextDepartures(stop string, at time.Time, route string, limit int) []Departure {
+	at = at.In(idx.location)
+	var departures []Departure
+	for offset := -1; offset <= 7; offset++ {
+		// Later days only have later departures
+		if offset > 0 && len(departures) >= limit {
+			break
+		}
+		day := time.Date(at.Year(), at.Month(), at.Day()+offset, 0, 0, 0, 0, idx.location)
+		// GTFS times count from noon minus 12 hours, which is midnight
+		// except on the days clocks change
+		base := time.Date(at.Year(), at.Month(), at.Day()+offset, 12, 0, 0, 0, idx.location).Add(-12 * time.Hour)
+		from := int(at.Sub(base).Seconds())
+		for _, s := range idx.stops[foldName(stop)] {
+			found := 0
+			start := sort.Search(len(s.departures), func(i int) bool { return s.departures[i].seconds >= from })
+			for _, d := range s.departures[start:] {
+				if found == limit {
+					break
+				}
+				if route != "" && foldName(d.trip.route.name) != foldName(route) {
+					continue
+				}
+				if service, ok := idx.services[d.trip.service]; !ok || !service.runsOn(day) {
+					continue
+				}
+				departures = append(departures, Departure{
+					Time:     base.Add(time.Duration(d.seconds) * time.Second),
+					Route:    d.trip.route.name,
+					Kind:     d.trip.route.kind,
+					Headsign: d.trip.headsign,
+				})
+				found++
+			}
+		}
+	}
+	sort.SliceStable(departures, func(i, j int) bool { return departures[i].Time.Before(departures[j].Time) })
+	if len(departures) > limit {
+		departures = departures[:limit]
+	}
+	return departures
+}
+
+type departuresArgs struct {
+	Stop  string `json:"stop" jsonschema_description:"Name of the stop, as the user wrote it"`
+	Time  string `json:"time,omitempty" jsonschema_description:"Time as HH:MM, empty for now"`
+	Date  string `json:"date,omitempty" jsonschema_description:"Day as DD.MM.YYYY., empty for today"`
+	Route string `json:"route,omitempty" jsonschema_description:"Only this line, e.g. 3 for tram 3"`
+}
+
+var departuresTool = FuncTool[departuresArgs]{
+	ToolName:        "next_departures",
+	ToolDescription: "Timetabled departures of trams, buses and trolleybuses from a stop, with line and destination",
+	Ready:           func() bool { return Transit != nil && Transit.Stops() > 0 },
+	Run: func(ctx context.Context, env ToolEnv, args departuresArgs) (string, error) {
+		stop, others := Transit.MatchStop(args.Stop)
+		if stop == "" {
+			return "", fmt.Errorf("no stop matches %q", args.Stop)
+		}
+		at := time.Now().In(Transit.location)
+		if args.Date != "" {
+			day, err := parseDay(args.Date)
+			if err != nil {
+				return "", err
+			}
+			at = time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, Transit.location)
+		}
+		if args.Time != "" {
+			clock, err := time.Parse("15:04", strings.ReplaceAll(strings.TrimSpace(args.Time), ".", ":"))
+			if err != nil {
+				return "", fmt.Errorf("invalid time %q, use HH:MM", args.Time)
+			}
+			at = time.Date(at.Year(), at.Month(), at.Day(), clock.Hour(), clock.Minute(), 0, 0, Transit.location)
+		}
+		departures := Transit.NextDepartures(stop, at, args.Route, Departures)
+		env.Logger.Info("Departures looked up",
+			"stop", args.Stop,
+			"matched_stop", stop,
+			"route", args.Route,
+			"at", at.Format(time.RFC3339),
+			"departures", len(departures))
+
+		var out strings.Builder
+		fmt.Fprintf(&out, "Stanica: %s\nPolasci od %s:\n", stop, at.Format("02.01.2006. 15:04"))
+		if len(departures) == 0 {
+			out.WriteString("Nema polazaka po redu vožnje.\n")
+		}
+		for _, d := range departures {
+			when := d.Time.Format("15:04")
+			if d.Time.YearDay() != at.YearDay() {
+				when = d.Time.Format("02.01. 15:04")
+			}
+			fmt.Fprintf(&out, "%s %s %s → %s\n", when, d.Kind, d.Route, d.Headsign)
+		}
+		if len(others) > 0 {
+			fmt.Fprintf(&out, "Druge stanice sličnog imena: %s\n", strings.Join(others, ", "))
+		}
+		out.WriteString("Vremena su po redu vožnje; stvarni polasci mogu kasniti.\n")
+		return out.String(), nil
+	},
+}
@@ -0,0 +1,181 @@
+package chatgpt_test
+
+import (
+	"archive/zip"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.com/chatgpt"
+)
+
+var gtfsFeed = map[string]string{
+	"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\n" +
+		"GRAS,GRAS Sarajevo,https://gras.ba,Europe/Sarajevo\n",
+	"routes.txt": "route_id,route_short_name,route_long_name,route_type\n" +
+		"R3,3,Baščaršija - Ilidža,0\n" +
+		"R31,31E,Vijećnica - Dobrinja,3\n",
+	"trips.txt": "route_id,service_id,trip_id,trip_headsign\n" +
+		"R3,WD,t1,Ilidža\n" +
+		"R3,WD,t2,\n" +
+		"R31,WE,t3,Dobrinja\n" +
+		"R3,WD,t4,Ilidža\n" +
+		"R3,WD,t5,Ilidža\n",
+	"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+		"WD,1,1,1,1,1,0,0,20260101,20261231\n" +
+		"WE,0,0,0,0,0,1,1,20260101,20261231\n",
+	"calendar_dates.txt": "service_id,date,exception_type\n" +
+		"WD,20261125,2\n",
+	"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station\n" +
+		"S1,Baščaršija,43.859,18.431,,\n" +
+		"S2,Baščaršija,43.859,18.432,,\n" +
+		"S3,Ilidža,43.830,18.310,0,\n" +
+		"S4,Marijin Dvor,43.855,18.401,,\n" +
+		"S5,Skenderija (prema Ilidži),43.856,18.415,0,SK\n" +
+		"SK,Skenderija,43.856,18.416,1,\n" +
+		"S6,Skenderija (prema Baščaršiji),43.856,18.417,0,SK\n" +
+		"E1,Skenderija ulaz,43.856,18.418,2,SK\n" +
+		"S7,Otoka,43.848,18.364,,\n",
+	"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+		"t1,08:00:00,08:00:00,S1,1\n" +
+		"t1,08:05:00,08:05:00,S5,2\n" +
+		"t1,08:10:00,08:10:00,S4,3\n" +
+		"t1,08:40:00,08:40:00,S3,4\n" +
+		"t2,09:00:00,09:00:00,S3,1\n" +
+		"t2,09:30:00,09:30:00,S4,2\n" +
+		"t2,09:35:00,09:35:00,S6,3\n" +
+		"t2,09:40:00,09:40:00,S2,4\n" +
+		"t3,10:00:00,10:00:00,S2,1\n" +
+		"t3,10:20:00,10:20:00,S3,2\n" +
+		"t4,23:50:00,23:50:00,S1,1\n" +
+		"t4,24:30:00,24:30:00,S3,2\n" +
+		"t5,24:20:00,24:20:00,S1,1\n" +
+		"t5,24:50:00,24:50:00,S3,2\n",
+}
+
+func loadTransit(t *testing.T) *chatgpt.TransitIndex {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gras.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	for name, content := range gtfsFeed {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	idx, err := chatgpt.LoadGTFS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func sarajevoTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Sarajevo")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestLoadGTFS(t *testing.T) {
+	idx := loadTransit(t)
+	// Skenderija's platforms count as one stop; its entrance does not count
+	if idx.Stops() != 5 || idx.Trips() != 5 {
+		t.Errorf("%d stops and %d trips, want 5 and 5", idx.Stops(), idx.Trips())
+	}
+	for query, want := range map[string]string{
+		"bascarsija":              "Baščaršija",
+		"ILIDZA":                  "Ilidža",
+		"marijin dvor":            "Marijin Dvor",
+		"Marjin Dvor":             "Marijin Dvor",
+		"Skenderija":              "Skenderija",
+		"skenderija prema ilidzi": "Skenderija",
+	} {
+		if got, _ := idx.MatchStop(query); got != want {
+			t.Errorf("MatchStop(%q) = %q, want %q", query, got, want)
+		}
+	}
+	// Otoka has no departures
+	for _, query := range []string{"Dobrinja", "Otoka"} {
+		if got, _ := idx.MatchStop(query); got != "" {
+			t.Errorf("MatchStop(%s) = %q", query, got)
+		}
+	}
+}
+
+func TestNextDepartures(t *testing.T) {
+	idx := loadTransit(t)
+	format := func(departures []chatgpt.Departure) string {
+		var s []string
+		for _, d := range departures {
+			s = append(s, d.Time.Format("02.01. 15:04")+" "+d.Kind+" "+d.Route+" "+d.Headsign)
+		}
+		return strings.Join(s, "; ")
+	}
+
+	tests := []struct {
+		name, at, route string
+		want            string
+	}{
+		{"weekday", "2026-10-20 07:30", "", "20.10. 08:00 tramvaj 3 Ilidža; 20.10. 23:50 tramvaj 3 Ilidža"},
+		{"after midnight", "2026-10-20 23:55", "", "21.10. 00:20 tramvaj 3 Ilidža; 21.10. 08:00 tramvaj 3 Ilidža"},
+		{"holiday", "2026-11-25 07:00", "", "26.11. 08:00 tramvaj 3 Ilidža; 26.11. 23:50 tramvaj 3 Ilidža"},
+		{"weekend", "2026-10-24 07:00", "", "24.10. 10:00 autobus 31E Dobrinja; 25.10. 10:00 autobus 31E Dobrinja"},
+		{"route", "2026-10-24 07:00", "3", "26.10. 08:00 tramvaj 3 Ilidža; 26.10. 23:50 tramvaj 3 Ilidža"},
+		{"clocks go forward", "2026-03-29 07:00", "", "29.03. 10:00 autobus 31E Dobrinja; 30.03. 08:00 tramvaj 3 Ilidža"},
+		{"clocks go back", "2026-10-25 07:00", "", "25.10. 10:00 autobus 31E Dobrinja; 26.10. 08:00 tramvaj 3 Ilidža"},
+		{"clocks go back, before the departure", "2026-10-25 09:59", "", "25.10. 10:00 autobus 31E Dobrinja; 26.10. 08:00 tramvaj 3 Ilidža"},
+	}
+	for _, tt := range tests {
+		got := format(idx.NextDepartures("Baščaršija", sarajevoTime(t, tt.at), tt.route, 2))
+		if got != tt.want {
+			t.Errorf("%s: departures %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Both platforms of Skenderija depart under the station's name
+	got := format(idx.NextDepartures("Skenderija", sarajevoTime(t, "2026-10-20 07:00"), "", 2))
+	if want := "20.10. 08:05 tramvaj 3 Ilidža; 20.10. 09:35 tramvaj 3 Baščaršija"; got != want {
+		t.Errorf("Skenderija: departures %s, want %s", got, want)
+	}
+}
+
+func TestDeparturesTool(t *testing.T) {
+	defer func() { chatgpt.Transit = nil }()
+	chatgpt.Transit = loadTransit(t)
+
+	tool, _ := chatgpt.Tools.Get("next_departures")
+	got, err := tool.Execute(context.Background(), chatgpt.ToolEnv{Logger: slog.Default()},
+		`{"stop":"Bascarsija","date":"20.10.2026.","time":"7:30","route":"3"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Stanica: Baščaršija", "20.10.2026. 07:30", "08:00 tramvaj 3 → Ilidža", "21.10. 00:20 tramvaj 3 → Ilidža"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("result does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "09:40") {
+		t.Errorf("arrival at the last stop offered as a departure:\n%s", got)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var ErrAnswerNotFound = errors.New("answer not found")
+
+// answerIDPattern guards the answer store against path traversal.
+var answerIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// storedAnswer is the subset of a chatResponse that later requests need.
+type storedAnswer struct {
+	ID      string `json:"id"`
+	Content struct {
+		Longresponse  string `json:"longresponse"`
+		Shortresponse string `json:"shortresponse"`
+		Title         string `json:"title"`
+	} `json:"content"`
+}
+
+// AnswerStore keeps every answer as a JSON file so that follow-up requests
+// such as /v1/answers/{id}/audio can refer to it by ID. Answers are kept for
+// the store's TTL.
+type AnswerStore struct {
+	dir string
+	ttl time.Duration
+}
+
+func NewAnswerStore(dir string, ttl time.Duration) (*AnswerStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &AnswerStore{dir: dir, ttl: ttl}, nil
+}
+
+// answerStoreFromEnv opens the answer store with the TTL from ANSWER_TTL.
+func answerStoreFromEnv() (*AnswerStore, error) {
+	ttl := 30 * 24 * time.Hour
+	if v := os.Getenv("ANSWER_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid ANSWER_TTL %q", v)
+		}
+		ttl = d
+	}
+	return NewAnswerStore(cfg.dataPath("answers"), ttl)
+}
+
+// Start launches the expiry loop, which also removes the cached audio in
+// audioDir once it is older than the TTL. It returns immediately.
+func (s *AnswerStore) Start(audioDir string) {
+	go func() {
+		for range time.Tick(time.Hour) {
+			s.expire(audioDir, time.Now())
+		}
+	}()
+}
+
+// expire removes answers and cached audio older than the store's TTL.
+func (s *AnswerStore) expire(audioDir string, now time.Time) {
+	for _, dir := range []string{s.dir, audioDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logger.Error("Failed to list stored answers", "error", err, "dir", dir)
+			}
+			continue
+		}
+		removed := 0
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || entry.IsDir() || now.Sub(info.ModTime()) < s.ttl {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				logger.Error("Failed to remove expired answer", "error", err, "file", entry.Name())
+				continue
+			}
+			removed++
+		}
+		if removed > 0 {
+			logger.Info("Stored answers expired", "dir", dir, "files", removed)
+		}
+	}
+}
+
+// Save stores a chatResponse and returns it with an added "id" field, as
+// clientAnswer sends it. Error messages from ChatGPTAnalyse are returned
+// unchanged.
+func (s *AnswerStore) Save(resultingText string) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resultingText), &fields); err != nil || fields["content"] == nil {
+		return resultingText
+	}
+
+	id, err := randomHex(12)
+	if err != nil {
+		logger.Error("Failed to generate answer id", "error", err)
+		return clientAnswer(resultingText)
+	}
+	fields["id"], _ = json.Marshal(id)
+	withID, err := json.Marshal(fields)
+	if err != nil {
+		logger.Error("Failed to encode answer", "error", err)
+		return clientAnswer(resultingText)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, id+".json"), withID, 0600); err != nil {
+		logger.Error("Failed to store answer", "error", err, "answer_id", id)
+		return clientAnswer(resultingText)
+	}
+	return clientAnswer(string(withID))
+}
+
+// serverFields are the parts of a chatResponse that are only logged and
+// stored, never sent to clients. The trace is shown to admins only.
+var serverFields = []string{"usage", "model", "trace"}
+
+// clientAnswer removes the serverFields from an answer. An answer that
+// cannot be re-encoded is replaced by an error message rather than sent
+// with them.
+func clientAnswer(resultingText string) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resultingText), &fields); err != nil {
+		return resultingText
+	}
+	found := false
+	for _, name := range serverFields {
+		if _, ok := fields[name]; ok {
+			delete(fields, name)
+			found = true
+		}
+	}
+	if !found {
+		return resultingText
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Sprintf("An error occurred during JSON Marshalling: %v", err.Error())
+	}
+	return string(data)
+}
+
+// Load returns a stored answer.
+func (s *AnswerStore) Load(id string) (storedAnswer, error) {
+	var answer storedAnswer
+	data, err := s.Raw(id)
+	if err != nil {
+		return answer, err
+	}
+	err = json.Unmarshal(data, &answer)
+	return answer, err
+}
+
+// Raw returns a stored answer as it was saved, including its trace.
+func (s *AnswerStore) Raw(id string) ([]byte, error) {
+	if !answerIDPattern.MatchString(id) {
+		return nil, ErrAnswerNotFound
+	}
+	path := filepath.Join(s.dir, id+".json")
+	// An expired answer that the expiry loop has not removed yet is gone too
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) >= s.ttl {
+		return nil, ErrAnswerNotFound
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrAnswerNotFound
+	}
+	return data, err
+}
+
+var answerStore *AnswerStore
+
+// saveAnswer stores an answer when the answer store is enabled and returns
+// it as clientAnswer sends it.
+func saveAnswer(resultingText string) string {
+	if answerStore == nil {
+		return clientAnswer(resultingText)
+	}
+	return answerStore.Save(resultingText)
+}
+
+// AdminGetAnswer handles GET /admin/answers/{id}. It returns the stored
+// answer with the trace of its completion and tool calls.
+func AdminGetAnswer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	data, err := answerStore.Raw(id)
+	if errors.Is(err, ErrAnswerNotFound) {
+		http.Error(w, "Answer not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to read answer", "error", err, "answer_id", id)
+		http.Error(w, "Error reading answer", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
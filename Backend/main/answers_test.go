@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const answerWithUsage = `{"content":{"longresponse":"Dobar dan","shortresponse":"Dobar dan","title":"Pozdrav"},` +
+	`"internet_search":false,"model":"gpt-4o-mini","usage":{"total_tokens":120,"cost_usd":0.0001}}`
+
+func TestSaveAnswerHidesServerFields(t *testing.T) {
+	store, err := NewAnswerStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(store.Save(answerWithUsage)), &sent); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"usage", "model"} {
+		if _, ok := sent[name]; ok {
+			t.Errorf("%q sent to the client", name)
+		}
+	}
+	var id string
+	if err := json.Unmarshal(sent["id"], &id); err != nil || sent["content"] == nil {
+		t.Fatalf("answer sent without id or content: %v", sent)
+	}
+
+	// The stored answer keeps them
+	data, err := os.ReadFile(filepath.Join(store.dir, id+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]json.RawMessage
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored["usage"] == nil || stored["model"] == nil {
+		t.Errorf("stored answer lost its usage or model: %s", data)
+	}
+}
+
+func TestClientAnswer(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{answerWithUsage, `{"content":{"longresponse":"Dobar dan","shortresponse":"Dobar dan","title":"Pozdrav"},"internet_search":false}`},
+		{`{"content":{"title":"Pozdrav"}}`, `{"content":{"title":"Pozdrav"}}`},
+		{"An error occurred: timeout", "An error occurred: timeout"},
+	}
+	for _, tt := range tests {
+		if got := clientAnswer(tt.in); got != tt.want {
+			t.Errorf("clientAnswer(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+const tracedAnswer = `{"content":{"longresponse":"Dugo","shortresponse":"Kratko","title":"Naslov"},"trace":[{"tool_calls":[{"name":"fetch_url","arguments":"{\"url\":\"https://pio.ba\"}"}]}]}`
+
+func TestAnswerStoreSave(t *testing.T) {
+	store, err := NewAnswerStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := store.Save(tracedAnswer)
+	if strings.Contains(saved, "trace") || !strings.Contains(saved, `"id"`) {
+		t.Fatalf("saved answer = %s", saved)
+	}
+	answer, err := store.Load(strings.Split(strings.Split(saved, `"id":"`)[1], `"`)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := store.Raw(answer.ID)
+	if !strings.Contains(string(raw), "fetch_url") {
+		t.Errorf("stored answer lost its trace: %s", raw)
+	}
+
+	// The trace is also removed when the answer cannot be stored
+	if err := os.RemoveAll(store.dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Save(tracedAnswer); strings.Contains(got, "trace") {
+		t.Errorf("unstored answer carries its trace: %s", got)
+	}
+	if got := store.Save("An error occurred: timeout"); got != "An error occurred: timeout" {
+		t.Errorf("error message changed to %s", got)
+	}
+}
+
+func TestSaveAnswerWithoutStore(t *testing.T) {
+	saved := answerStore
+	answerStore = nil
+	defer func() { answerStore = saved }()
+
+	if got := saveAnswer(tracedAnswer); strings.Contains(got, "trace") || !strings.Contains(got, "Kratko") {
+		t.Errorf("saveAnswer = %s", got)
+	}
+}
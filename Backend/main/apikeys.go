@@ -0,0 +1,601 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.com/chatgpt"
+)
+
+var (
+	ErrKeyNotFound    = errors.New("api key not found")
+	ErrKeyRevoked     = errors.New("api key revoked")
+	ErrQuotaExceeded  = errors.New("api key quota exceeded")
+	ErrOriginRejected = errors.New("origin not allowed for api key")
+)
+
+// KeyDefaults are the answer options applied to every request made with a key.
+type KeyDefaults struct {
+	Language     string `json:"language,omitempty"`
+	AnswerLength string `json:"answer_length,omitempty"`
+}
+
+// KeyUsage counts the requests made with a key in the current day and month.
+type KeyUsage struct {
+	Day        string `json:"day"`
+	DayCount   int    `json:"day_count"`
+	Month      string `json:"month"`
+	MonthCount int    `json:"month_count"`
+	Total      int    `json:"total"`
+}
+
+// APIKey is a credential issued to a partner organisation.
+// Only the SHA-256 hash of the secret token is stored.
+type APIKey struct {
+	ID             string      `json:"id"`
+	Name           string      `json:"name"`
+	Hash           string      `json:"hash,omitempty"`
+	DailyQuota     int         `json:"daily_quota"`
+	MonthlyQuota   int         `json:"monthly_quota"`
+	AllowedOrigins []string    `json:"allowed_origins,omitempty"`
+	Defaults       KeyDefaults `json:"defaults"`
+	// Tools limits the assistant's tools for this key; nil allows every
+	// tool enabled for the deployment.
+	Tools     []string   `json:"tools"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Usage     KeyUsage   `json:"usage"`
+}
+
+// Options converts the key defaults into analysis options.
+func (k *APIKey) Options() chatgpt.Options {
+	return chatgpt.Options{
+		Language:     k.Defaults.Language,
+		AnswerLength: k.Defaults.AnswerLength,
+		Tools:        k.Tools,
+	}
+}
+
+// AllowsOrigin reports whether a browser origin may use the key.
+// Requests without an Origin header (server to server) are always allowed.
+func (k *APIKey) AllowsOrigin(origin string) bool {
+	if origin == "" || len(k.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range k.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// rollover starts new day and month counts when now is in a later period.
+func (u *KeyUsage) rollover(now time.Time) {
+	day := now.Format("2006-01-02")
+	month := now.Format("2006-01")
+	if u.Day != day {
+		u.Day = day
+		u.DayCount = 0
+	}
+	if u.Month != month {
+		u.Month = month
+		u.MonthCount = 0
+	}
+}
+
+// checkQuota returns ErrQuotaExceeded when the key has no requests left at now.
+func (k *APIKey) checkQuota(now time.Time) error {
+	usage := k.Usage
+	usage.rollover(now)
+	if k.DailyQuota > 0 && usage.DayCount >= k.DailyQuota {
+		return ErrQuotaExceeded
+	}
+	if k.MonthlyQuota > 0 && usage.MonthCount >= k.MonthlyQuota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// KeyUsageFlushInterval is how often request counts are written to the key
+// file. Counts of the last interval are lost if the server crashes.
+const KeyUsageFlushInterval = 10 * time.Second
+
+// KeyStore keeps API keys in memory and persists them to a JSON file.
+type KeyStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	keys    map[string]*APIKey
+	// dirty is set when request counts changed since savedAt.
+	dirty   bool
+	savedAt time.Time
+}
+
+// LoadKeyStore reads the key file at path, creating an empty store if it does not exist.
+func LoadKeyStore(path string) (*KeyStore, error) {
+	ks := &KeyStore{path: path, keys: map[string]*APIKey{}}
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// reload re-reads the key file when it was changed by another process (e.g. the CLI).
+func (ks *KeyStore) reload() error {
+	info, err := os.Stat(ks.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().After(ks.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	var list []*APIKey
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("error parsing api key file %s: %v", ks.path, err)
+		}
+	}
+
+	keys := make(map[string]*APIKey, len(list))
+	for _, k := range list {
+		// Only the server counts requests, so counts not yet flushed win
+		// over the ones the CLI wrote back
+		if old, ok := ks.keys[k.ID]; ok && old.Usage.Total > k.Usage.Total {
+			k.Usage = old.Usage
+		}
+		keys[k.ID] = k
+	}
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	return nil
+}
+
+func (ks *KeyStore) save() error {
+	list := make([]*APIKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0755); err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ks.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(ks.path); err == nil {
+		ks.modTime = info.ModTime()
+	}
+	ks.dirty = false
+	ks.savedAt = time.Now()
+	return nil
+}
+
+// Flush writes request counts that were not saved yet.
+func (ks *KeyStore) Flush() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if !ks.dirty {
+		return nil
+	}
+	return ks.save()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a fresh secret token for the key with the given ID.
+func newToken(id string) (string, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	return "slk_" + id + "_" + secret, nil
+}
+
+// public returns a copy of the key that is safe to show to admins.
+func (k *APIKey) public() APIKey {
+	c := *k
+	c.Hash = ""
+	return c
+}
+
+// List returns all keys, oldest first, without their hashes.
+func (ks *KeyStore) List() ([]APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+
+	list := make([]APIKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		list = append(list, k.public())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Create issues a new key and returns it together with its secret token.
+// The token is only available at this point.
+func (ks *KeyStore) Create(k APIKey) (APIKey, string, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return APIKey{}, "", err
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	token, err := newToken(id)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	k.ID = id
+	k.Hash = hashToken(token)
+	k.CreatedAt = time.Now()
+	k.RotatedAt = nil
+	k.RevokedAt = nil
+	k.Usage = KeyUsage{}
+	ks.keys[id] = &k
+
+	if err := ks.save(); err != nil {
+		delete(ks.keys, id)
+		return APIKey{}, "", err
+	}
+	return k.public(), token, nil
+}
+
+// Revoke disables a key permanently.
+func (ks *KeyStore) Revoke(id string) (APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return APIKey{}, err
+	}
+
+	k, ok := ks.keys[id]
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		now := time.Now()
+		k.RevokedAt = &now
+	}
+	if err := ks.save(); err != nil {
+		return APIKey{}, err
+	}
+	return k.public(), nil
+}
+
+// Rotate replaces the secret token of a key, keeping its quota, defaults and usage.
+func (ks *KeyStore) Rotate(id string) (APIKey, string, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return APIKey{}, "", err
+	}
+
+	k, ok := ks.keys[id]
+	if !ok {
+		return APIKey{}, "", ErrKeyNotFound
+	}
+	if k.RevokedAt != nil {
+		return APIKey{}, "", ErrKeyRevoked
+	}
+	token, err := newToken(id)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	now := time.Now()
+	k.Hash = hashToken(token)
+	k.RotatedAt = &now
+	if err := ks.save(); err != nil {
+		return APIKey{}, "", err
+	}
+	return k.public(), token, nil
+}
+
+// findLocked returns the active key for a token. The caller must hold ks.mu.
+func (ks *KeyStore) findLocked(token string) (*APIKey, error) {
+	hash := hashToken(token)
+	for _, k := range ks.keys {
+		if k.Hash == hash {
+			if k.RevokedAt != nil {
+				return nil, ErrKeyRevoked
+			}
+			return k, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Lookup returns the active key for a token without counting a request against it.
+func (ks *KeyStore) Lookup(token string) (APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return APIKey{}, err
+	}
+	key, err := ks.findLocked(token)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return key.public(), nil
+}
+
+// Authenticate looks up the key for a token and checks that it is still
+// active, that origin may use it and that its quota allows another
+// request. It does not count the request; see Count.
+func (ks *KeyStore) Authenticate(token, origin string, now time.Time) (APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return APIKey{}, err
+	}
+
+	key, err := ks.findLocked(token)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if !key.AllowsOrigin(origin) {
+		return key.public(), ErrOriginRejected
+	}
+	if err := key.checkQuota(now); err != nil {
+		return key.public(), err
+	}
+	return key.public(), nil
+}
+
+// Count records a request against the key with the given ID once it is
+// known to be valid. It fails when the key was revoked or its quota was
+// used up in the meantime. Counts are written to the key file at most every
+// KeyUsageFlushInterval.
+func (ks *KeyStore) Count(id string, now time.Time) (APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return APIKey{}, err
+	}
+
+	key, ok := ks.keys[id]
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+	if key.RevokedAt != nil {
+		return APIKey{}, ErrKeyRevoked
+	}
+	if err := key.checkQuota(now); err != nil {
+		return key.public(), err
+	}
+
+	key.Usage.rollover(now)
+	key.Usage.DayCount++
+	key.Usage.MonthCount++
+	key.Usage.Total++
+	ks.dirty = true
+	if time.Since(ks.savedAt) >= KeyUsageFlushInterval {
+		if err := ks.save(); err != nil {
+			return APIKey{}, err
+		}
+	}
+	return key.public(), nil
+}
+
+// requestToken extracts an API key from the X-API-Key or Authorization: Bearer header.
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		return strings.TrimSpace(token)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// authenticateRequest resolves the API key used for a request. It returns nil
+// without an error for anonymous requests coming from our own frontend. The
+// request is counted by countRequest once its input is valid.
+func authenticateRequest(r *http.Request) (*APIKey, error) {
+	token := requestToken(r)
+	if token == "" || keyStore == nil {
+		return nil, nil
+	}
+	key, err := keyStore.Authenticate(token, r.Header.Get("Origin"), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// countRequest records a valid request against key, if any.
+func countRequest(key *APIKey) error {
+	if key == nil || keyStore == nil {
+		return nil
+	}
+	_, err := keyStore.Count(key.ID, time.Now())
+	return err
+}
+
+// keyID returns the identifier used to attribute usage to a key.
+func keyID(key *APIKey) string {
+	if key == nil {
+		return "public"
+	}
+	return key.ID
+}
+
+// keyAuthStatus maps key authentication errors to HTTP status codes.
+func keyAuthStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrOriginRejected):
+		return http.StatusForbidden
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrKeyRevoked):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// adminKeyStatus maps key store errors to HTTP status codes for the admin API.
+func adminKeyStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrKeyRevoked):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type createKeyRequest struct {
+	Name           string      `json:"name"`
+	DailyQuota     int         `json:"daily_quota"`
+	MonthlyQuota   int         `json:"monthly_quota"`
+	AllowedOrigins []string    `json:"allowed_origins"`
+	Defaults       KeyDefaults `json:"defaults"`
+	Tools          []string    `json:"tools"`
+}
+
+type issuedKeyResponse struct {
+	Key   APIKey `json:"key"`
+	Token string `json:"token,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// AdminListKeys handles GET /admin/keys.
+func AdminListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := keyStore.List()
+	if err != nil {
+		logger.Error("Failed to list api keys", "error", err)
+		http.Error(w, "Error reading api keys", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// AdminCreateKey handles POST /admin/keys.
+func AdminCreateKey(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "Key name is required", http.StatusBadRequest)
+		return
+	}
+	if !chatgpt.ValidAnswerLength(req.Defaults.AnswerLength) {
+		http.Error(w, fmt.Sprintf("Unknown answer length %q", req.Defaults.AnswerLength), http.StatusBadRequest)
+		return
+	}
+	for _, name := range req.Tools {
+		if _, ok := chatgpt.Tools.Get(name); !ok {
+			http.Error(w, fmt.Sprintf("Unknown tool %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+	defaults, err := withLanguage(chatgpt.Options{}, req.Defaults.Language)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Defaults.Language = defaults.Language
+
+	key, token, err := keyStore.Create(APIKey{
+		Name:           req.Name,
+		DailyQuota:     req.DailyQuota,
+		MonthlyQuota:   req.MonthlyQuota,
+		AllowedOrigins: req.AllowedOrigins,
+		Defaults:       req.Defaults,
+		Tools:          req.Tools,
+	})
+	if err != nil {
+		logger.Error("Failed to create api key", "error", err, "name", req.Name)
+		http.Error(w, "Error creating api key", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("API key created",
+		"key_id", key.ID,
+		"name", key.Name,
+		"daily_quota", key.DailyQuota,
+		"monthly_quota", key.MonthlyQuota)
+	writeJSON(w, http.StatusCreated, issuedKeyResponse{Key: key, Token: token})
+}
+
+// AdminRevokeKey handles POST /admin/keys/{id}/revoke.
+func AdminRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	key, err := keyStore.Revoke(id)
+	if err != nil {
+		logger.Error("Failed to revoke api key", "error", err, "key_id", id)
+		http.Error(w, err.Error(), adminKeyStatus(err))
+		return
+	}
+	logger.Info("API key revoked", "key_id", id)
+	writeJSON(w, http.StatusOK, issuedKeyResponse{Key: key})
+}
+
+// AdminRotateKey handles POST /admin/keys/{id}/rotate.
+func AdminRotateKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	key, token, err := keyStore.Rotate(id)
+	if err != nil {
+		logger.Error("Failed to rotate api key", "error", err, "key_id", id)
+		http.Error(w, err.Error(), adminKeyStatus(err))
+		return
+	}
+	logger.Info("API key rotated", "key_id", id)
+	writeJSON(w, http.StatusOK, issuedKeyResponse{Key: key, Token: token})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeyStore(t *testing.T, key APIKey) (*KeyStore, APIKey, string) {
+	t.Helper()
+	ks, err := LoadKeyStore(filepath.Join(t.TempDir(), "apikeys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Name = "Udruženje penzionera"
+	created, token, err := ks.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks, created, token
+}
+
+func TestKeyStoreQuotaRollover(t *testing.T) {
+	ks, key, token := newTestKeyStore(t, APIKey{DailyQuota: 2, MonthlyQuota: 3})
+	day := time.Date(2026, time.March, 31, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if _, err := ks.Authenticate(token, "", day); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		if _, err := ks.Count(key.ID, day); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	if _, err := ks.Authenticate(token, "", day); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("third request of the day: err = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := ks.Count(key.ID, day); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("counting the third request of the day: err = %v, want ErrQuotaExceeded", err)
+	}
+
+	// The next day starts a new month, so both counts start over
+	counted, err := ks.Count(key.ID, time.Date(2026, time.April, 1, 10, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("first request of the new month: %v", err)
+	}
+	if counted.Usage.DayCount != 1 || counted.Usage.MonthCount != 1 || counted.Usage.Total != 3 {
+		t.Errorf("usage after rollover = %+v", counted.Usage)
+	}
+
+	sameMonth := time.Date(2026, time.April, 2, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, err := ks.Count(key.ID, sameMonth); err != nil {
+			t.Fatalf("request %d on the second day: %v", i+1, err)
+		}
+	}
+	if _, err := ks.Authenticate(token, "", time.Date(2026, time.April, 3, 10, 0, 0, 0, time.UTC)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("request over the monthly quota: err = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestKeyStoreRevocation(t *testing.T) {
+	ks, key, token := newTestKeyStore(t, APIKey{})
+	now := time.Now()
+	if _, err := ks.Authenticate(token, "", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Authenticate(token, "", now); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("authenticating a revoked key: err = %v, want ErrKeyRevoked", err)
+	}
+	// A request authenticated just before the revocation is not counted
+	if _, err := ks.Count(key.ID, now); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("counting for a revoked key: err = %v, want ErrKeyRevoked", err)
+	}
+	if _, err := ks.Authenticate("slk_unknown_token", "", now); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown token: err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestKeyStoreOriginRejection(t *testing.T) {
+	ks, key, token := newTestKeyStore(t, APIKey{DailyQuota: 1, AllowedOrigins: []string{"https://penzioneri.ba"}})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Authenticate(token, "https://evil.example", now); !errors.Is(err, ErrOriginRejected) {
+			t.Fatalf("foreign origin: err = %v, want ErrOriginRejected", err)
+		}
+	}
+	// Rejected requests did not use up the quota
+	if _, err := ks.Authenticate(token, "https://penzioneri.ba", now); err != nil {
+		t.Fatalf("allowed origin: %v", err)
+	}
+	if _, err := ks.Authenticate(token, "", now); err != nil {
+		t.Fatalf("server to server request: %v", err)
+	}
+	counted, err := ks.Count(key.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counted.Usage.Total != 1 {
+		t.Errorf("total = %d, want 1", counted.Usage.Total)
+	}
+}
+
+func TestKeyStoreFlushesCounts(t *testing.T) {
+	ks, key, token := newTestKeyStore(t, APIKey{})
+	if _, err := ks.Count(key.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadKeyStore(ks.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := reloaded.Lookup(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Usage.Total != 1 {
+		t.Errorf("saved total = %d, want 1", found.Usage.Total)
+	}
+}
+
+func TestAdminCreateKeyChecksDefaults(t *testing.T) {
+	ks, err := LoadKeyStore(filepath.Join(t.TempDir(), "apikeys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore = ks
+	defer func() { keyStore = nil }()
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"name":"Penzioneri","defaults":{"answer_length":"long"}}`, http.StatusCreated},
+		{`{"name":"Penzioneri"}`, http.StatusCreated},
+		{`{"name":"Penzioneri","defaults":{"answer_length":"huge"}}`, http.StatusBadRequest},
+		{`{"defaults":{"answer_length":"short"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		AdminCreateKey(rec, httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.body, rec.Code, tt.want)
+		}
+	}
+	keys, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("%d keys stored, want 2", len(keys))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.com/chatgpt"
+	"code.com/speech"
+)
+
+// Default limits for uploaded voice questions
+const (
+	defaultSTTMaxBytes   = 10 << 20
+	defaultSTTMaxSeconds = 120
+)
+
+// envInt reads a positive integer environment variable, falling back to def.
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// newTranscriber builds the speech-to-text provider from STT_* environment variables.
+func newTranscriber(clientIP string) (speech.Transcriber, error) {
+	provider := os.Getenv("STT_PROVIDER")
+	apiKey := ""
+	if provider == "" || provider == "openai" {
+		var err error
+		if apiKey, err = loadOpenAIKey(clientIP); err != nil {
+			return nil, err
+		}
+	}
+	return speech.NewTranscriber(provider, apiKey, os.Getenv("STT_MODEL"))
+}
+
+// askAudioResponse pairs the transcript of a voice question with its answer.
+type askAudioResponse struct {
+	Transcript string          `json:"transcript"`
+	Answer     json.RawMessage `json:"answer"`
+}
+
+// AskAudioHandler handles POST /v1/ask/audio. The recording is sent as the
+// "audio" field of a multipart form, transcribed and answered like a typed
+// question. An optional "language" field selects the answer language.
+func AskAudioHandler(w http.ResponseWriter, r *http.Request) {
+	clientIP := ClientIP(r)
+
+	key, err := authenticateRequest(r)
+	if err != nil {
+		logger.Warn("API key rejected", "error", err, "ip", clientIP)
+		http.Error(w, err.Error(), keyAuthStatus(err))
+		return
+	}
+	opts := chatgpt.Options{}
+	if key != nil {
+		opts = key.Options()
+	}
+
+	maxBytes := envInt("STT_MAX_BYTES", defaultSTTMaxBytes)
+	maxSeconds := envInt("STT_MAX_SECONDS", defaultSTTMaxSeconds)
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes)+1<<20)
+	file, _, err := r.FormFile("audio")
+	if err != nil {
+		logger.Error("Invalid audio upload", "error", err, "ip", clientIP)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Recording is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Missing audio file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if opts, err = withLanguage(opts, r.FormValue("language")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	audio, err := io.ReadAll(io.LimitReader(file, int64(maxBytes)+1))
+	if err != nil {
+		logger.Error("Audio read error", "error", err, "ip", clientIP)
+		http.Error(w, "Unable to read audio file", http.StatusBadRequest)
+		return
+	}
+	if len(audio) > maxBytes {
+		http.Error(w, "Recording is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	format, err := speech.DetectFormat(audio)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	// Recordings whose length cannot be checked are not sent for transcription
+	duration, err := speech.Duration(audio, format)
+	if err != nil {
+		logger.Warn("Could not determine recording duration", "error", err, "ip", clientIP, "format", format)
+		http.Error(w, "Unable to read recording", http.StatusBadRequest)
+		return
+	}
+	if duration > time.Duration(maxSeconds)*time.Second {
+		http.Error(w, "Recording is too long", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	logger.Info("Voice question received",
+		"ip", clientIP,
+		"format", format,
+		"audio_size", len(audio),
+		"duration_ms", duration.Milliseconds())
+
+	transcriber, err := newTranscriber(clientIP)
+	if err != nil {
+		logger.Error("Speech-to-text configuration error", "error", err, "ip", clientIP)
+		http.Error(w, "Speech-to-text unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	// Speech-to-text takes ISO-639-1 codes, so sr-Cyrl is passed as sr
+	hint, _, _ := strings.Cut(opts.Language, "-")
+	transcript, err := transcriber.Transcribe(ctx, audio, format, hint)
+	if err != nil {
+		logger.Error("Speech-to-text failed", "error", err, "ip", clientIP, "duration_ms", time.Since(startTime).Milliseconds())
+		http.Error(w, "Error transcribing recording", http.StatusBadGateway)
+		return
+	}
+	if transcript == "" {
+		http.Error(w, "No speech recognised in recording", http.StatusUnprocessableEntity)
+		return
+	}
+
+	logger.Info("Voice question transcribed",
+		"ip", clientIP,
+		"transcript_length", len(transcript),
+		"duration_ms", time.Since(startTime).Milliseconds())
+	requestdata.Info("Received text", "text", transcript, "ip", clientIP, "api_key", keyID(key), "transport", "audio")
+
+	apiKey, err := loadOpenAIKey(clientIP)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	prompt, err := promptFromText(transcript)
+	if err != nil {
+		http.Error(w, "JSON error response", http.StatusInternalServerError)
+		return
+	}
+	// Only recordings that could be transcribed count against the key's quota
+	if err := countRequest(key); err != nil {
+		logger.Warn("API key rejected", "error", err, "ip", clientIP, "key_id", keyID(key))
+		http.Error(w, err.Error(), keyAuthStatus(err))
+		return
+	}
+
+	opts = withModeration(opts, apiKey, clientIP, keyID(key))
+	resultingText, used := answerQuestion(r.Context(), transcript, prompt, apiKey, keyID(key), opts)
+	resultingText = saveAnswer(resultingText)
+	requestdata.Info("Resulting text", "text", resultingText, "ip", clientIP, "api_key", keyID(key), "transport", "audio", "language", answerLanguage(resultingText, transcript, opts), "total_tokens", used.TotalTokens, "cost_usd", used.CostUSD)
+
+	if !json.Valid([]byte(resultingText)) {
+		logger.Error("Question could not be answered", "error", resultingText, "ip", clientIP, "transport", "audio")
+		http.Error(w, answerFailedMessage, http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, askAudioResponse{Transcript: transcript, Answer: json.RawMessage(resultingText)})
+}
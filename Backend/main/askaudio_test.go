@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// wavRecording builds a 16 kHz mono PCM recording with the given data chunk.
+func wavRecording(data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(data)))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(16000), uint32(32000), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestAskAudioHandlerChecksDuration(t *testing.T) {
+	t.Setenv("STT_MAX_SECONDS", "2")
+	t.Setenv("STT_PROVIDER", "stub")
+
+	tests := []struct {
+		name  string
+		audio []byte
+		want  int
+	}{
+		{"unreadable duration", wavRecording(nil)[:36], http.StatusBadRequest},
+		{"too long", wavRecording(make([]byte, 3*32000)), http.StatusRequestEntityTooLarge},
+		{"unsupported format", []byte("ID3\x04 not a recording"), http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("audio", "question.wav")
+		part.Write(tt.audio)
+		form.Close()
+
+		r := httptest.NewRequest("POST", "/v1/ask/audio", &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		AskAudioHandler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.com/speech"
+)
+
+// audioLocks serialises generation of the same file. Paths share a fixed
+// set of locks so the set does not grow with the number of answers.
+var audioLocks [64]sync.Mutex
+
+// audioLock returns the lock guarding path.
+func audioLock(path string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return &audioLocks[h.Sum32()%uint32(len(audioLocks))]
+}
+
+// audioVariant identifies the text-to-speech provider, model and voice, so
+// that changing them does not serve audio cached with the previous ones.
+func audioVariant() string {
+	h := fnv.New32a()
+	for _, name := range []string{"TTS_PROVIDER", "TTS_MODEL", "TTS_VOICE"} {
+		h.Write([]byte(os.Getenv(name) + "\x00"))
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// newSynthesizer builds the text-to-speech provider from TTS_* environment variables.
+func newSynthesizer(clientIP string) (speech.Synthesizer, error) {
+	provider := os.Getenv("TTS_PROVIDER")
+	apiKey := ""
+	if provider == "" || provider == "openai" {
+		var err error
+		if apiKey, err = loadOpenAIKey(clientIP); err != nil {
+			return nil, err
+		}
+	}
+	return speech.NewSynthesizer(provider, apiKey, os.Getenv("TTS_MODEL"), os.Getenv("TTS_VOICE"))
+}
+
+// AnswerAudio handles GET /v1/answers/{id}/audio. The "part" query parameter
+// selects the shortresponse ("short", default) or the longresponse ("long").
+func AnswerAudio(w http.ResponseWriter, r *http.Request) {
+	clientIP := ClientIP(r)
+
+	id := r.PathValue("id")
+	part := r.URL.Query().Get("part")
+	if part == "" {
+		part = "short"
+	}
+	if part != "short" && part != "long" {
+		http.Error(w, "part must be short or long", http.StatusBadRequest)
+		return
+	}
+
+	answer, err := answerStore.Load(id)
+	if err != nil {
+		if errors.Is(err, ErrAnswerNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to load answer", "error", err, "answer_id", id, "ip", clientIP)
+		http.Error(w, "Error loading answer", http.StatusInternalServerError)
+		return
+	}
+
+	synth, err := newSynthesizer(clientIP)
+	if err != nil {
+		logger.Error("Text-to-speech configuration error", "error", err, "ip", clientIP)
+		http.Error(w, "Text-to-speech unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	// Generated audio is cached in the data directory, one file per answer,
+	// part and voice. The answer store removes it when the answer expires.
+	audioCacheDir := cfg.dataPath("audio")
+	path := filepath.Join(audioCacheDir, id+"-"+part+"-"+audioVariant()+"."+synth.Extension())
+	lock := audioLock(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		logger.Info("Serving cached answer audio", "answer_id", id, "part", part, "ip", clientIP)
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	text := answer.Content.Shortresponse
+	if part == "long" {
+		text = speech.PlainText(answer.Content.Longresponse)
+	}
+	if text == "" {
+		http.Error(w, "Answer has no text for this part", http.StatusNotFound)
+		return
+	}
+
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	audio, contentType, err := synth.Synthesize(ctx, text)
+	if err != nil {
+		logger.Error("Text-to-speech failed",
+			"error", err,
+			"answer_id", id,
+			"part", part,
+			"ip", clientIP,
+			"duration_ms", time.Since(startTime).Milliseconds())
+		http.Error(w, "Error generating audio", http.StatusBadGateway)
+		return
+	}
+
+	if err := os.MkdirAll(audioCacheDir, 0755); err == nil {
+		err = os.WriteFile(path, audio, 0644)
+	}
+
+	logger.Info("Answer audio generated",
+		"answer_id", id,
+		"part", part,
+		"ip", clientIP,
+		"text_length", len(text),
+		"audio_size", len(audio),
+		"duration_ms", time.Since(startTime).Milliseconds())
+
+	if err != nil {
+		logger.Error("Failed to cache answer audio", "error", err, "path", path)
+		w.Header().Set("Content-Type", contentType)
+		w.Write(audio)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
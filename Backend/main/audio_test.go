@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAnswerStore stores answers and their audio in a temporary data
+// directory and returns the ID of a stored answer.
+func newTestAnswerStore(t *testing.T) string {
+	t.Helper()
+	t.Setenv("TTS_PROVIDER", "stub")
+	dataDir := cfg.DataDir
+	cfg.DataDir = t.TempDir()
+	t.Cleanup(func() { cfg.DataDir = dataDir; answerStore = nil })
+
+	var err error
+	answerStore, err = NewAnswerStore(cfg.dataPath("answers"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(answerStore.Save(answerWithUsage)), &saved); err != nil {
+		t.Fatal(err)
+	}
+	return saved.ID
+}
+
+func getAudio(id, part string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/v1/answers/"+id+"/audio?part="+part, nil)
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	AnswerAudio(w, r)
+	return w
+}
+
+func cachedAudio(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(cfg.dataPath("audio"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestAnswerAudio(t *testing.T) {
+	id := newTestAnswerStore(t)
+
+	tests := []struct {
+		name, id, part string
+		want           int
+	}{
+		{"short", id, "short", http.StatusOK},
+		{"long", id, "long", http.StatusOK},
+		{"unknown part", id, "title", http.StatusBadRequest},
+		{"unknown answer", strings.Repeat("0", 24), "short", http.StatusNotFound},
+		{"invalid id", "../keys", "short", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := getAudio(tt.id, tt.part)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.want == http.StatusOK && !strings.HasPrefix(w.Body.String(), "RIFF") {
+			t.Errorf("%s: body is not WAV audio", tt.name)
+		}
+	}
+	if n := len(cachedAudio(t)); n != 2 {
+		t.Errorf("%d cached files, want one per part", n)
+	}
+}
+
+func TestAnswerAudioCachedPerVoice(t *testing.T) {
+	id := newTestAnswerStore(t)
+
+	t.Setenv("TTS_VOICE", "alloy")
+	getAudio(id, "short")
+	getAudio(id, "short")
+	if n := len(cachedAudio(t)); n != 1 {
+		t.Fatalf("%d cached files after asking twice, want 1", n)
+	}
+	t.Setenv("TTS_VOICE", "nova")
+	getAudio(id, "short")
+	t.Setenv("TTS_MODEL", "tts-1-hd")
+	getAudio(id, "short")
+	if n := len(cachedAudio(t)); n != 3 {
+		t.Errorf("%d cached files, want one per voice and model", n)
+	}
+}
+
+func TestAnswerStoreExpiry(t *testing.T) {
+	id := newTestAnswerStore(t)
+	if w := getAudio(id, "short"); w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+
+	answerStore.expire(cfg.dataPath("audio"), time.Now())
+	if _, err := answerStore.Load(id); err != nil {
+		t.Fatalf("answer expired before its TTL: %v", err)
+	}
+
+	// An answer past its TTL is gone even before the expiry loop runs
+	old := time.Now().Add(-2 * time.Hour)
+	files := []string{filepath.Join(answerStore.dir, id+".json")}
+	for _, name := range cachedAudio(t) {
+		files = append(files, filepath.Join(cfg.dataPath("audio"), name))
+	}
+	for _, f := range files {
+		if err := os.Chtimes(f, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := getAudio(id, "short"); w.Code != http.StatusNotFound {
+		t.Errorf("expired answer: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	answerStore.expire(cfg.dataPath("audio"), time.Now())
+	for _, f := range files {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", filepath.Base(f), err)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"code.com/chatgpt"
+)
+
+// BudgetLevel is how far the service is degraded to stay within budget.
+type BudgetLevel int
+
+const (
+	BudgetNormal     BudgetLevel = iota
+	BudgetNoSearch               // the search_google tool is withheld
+	BudgetCheapModel             // questions go to the cheap model, without search
+	BudgetCachedOnly             // only cached and FAQ answers are served
+)
+
+func (l BudgetLevel) String() string {
+	switch l {
+	case BudgetNoSearch:
+		return "no_search"
+	case BudgetCheapModel:
+		return "cheap_model"
+	case BudgetCachedOnly:
+		return "cached_only"
+	}
+	return "normal"
+}
+
+func (l BudgetLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// Budget tracks spend against daily and monthly limits and picks the
+// degradation level. A zero limit is not enforced.
+type Budget struct {
+	DailyLimit   float64
+	MonthlyLimit float64
+	// Thresholds are the fractions of a limit at which BudgetNoSearch,
+	// BudgetCheapModel and BudgetCachedOnly start.
+	Thresholds [3]float64
+	// CheapModel answers from BudgetCheapModel on. It must be a cheaper
+	// model of the configured provider.
+	CheapModel string
+	// AlertURL, when set, receives a JSON {"text": ...} POST whenever the
+	// level rises, which Slack and Mattermost webhooks understand.
+	AlertURL string
+
+	mu         sync.Mutex
+	day        string
+	month      string
+	spentDay   float64
+	spentMonth float64
+	level      BudgetLevel
+}
+
+// BudgetState is the budget as shown on the stats dashboard.
+type BudgetState struct {
+	Level        BudgetLevel `json:"level"`
+	DailyLimit   float64     `json:"daily_limit"`
+	MonthlyLimit float64     `json:"monthly_limit"`
+	SpentToday   float64     `json:"spent_today"`
+	SpentMonth   float64     `json:"spent_month"`
+	Thresholds   [3]float64  `json:"thresholds"`
+	CheapModel   string      `json:"cheap_model"`
+}
+
+var budget *Budget
+
+// budgetFromEnv configures the budget from the BUDGET_* environment
+// variables and the spend already in the usage log. It returns nil if no
+// limit is set.
+func budgetFromEnv(entries []LogEntry) (*Budget, error) {
+	b := &Budget{
+		Thresholds: [3]float64{0.8, 0.9, 1.0},
+		CheapModel: os.Getenv("BUDGET_CHEAP_MODEL"),
+		AlertURL:   os.Getenv("BUDGET_ALERT_URL"),
+	}
+	for name, limit := range map[string]*float64{"BUDGET_DAILY_USD": &b.DailyLimit, "BUDGET_MONTHLY_USD": &b.MonthlyLimit} {
+		if v := os.Getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*limit = f
+		}
+	}
+	if b.DailyLimit == 0 && b.MonthlyLimit == 0 {
+		return nil, nil
+	}
+	if v := os.Getenv("BUDGET_THRESHOLDS"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("BUDGET_THRESHOLDS needs three fractions, got %q", v)
+		}
+		for i, part := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || f <= 0 || (i > 0 && f < b.Thresholds[i-1]) {
+				return nil, fmt.Errorf("invalid BUDGET_THRESHOLDS %q", v)
+			}
+			b.Thresholds[i] = f
+		}
+	}
+	if err := checkCheapModel(context.Background(), cfg.Provider, b.CheapModel); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	b.day, b.month = now.Format("2006-01-02"), now.Format("2006-01")
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	costs := collectCosts(entries, monthStart)
+	b.spentMonth = costs.Total.CostUSD
+	for _, row := range costs.ByDay {
+		if row.Group == b.day {
+			b.spentDay = row.CostUSD
+		}
+	}
+	b.level = b.levelFor()
+	return b, nil
+}
+
+// checkCheapModel checks that the cheap model is set, differs from the
+// model that normally answers and, as far as the provider reports, keeps
+// its tools and vision, so that switching to it does not silently stop
+// search or photo questions.
+func checkCheapModel(ctx context.Context, provider chatgpt.ProviderConfig, cheap string) error {
+	if cheap == "" {
+		return fmt.Errorf("BUDGET_CHEAP_MODEL is required when a budget limit is set")
+	}
+	client, model, err := chatgpt.NewProvider(provider, "")
+	if err != nil {
+		return err
+	}
+	if cheap == model {
+		return fmt.Errorf("BUDGET_CHEAP_MODEL %s is the model that already answers questions", cheap)
+	}
+	reporter, ok := client.(chatgpt.CapabilityReporter)
+	if !ok {
+		return nil
+	}
+	normal, reduced := reporter.Capabilities(ctx, model), reporter.Capabilities(ctx, cheap)
+	switch {
+	case normal.Tools && !reduced.Tools:
+		return fmt.Errorf("BUDGET_CHEAP_MODEL %s does not support tools, which %s uses for search", cheap, model)
+	case normal.Vision && !reduced.Vision:
+		return fmt.Errorf("BUDGET_CHEAP_MODEL %s does not support images, which %s reads", cheap, model)
+	}
+	return nil
+}
+
+// levelFor returns the level for the current spend. b.mu must be held.
+func (b *Budget) levelFor() BudgetLevel {
+	fraction := 0.0
+	if b.DailyLimit > 0 {
+		fraction = b.spentDay / b.DailyLimit
+	}
+	if b.MonthlyLimit > 0 {
+		fraction = max(fraction, b.spentMonth/b.MonthlyLimit)
+	}
+	level := BudgetNormal
+	for i, threshold := range b.Thresholds {
+		if fraction >= threshold {
+			level = BudgetLevel(i + 1)
+		}
+	}
+	return level
+}
+
+// rollover starts a new day or month. b.mu must be held.
+func (b *Budget) rollover(now time.Time) {
+	if day := now.Format("2006-01-02"); day != b.day {
+		b.day, b.spentDay = day, 0
+	}
+	if month := now.Format("2006-01"); month != b.month {
+		b.month, b.spentMonth = month, 0
+	}
+}
+
+// Level returns the current degradation level.
+func (b *Budget) Level() BudgetLevel {
+	if b == nil {
+		return BudgetNormal
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(time.Now())
+	if level := b.levelFor(); level != b.level {
+		logger.Info("Budget level changed", "from", b.level.String(), "to", level.String())
+		b.level = level
+	}
+	return b.level
+}
+
+// Record adds the cost of an answer and alerts admins when that raises the level.
+func (b *Budget) Record(cost float64) {
+	if b == nil || cost == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.rollover(time.Now())
+	b.spentDay += cost
+	b.spentMonth += cost
+	previous, level := b.level, b.levelFor()
+	b.level = level
+	state := b.stateLocked()
+	b.mu.Unlock()
+
+	if level > previous {
+		b.alert(previous, state)
+	}
+}
+
+func (b *Budget) alert(previous BudgetLevel, state BudgetState) {
+	attrs := []any{
+		"from", previous.String(),
+		"to", state.Level.String(),
+		"spent_today", state.SpentToday,
+		"daily_limit", state.DailyLimit,
+		"spent_month", state.SpentMonth,
+		"monthly_limit", state.MonthlyLimit,
+	}
+	logger.Warn("Budget threshold crossed", attrs...)
+	if auditlog != nil {
+		auditlog.Warn("Budget threshold crossed", attrs...)
+	}
+	if b.AlertURL == "" {
+		return
+	}
+
+	text := fmt.Sprintf("SeniorLabAI budget: service degraded to %s (today $%.2f of $%.2f, this month $%.2f of $%.2f)",
+		state.Level, state.SpentToday, state.DailyLimit, state.SpentMonth, state.MonthlyLimit)
+	body, _ := json.Marshal(map[string]string{"text": text})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.AlertURL, bytes.NewReader(body))
+		if err != nil {
+			logger.Error("Failed to send budget alert", "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			logger.Error("Failed to send budget alert", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logger.Error("Budget alert rejected", "status", resp.StatusCode)
+		}
+	}()
+}
+
+// State returns the budget for the dashboard.
+func (b *Budget) State() BudgetState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(time.Now())
+	b.level = b.levelFor()
+	return b.stateLocked()
+}
+
+func (b *Budget) stateLocked() BudgetState {
+	return BudgetState{
+		Level:        b.level,
+		DailyLimit:   b.DailyLimit,
+		MonthlyLimit: b.MonthlyLimit,
+		SpentToday:   b.spentDay,
+		SpentMonth:   b.spentMonth,
+		Thresholds:   b.Thresholds,
+		CheapModel:   b.CheapModel,
+	}
+}
+
+// analyseQuestion runs the pipeline for answerQuestion. Tests replace it.
+var analyseQuestion = chatgpt.ChatGPTAnalyseUsage
+
+// answerQuestion answers a question within the budget: depending on the
+// level it withholds search, switches to the cheap model or only serves
+// cached and FAQ answers. keyID is the partner key the question was asked
+// with. While a budget is configured, answers are kept for cached-only mode.
+// The answer is bounded by ANSWER_TIMEOUT, so that retries and fallbacks
+// give up in time even when ctx has no deadline of its own.
+func answerQuestion(ctx context.Context, question, prompt, apiKey, keyID string, opts chatgpt.Options) (string, chatgpt.Usage) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.AnswerTimeout)
+	defer cancel()
+
+	level := budget.Level()
+	if level >= BudgetCachedOnly {
+		logger.Info("Serving cached answer only", "budget_level", level.String())
+		return cachedAnswers.lookup(question, keyID, opts), chatgpt.Usage{}
+	}
+	if level >= BudgetNoSearch {
+		opts.DisableSearch = true
+	}
+	if level >= BudgetCheapModel {
+		opts.Model = budget.CheapModel
+	}
+
+	// The usage comes from the pipeline rather than the answer JSON, since
+	// failures are plain text but still spend tokens
+	resultingText, used := analyseQuestion(ctx, prompt, apiKey, opts)
+	budget.Record(used.CostUSD)
+	if budget != nil && len(opts.History) == 0 && len(opts.Images) == 0 {
+		cachedAnswers.put(question, keyID, opts, resultingText)
+	}
+	return resultingText, used
+}
+
+// limitedAnswer is served in cached-only mode when no answer fits.
+const limitedAnswer = "Zbog velikog broja pitanja trenutno odgovaramo samo na već postavljena i česta pitanja. Molimo pokušajte ponovo kasnije."
+
+// answerCache remembers recent answers by question, language, answer
+// length and API key, next to the FAQ answers loaded from
+// DATA_DIR/faq.json.
+type answerCache struct {
+	mu      sync.Mutex
+	max     int
+	answers map[string]string
+	order   []string
+	// faq holds the FAQ answers by language and normalized question.
+	faq map[string]map[string]string
+}
+
+var cachedAnswers = &answerCache{max: 5000, answers: map[string]string{}, faq: map[string]map[string]string{}}
+
+// faqEntry is an entry of faq.json.
+type faqEntry struct {
+	Question      string `json:"question"`
+	Longresponse  string `json:"longresponse"`
+	Shortresponse string `json:"shortresponse"`
+	Title         string `json:"title"`
+	// Language is the language of the answer; it defaults to bs.
+	Language string `json:"language,omitempty"`
+}
+
+// loadFAQ loads the FAQ answers. A missing file means no FAQ.
+func (c *answerCache) loadFAQ(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []faqEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		language, ok := chatgpt.NormalizeLanguage(e.Language)
+		if !ok {
+			language = chatgpt.DefaultLanguage
+		}
+		answer, _ := json.Marshal(map[string]any{
+			"content": map[string]string{
+				"longresponse":  e.Longresponse,
+				"shortresponse": e.Shortresponse,
+				"title":         e.Title,
+			},
+			"internet_search": false,
+			"cached":          true,
+			"language":        language,
+		})
+		if c.faq[language] == nil {
+			c.faq[language] = map[string]string{}
+		}
+		c.faq[language][normalizeQuestion(e.Question)] = string(answer)
+	}
+	return nil
+}
+
+// normalizeQuestion reduces a question to lower case words.
+func normalizeQuestion(q string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// cacheKey identifies a cached answer. Answers are only served again for
+// the same question asked in the same language and length with the same
+// key. The language is resolved as the pipeline does, so a question without
+// one matches the answer in the language it was written in.
+func cacheKey(question, keyID string, opts chatgpt.Options) string {
+	language := chatgpt.ResolveLanguage(opts.Language, question)
+	return strings.Join([]string{keyID, language, opts.AnswerLength, normalizeQuestion(question)}, "\x00")
+}
+
+// put caches an answer without its ID and usage, so that serving it again
+// neither points at the original nor counts its cost twice.
+func (c *answerCache) put(question, keyID string, opts chatgpt.Options, resultingText string) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resultingText), &fields); err != nil || fields["content"] == nil || fields["moderated"] != nil {
+		return
+	}
+	delete(fields, "id")
+	delete(fields, "usage")
+	delete(fields, "trace")
+	fields["cached"] = json.RawMessage("true")
+	if fields["language"] == nil {
+		fields["language"], _ = json.Marshal(chatgpt.ResolveLanguage(opts.Language, question))
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+
+	key := cacheKey(question, keyID, opts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.answers[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.answers[key] = string(data)
+	if len(c.order) > c.max {
+		delete(c.answers, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// lookup returns the answer cached for exactly this question, else the FAQ
+// answer of the most similar FAQ question, or limitedAnswer if none is
+// similar enough. Cached answers are not matched loosely: they were written
+// for one user's question.
+func (c *answerCache) lookup(question, keyID string, opts chatgpt.Options) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if answer, ok := c.answers[cacheKey(question, keyID, opts)]; ok {
+		return answer
+	}
+	faq := c.faq[chatgpt.ResolveLanguage(opts.Language, question)]
+	normalized := normalizeQuestion(question)
+	if answer, ok := faq[normalized]; ok {
+		return answer
+	}
+
+	best, bestScore := "", 0.6
+	for q, answer := range faq {
+		if score := wordOverlap(normalized, q); score >= bestScore {
+			best, bestScore = answer, score
+		}
+	}
+	if best != "" {
+		return best
+	}
+	data, _ := json.Marshal(map[string]any{
+		"content": map[string]string{
+			"longresponse":  limitedAnswer,
+			"shortresponse": limitedAnswer,
+			"title":         "Ograničena usluga",
+		},
+		"internet_search": false,
+		"budget_limited":  true,
+		"language":        chatgpt.DefaultLanguage,
+	})
+	return string(data)
+}
+
+// wordOverlap is the Jaccard similarity of the words of two normalized questions.
+func wordOverlap(a, b string) float64 {
+	words := map[string]int{}
+	for _, w := range strings.Fields(a) {
+		words[w] |= 1
+	}
+	for _, w := range strings.Fields(b) {
+		words[w] |= 2
+	}
+	both := 0
+	for _, v := range words {
+		if v == 3 {
+			both++
+		}
+	}
+	if len(words) == 0 {
+		return 0
+	}
+	return float64(both) / float64(len(words))
+}
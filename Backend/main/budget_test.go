@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.com/chatgpt"
+)
+
+func TestBudgetLevelFor(t *testing.T) {
+	tests := []struct {
+		spentDay, spentMonth float64
+		want                 BudgetLevel
+	}{
+		{0, 0, BudgetNormal},
+		{7.9, 7.9, BudgetNormal},
+		{8, 8, BudgetNoSearch},
+		{9.5, 9.5, BudgetCheapModel},
+		{10, 10, BudgetCachedOnly},
+		// The monthly limit applies even when today is cheap
+		{1, 95, BudgetCheapModel},
+	}
+	for _, tt := range tests {
+		b := &Budget{DailyLimit: 10, MonthlyLimit: 100, Thresholds: [3]float64{0.8, 0.9, 1.0}, spentDay: tt.spentDay, spentMonth: tt.spentMonth}
+		if got := b.levelFor(); got != tt.want {
+			t.Errorf("levelFor(day %.2f, month %.2f) = %s, want %s", tt.spentDay, tt.spentMonth, got, tt.want)
+		}
+	}
+
+	onlyMonthly := &Budget{MonthlyLimit: 100, Thresholds: [3]float64{0.8, 0.9, 1.0}, spentDay: 50, spentMonth: 50}
+	if got := onlyMonthly.levelFor(); got != BudgetNormal {
+		t.Errorf("levelFor without a daily limit = %s, want normal", got)
+	}
+}
+
+func TestBudgetRollover(t *testing.T) {
+	b := &Budget{day: "2026-03-31", month: "2026-03", spentDay: 4, spentMonth: 40}
+
+	b.rollover(time.Date(2026, time.March, 31, 23, 0, 0, 0, time.Local))
+	if b.spentDay != 4 || b.spentMonth != 40 {
+		t.Errorf("same day: spent %.2f/%.2f", b.spentDay, b.spentMonth)
+	}
+	b.rollover(time.Date(2026, time.April, 1, 0, 30, 0, 0, time.Local))
+	if b.day != "2026-04-01" || b.spentDay != 0 || b.month != "2026-04" || b.spentMonth != 0 {
+		t.Errorf("new month: %+v", b.stateLocked())
+	}
+	b.spentDay, b.spentMonth = 3, 30
+	b.rollover(time.Date(2026, time.April, 2, 8, 0, 0, 0, time.Local))
+	if b.spentDay != 0 || b.spentMonth != 30 {
+		t.Errorf("new day: spent %.2f/%.2f, want 0/30", b.spentDay, b.spentMonth)
+	}
+}
+
+func TestBudgetRecordAlerts(t *testing.T) {
+	alerts := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		alerts <- body.Text
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	b := &Budget{
+		DailyLimit: 10,
+		Thresholds: [3]float64{0.8, 0.9, 1.0},
+		CheapModel: "gpt-4.1-nano",
+		AlertURL:   srv.URL,
+		day:        now.Format("2006-01-02"),
+		month:      now.Format("2006-01"),
+	}
+
+	b.Record(5)
+	b.Record(3.5)
+	select {
+	case text := <-alerts:
+		if !strings.Contains(text, "no_search") || !strings.Contains(text, "$8.50 of $10.00") {
+			t.Errorf("alert = %q", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert when the first threshold was crossed")
+	}
+
+	// Spending within the same level does not alert again
+	b.Record(0.1)
+	b.Record(2)
+	select {
+	case text := <-alerts:
+		if !strings.Contains(text, "cached_only") {
+			t.Errorf("alert = %q, want cached_only", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert when the last threshold was crossed")
+	}
+	select {
+	case text := <-alerts:
+		t.Errorf("unexpected alert %q", text)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := b.Level(); got != BudgetCachedOnly {
+		t.Errorf("level = %s, want cached_only", got)
+	}
+}
+
+func TestCheckCheapModel(t *testing.T) {
+	// An Ollama server that reports what each model supports
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		caps := map[string][]string{
+			"llama3.2-vision": {"completion", "tools", "vision"},
+			"llama3.1":        {"completion", "tools"},
+			"gemma2:2b":       {"completion"},
+		}[req.Model]
+		json.NewEncoder(w).Encode(map[string][]string{"capabilities": caps})
+	}))
+	defer ollama.Close()
+	local := func(model string) chatgpt.ProviderConfig {
+		return chatgpt.ProviderConfig{Name: chatgpt.ProviderOpenAICompatible, BaseURL: ollama.URL + "/v1", Model: model}
+	}
+
+	tests := []struct {
+		provider chatgpt.ProviderConfig
+		cheap    string
+		ok       bool
+	}{
+		{chatgpt.ProviderConfig{}, "", false},
+		{chatgpt.ProviderConfig{}, chatgpt.DefaultModel, false},
+		{chatgpt.ProviderConfig{Model: "gpt-4o"}, "gpt-4o-mini", true},
+		{chatgpt.ProviderConfig{Name: chatgpt.ProviderAnthropic}, "claude-3-haiku-20240307", true},
+		{local("llama3.2-vision"), "llama3.1", false},
+		{local("llama3.1"), "gemma2:2b", false},
+		{local("llama3.1"), "llama3.2-vision", true},
+		{local("gemma2:2b"), "llama3.1", true},
+		{chatgpt.ProviderConfig{Name: chatgpt.ProviderOpenAICompatible, BaseURL: ollama.URL + "/v1", Model: "llama3.1", Capabilities: "tools"}, "gemma2:2b", true},
+	}
+	for _, tt := range tests {
+		err := checkCheapModel(context.Background(), tt.provider, tt.cheap)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkCheapModel(%+v, %q) = %v, want ok %v", tt.provider, tt.cheap, err, tt.ok)
+		}
+	}
+}
+
+func TestAnswerQuestionDeadline(t *testing.T) {
+	saved := cfg.AnswerTimeout
+	cfg.AnswerTimeout = time.Minute
+	t.Cleanup(func() { cfg.AnswerTimeout = saved; analyseQuestion = chatgpt.ChatGPTAnalyseUsage })
+	var deadline time.Time
+	var ok bool
+	analyseQuestion = func(ctx context.Context, prompt, apikey string, opts chatgpt.Options) (string, chatgpt.Usage) {
+		deadline, ok = ctx.Deadline()
+		return `{"content":{}}`, chatgpt.Usage{}
+	}
+
+	answerQuestion(context.Background(), "Zdravo", `{"received":"Zdravo"}`, "", "", chatgpt.Options{})
+	if !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("deadline = %v (set %v), want within ANSWER_TIMEOUT", deadline, ok)
+	}
+
+	// A request's own earlier deadline is kept
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	answerQuestion(ctx, "Zdravo", `{"received":"Zdravo"}`, "", "", chatgpt.Options{})
+	if time.Until(deadline) > time.Second {
+		t.Errorf("deadline %v is later than the request's", deadline)
+	}
+}
+
+func TestAnswerCacheLookup(t *testing.T) {
+	c := &answerCache{max: 10, answers: map[string]string{}, faq: map[string]map[string]string{
+		"bs": {normalizeQuestion("Koji su brojevi hitnih službi?"): `{"content":{"title":"FAQ"}}`},
+	}}
+	answer := `{"content":{"longresponse":"Penzija se isplaćuje desetog.","shortresponse":"Desetog.","title":"Penzija"},"usage":{"total_tokens":10}}`
+	opts := chatgpt.Options{Language: "bs", AnswerLength: "normal"}
+	c.put("Kada se isplaćuje penzija?", "k1", opts, answer)
+
+	title := func(result string) string {
+		var a struct {
+			Content struct {
+				Title string `json:"title"`
+			} `json:"content"`
+			Usage *struct{} `json:"usage"`
+		}
+		json.Unmarshal([]byte(result), &a)
+		if a.Usage != nil {
+			t.Errorf("cached answer carries usage: %s", result)
+		}
+		return a.Content.Title
+	}
+
+	if got := title(c.lookup("kada se isplaćuje penzija", "k1", opts)); got != "Penzija" {
+		t.Errorf("same question: title %q, want Penzija", got)
+	}
+	for name, lookup := range map[string]func() string{
+		"other key": func() string { return c.lookup("Kada se isplaćuje penzija?", "k2", opts) },
+		"other length": func() string {
+			return c.lookup("Kada se isplaćuje penzija?", "k1", chatgpt.Options{Language: "bs", AnswerLength: "long"})
+		},
+		"similar question": func() string { return c.lookup("Kada se isplaćuje penzija u Tuzli?", "k1", opts) },
+	} {
+		if got := title(lookup()); got != "Ograničena usluga" {
+			t.Errorf("%s: title %q, want the limited service answer", name, got)
+		}
+	}
+	if got := title(c.lookup("Koji su brojevi hitnih službi u BiH", "k2", opts)); got != "FAQ" {
+		t.Errorf("similar FAQ question: title %q, want FAQ", got)
+	}
+}
+
+func TestAnswerCacheLanguage(t *testing.T) {
+	c := &answerCache{max: 10, answers: map[string]string{}, faq: map[string]map[string]string{
+		"bs": {normalizeQuestion("Koji su brojevi hitnih službi?"): `{"content":{"title":"FAQ"}}`},
+	}}
+	question := "Kada se isplaćuje penzija?"
+	// Without a language the answer is in the language of the question
+	c.put(question, "k1", chatgpt.Options{AnswerLength: "normal"}, `{"content":{"title":"Penzija"}}`)
+
+	for _, tt := range []struct {
+		language string
+		cached   bool
+	}{
+		{"", true},
+		{"bs", true},
+		{"en", false},
+		{"sr-Cyrl", false},
+	} {
+		opts := chatgpt.Options{Language: tt.language, AnswerLength: "normal"}
+		if got := !strings.Contains(c.lookup(question, "k1", opts), "budget_limited"); got != tt.cached {
+			t.Errorf("language %q: cached answer served = %v, want %v", tt.language, got, tt.cached)
+		}
+		if got := !strings.Contains(c.lookup("Koji su brojevi hitnih službi?", "k1", opts), "budget_limited"); got != tt.cached {
+			t.Errorf("language %q: FAQ answer served = %v, want %v", tt.language, got, tt.cached)
+		}
+	}
+}
+
+func TestCachedAnswersRecordLanguage(t *testing.T) {
+	c := &answerCache{max: 10, answers: map[string]string{}, faq: map[string]map[string]string{}}
+	path := filepath.Join(t.TempDir(), "faq.json")
+	writeTestFile(t, path, `[{"question":"Koji su brojevi hitnih službi?","title":"Hitne službe"},`+
+		`{"question":"What are the emergency numbers?","title":"Emergency","language":"en"}]`)
+	if err := c.loadFAQ(path); err != nil {
+		t.Fatal(err)
+	}
+	c.put("Gde je najbliža apoteka?", "k1", chatgpt.Options{}, `{"content":{"title":"Apoteka"}}`)
+
+	for question, want := range map[string]string{
+		"Koji su brojevi hitnih službi?":  "bs",
+		"What are the emergency numbers?": "en",
+		"Gde je najbliža apoteka?":        "sr",
+		"Kada je sljedeći praznik?":       "bs",
+	} {
+		answer := c.lookup(question, "k1", chatgpt.Options{})
+		var a struct {
+			Language string `json:"language"`
+		}
+		json.Unmarshal([]byte(answer), &a)
+		if a.Language != want {
+			t.Errorf("%s: answer language %q, want %q: %s", question, a.Language, want, answer)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"code.com/chatgpt"
+	"code.com/webpagescraper"
+)
+
+const usage = `usage: seniorlabai <command> [flags] [args]
+
+commands:
+  serve [-port N]                         run the HTTP server (default)
+  ask [-language L] [-length L] QUESTION  answer a question and print the JSON
+  search [-count N] QUERY                 print the search results given to the model
+  scrape URL                              print the scraped content of a page
+  tokens FILE                             count the tokens in a file ("-" for stdin)
+  logs [flags] [QUERY]                    filter a log file offline
+  eval -b CONFIG [flags]                  compare two configurations on a question set
+  cost-report [-since D] [-by G]          print spend per day, api key, client and language
+  kb-ingest [-index F] DIR...             index the documents of the knowledge base
+  kb-search [-count N] QUERY              print the knowledge base passages for a query
+  med-import [-o F] FILE                  import a CSV or JSON dataset of registered medicines
+  keys <command>                          manage partner api keys
+
+configuration is read from the .env file (ENV_FILE) and the environment:
+LOG_DIR, DATA_DIR, PORT, SEARXNG_URL,
+LLM_PROVIDER (openai, openai-compatible, anthropic), LLM_BASE_URL, LLM_API_KEY,
+LLM_MODEL, LLM_CAPABILITIES,
+MODERATION_PROVIDER (openai, stub or off; off by default without OPENAI_API_KEY),
+MODERATION_FAIL_OPEN (true answers unscreened questions when moderation fails),
+LLM_FALLBACKS (e.g. anthropic:claude-3-5-haiku-latest,openai:gpt-4o), LLM_ATTEMPT_TIMEOUT,
+ANSWER_TIMEOUT (default 2m, bounds a whole answer including retries and fallbacks),
+PRICE_TABLE (JSON file of {"model": {"input": USD, "output": USD}} per million tokens),
+BUDGET_DAILY_USD, BUDGET_MONTHLY_USD, BUDGET_THRESHOLDS (e.g. 0.8,0.9,1.0 for no search,
+cheap model, cached answers only), BUDGET_CHEAP_MODEL (required with a limit), BUDGET_ALERT_URL,
+TOOLS (comma separated tool names, "all" or "none"),
+HOLIDAYS_FILE (JSON holiday rules for the calendar tool),
+RATES_FILE (JSON of {"USD": KM} exchange rates for the calculate tool; EUR is fixed),
+EMBEDDINGS (hash or provider:model@baseurl, default openai:text-embedding-3-small),
+KNOWLEDGE_INDEX (default DATA_DIR/knowledge.idx),
+MEDICATIONS_FILE (default DATA_DIR/medications.json),
+GTFS_FEEDS (comma separated GTFS zip files or directories, e.g. the GRAS Sarajevo feed)
+`
+
+// run dispatches to a subcommand and returns the exit code.
+func run(args []string) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return 1
+	}
+
+	switch command {
+	case "serve":
+		return runServe(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	}
+
+	// Only the server writes to the log files; subcommands report problems on stderr
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	requestdata = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	auditlog = logger
+	chatgpt.LogHandler = logger.Handler()
+	webpagescraper.LogHandler = logger.Handler()
+
+	switch command {
+	case "ask":
+		return runAskCommand(args)
+	case "search":
+		return runSearchCommand(args)
+	case "scrape":
+		return runScrapeCommand(args)
+	case "tokens":
+		return runTokensCommand(args)
+	case "logs":
+		return runLogsCommand(args)
+	case "keys":
+		return runKeysCommand(args)
+	case "eval":
+		return runEvalCommand(args)
+	case "cost-report":
+		return runCostReportCommand(args)
+	case "kb-ingest":
+		return runKnowledgeIngestCommand(args)
+	case "kb-search":
+		return runKnowledgeSearchCommand(args)
+	case "med-import":
+		return runMedicationImportCommand(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+}
+
+func runAskCommand(args []string) int {
+	fs := flag.NewFlagSet("ask", flag.ContinueOnError)
+	language := fs.String("language", "", "answer language (bs, hr, sr, sr-Cyrl, en); detected from the question when empty")
+	length := fs.String("length", "", "answer length (short, normal, long)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	question := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if question == "" {
+		fmt.Fprintln(os.Stderr, "usage: seniorlabai ask [-language L] [-length L] QUESTION")
+		return 2
+	}
+	opts, err := withLanguage(chatgpt.Options{AnswerLength: *length}, *language)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ask: %v\n", err)
+		return 2
+	}
+
+	apiKey, err := loadOpenAIKey("cli")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	prompt, err := promptFromText(question)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	opts = withModeration(opts, apiKey, "cli", "cli")
+	result := chatgpt.ChatGPTAnalyseWithOptions(prompt, apiKey, opts)
+	if !json.Valid([]byte(result)) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", result)
+		return 1
+	}
+	return printRawJSON(result)
+}
+
+func runSearchCommand(args []string) int {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	count := fs.Int("count", 10, "number of results")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	query := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if query == "" {
+		fmt.Fprintln(os.Stderr, "usage: seniorlabai search [-count N] QUERY")
+		return 2
+	}
+	fmt.Println(webpagescraper.GoogleSearch(query, *count))
+	return 0
+}
+
+func runScrapeCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: seniorlabai scrape URL")
+		return 2
+	}
+	content := webpagescraper.WebpageAnalyse(args[0])
+	if content == "" {
+		fmt.Fprintf(os.Stderr, "error: could not scrape %s\n", args[0])
+		return 1
+	}
+	fmt.Println(content)
+	return 0
+}
+
+func runTokensCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: seniorlabai tokens FILE")
+		return 2
+	}
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", args[0], err)
+		return 1
+	}
+	count := webpagescraper.TokenCounter(string(data))
+	if count < 0 {
+		fmt.Fprintln(os.Stderr, "error: could not count tokens")
+		return 1
+	}
+	fmt.Println(count)
+	return 0
+}
+
+// logQuery selects log entries, mirroring the filters of the log viewer.
+type logQuery struct {
+	Level  string
+	Since  time.Time
+	IP     string
+	APIKey string
+	Msg    string
+	Text   string
+}
+
+func (q logQuery) matches(entry LogEntry) bool {
+	if q.Level != "" && !strings.EqualFold(fmt.Sprint(entry["level"]), q.Level) {
+		return false
+	}
+	if !q.Since.IsZero() {
+		timestamp, _ := entry["time"].(string)
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil || t.Before(q.Since) {
+			return false
+		}
+	}
+	if q.IP != "" && entry["ip"] != q.IP {
+		return false
+	}
+	if q.APIKey != "" && entry["api_key"] != q.APIKey {
+		return false
+	}
+	if q.Msg != "" && entry["msg"] != q.Msg {
+		return false
+	}
+	if q.Text != "" {
+		line, _ := json.Marshal(entry)
+		if !strings.Contains(strings.ToLower(string(line)), strings.ToLower(q.Text)) {
+			return false
+		}
+	}
+	return true
+}
+
+// logsCommand is a parsed logs command line.
+type logsCommand struct {
+	path  string
+	query logQuery
+	// limit is the maximum number of entries printed, 0 for all.
+	limit int
+}
+
+// parseLogsArgs parses the flags of the logs command. The remaining
+// arguments are the text every printed entry has to contain.
+func parseLogsArgs(args []string, now time.Time) (logsCommand, error) {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	file := fs.String("file", "logfile", "log to search: logfile, usage or audit")
+	level := fs.String("level", "", "only entries with this level (INFO, WARN, ERROR)")
+	since := fs.Duration("since", 0, "only entries newer than this, e.g. 24h")
+	ip := fs.String("ip", "", "only entries for this client ip")
+	key := fs.String("key", "", "only entries for this api key id")
+	msg := fs.String("msg", "", "only entries with exactly this message")
+	limit := fs.Int("n", 0, "print at most this many entries, newest first (0 = all)")
+	if err := fs.Parse(args); err != nil {
+		return logsCommand{}, err
+	}
+
+	c := logsCommand{
+		path: *file,
+		query: logQuery{
+			Level:  *level,
+			IP:     *ip,
+			APIKey: *key,
+			Msg:    *msg,
+			Text:   strings.Join(fs.Args(), " "),
+		},
+		limit: *limit,
+	}
+	switch *file {
+	case "logfile", "usage", "audit":
+		c.path = cfg.logFile(*file + ".log")
+	}
+	if *since > 0 {
+		c.query.Since = now.Add(-*since)
+	}
+	return c, nil
+}
+
+func runLogsCommand(args []string) int {
+	c, err := parseLogsArgs(args, time.Now())
+	if err != nil {
+		return 2
+	}
+	entries, err := parseLogFile(c.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", c.path, err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	printed := 0
+	for _, entry := range entries {
+		if !c.query.matches(entry) {
+			continue
+		}
+		if err := enc.Encode(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding output: %v\n", err)
+			return 1
+		}
+		if printed++; c.limit > 0 && printed >= c.limit {
+			break
+		}
+	}
+	return 0
+}
+
+// printRawJSON pretty-prints an already encoded JSON document.
+func printRawJSON(data string) int {
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(data), "", "  "); err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding output: %v\n", err)
+		return 1
+	}
+	fmt.Println(out.String())
+	return 0
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.com/chatgpt"
+	"code.com/webpagescraper"
+)
+
+// withTestConfig points the configuration at a temporary directory and
+// restores the configuration and loggers that run replaces.
+func withTestConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("ENV_FILE", filepath.Join(dir, "missing.env"))
+	t.Setenv("DATA_DIR", dir)
+	t.Setenv("LOG_DIR", dir)
+	t.Setenv("EMBEDDINGS", "hash")
+	savedCfg, savedLogger, savedRequestdata, savedAudit := cfg, logger, requestdata, auditlog
+	logFile, logHandler := chatgpt.LogFile, chatgpt.LogHandler
+	scraperLogFile, scraperLogHandler := webpagescraper.LogFile, webpagescraper.LogHandler
+	t.Cleanup(func() {
+		cfg, logger, requestdata, auditlog = savedCfg, savedLogger, savedRequestdata, savedAudit
+		chatgpt.LogFile, chatgpt.LogHandler = logFile, logHandler
+		webpagescraper.LogFile, webpagescraper.LogHandler = scraperLogFile, scraperLogHandler
+	})
+	return dir
+}
+
+// captureStdout returns what f prints on standard output.
+func captureStdout(t *testing.T, f func() int) (int, string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	code := f()
+	os.Stdout = stdout
+	w.Close()
+	return code, <-out
+}
+
+func TestParseLogsArgs(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.LogDir = "/logs"
+	now := time.Date(2024, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		args []string
+		want logsCommand
+	}{
+		{nil, logsCommand{path: "/logs/logfile.log"}},
+		{[]string{"-file", "usage", "-n", "5"}, logsCommand{path: "/logs/usage.log", limit: 5}},
+		{[]string{"-file", "/tmp/old.log", "penzija"}, logsCommand{path: "/tmp/old.log", query: logQuery{Text: "penzija"}}},
+		{
+			[]string{"-level", "ERROR", "-since", "24h", "-ip", "10.0.0.1", "-key", "k1", "-msg", "Job failed", "dom", "zdravlja"},
+			logsCommand{path: "/logs/logfile.log", query: logQuery{
+				Level: "ERROR", Since: now.Add(-24 * time.Hour), IP: "10.0.0.1", APIKey: "k1", Msg: "Job failed", Text: "dom zdravlja",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseLogsArgs(tt.args, now)
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q:\n got %+v\nwant %+v", tt.args, got, tt.want)
+		}
+	}
+
+	for _, bad := range [][]string{{"-since", "yesterday"}, {"-n", "many"}, {"-verbose"}} {
+		if _, err := parseLogsArgs(bad, now); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+func TestLogQueryMatches(t *testing.T) {
+	entry := LogEntry{
+		"time":    "2024-11-05T10:00:00.5Z",
+		"level":   "ERROR",
+		"msg":     "Job failed",
+		"ip":      "10.0.0.1",
+		"api_key": "k1",
+		"error":   "Timeout while answering",
+	}
+	since := time.Date(2024, 11, 5, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query logQuery
+		want  bool
+	}{
+		{"no filter", logQuery{}, true},
+		{"level ignores case", logQuery{Level: "error"}, true},
+		{"other level", logQuery{Level: "INFO"}, false},
+		{"newer than since", logQuery{Since: since}, true},
+		{"older than since", logQuery{Since: since.Add(2 * time.Hour)}, false},
+		{"ip", logQuery{IP: "10.0.0.1"}, true},
+		{"other ip", logQuery{IP: "10.0.0.2"}, false},
+		{"api key", logQuery{APIKey: "k2"}, false},
+		{"exact message", logQuery{Msg: "Job failed"}, true},
+		{"partial message", logQuery{Msg: "Job"}, false},
+		{"text in any field", logQuery{Text: "timeout"}, true},
+		{"missing text", logQuery{Text: "penzija"}, false},
+		{"all filters", logQuery{Level: "ERROR", Since: since, IP: "10.0.0.1", APIKey: "k1", Msg: "Job failed", Text: "timeout"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.query.matches(entry); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if (logQuery{Since: since}).matches(LogEntry{"msg": "no time"}) {
+		t.Error("an entry without a time matched a since filter")
+	}
+}
+
+func TestRunDispatch(t *testing.T) {
+	dir := withTestConfig(t)
+	logPath := filepath.Join(dir, "old.log")
+	writeTestFile(t, logPath, `{"time":"2024-11-05T10:00:00Z","level":"INFO","msg":"Request received"}`+"\n"+
+		`{"time":"2024-11-05T11:00:00Z","level":"ERROR","msg":"Job failed"}`+"\n")
+	csvPath := filepath.Join(dir, "lijekovi.csv")
+	writeTestFile(t, csvPath, "name;substance\nBrufen;ibuprofen\n")
+	datasetPath := filepath.Join(dir, "medications.json")
+
+	tests := []struct {
+		args []string
+		code int
+		out  string
+	}{
+		{[]string{"help"}, 0, "usage: seniorlabai"},
+		{[]string{"frobnicate"}, 2, ""},
+		{[]string{"ask"}, 2, ""},
+		{[]string{"ask", "-length"}, 2, ""},
+		{[]string{"search"}, 2, ""},
+		{[]string{"scrape"}, 2, ""},
+		{[]string{"tokens"}, 2, ""},
+		{[]string{"keys"}, 2, ""},
+		{[]string{"logs", "-file", logPath, "-level", "ERROR"}, 0, `"msg":"Job failed"`},
+		{[]string{"logs", "-file", logPath, "-n", "1"}, 0, `"msg":"Job failed"`},
+		{[]string{"logs", "-file", filepath.Join(dir, "missing.log")}, 1, ""},
+		{[]string{"logs", "-verbose"}, 2, ""},
+		{[]string{"med-import"}, 2, ""},
+		{[]string{"med-import", "-o", datasetPath, csvPath}, 0, `"medicines": 1`},
+	}
+	for _, tt := range tests {
+		code, out := captureStdout(t, func() int { return run(tt.args) })
+		if code != tt.code {
+			t.Errorf("%q: exit code %d, want %d", tt.args, code, tt.code)
+		}
+		if !strings.Contains(out, tt.out) {
+			t.Errorf("%q: output %q does not contain %q", tt.args, out, tt.out)
+		}
+		if tt.args[0] == "logs" && tt.code == 0 && strings.Contains(out, "Request received") {
+			t.Errorf("%q: printed a filtered entry: %s", tt.args, out)
+		}
+	}
+	if _, err := os.Stat(datasetPath); err != nil {
+		t.Errorf("med-import did not write the dataset: %v", err)
+	}
+}
+
+func TestMalformedMedicationsFile(t *testing.T) {
+	dir := withTestConfig(t)
+	medicines := chatgpt.Medicines
+	t.Cleanup(func() { chatgpt.Medicines = medicines })
+	datasetPath := filepath.Join(dir, "medications.json")
+	writeTestFile(t, datasetPath, "[{")
+	t.Setenv("MEDICATIONS_FILE", datasetPath)
+	chatgpt.Medicines = []chatgpt.Medicine{{Name: "Brufen"}}
+
+	if err := loadConfig(); err != nil {
+		t.Fatalf("a malformed dataset broke the configuration: %v", err)
+	}
+	if chatgpt.Medicines != nil {
+		t.Error("medication tool still enabled")
+	}
+
+	// med-import can still replace the dataset
+	csvPath := filepath.Join(dir, "lijekovi.csv")
+	writeTestFile(t, csvPath, "name;substance\nBrufen;ibuprofen\n")
+	if code, out := captureStdout(t, func() int { return run([]string{"med-import", csvPath}) }); code != 0 {
+		t.Fatalf("med-import exited with %d: %s", code, out)
+	}
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if len(chatgpt.Medicines) != 1 {
+		t.Errorf("loaded %d medicines after the import, want 1", len(chatgpt.Medicines))
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
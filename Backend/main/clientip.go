@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// defaultTrustedProxies covers loopback and the private ranges used by the
+// Docker network that Caddy and the Express proxy run in.
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8",
+	"::1/128",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or plain addresses.
+func ParseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// trustedProxiesFromEnv reads TRUSTED_PROXIES, falling back to defaultTrustedProxies.
+func trustedProxiesFromEnv() ([]netip.Prefix, error) {
+	spec := os.Getenv("TRUSTED_PROXIES")
+	if spec == "" {
+		spec = strings.Join(defaultTrustedProxies, ",")
+	}
+	return ParseTrustedProxies(spec)
+}
+
+// clientIPHeaders are the forwarding headers a proxy may be configured to set.
+var clientIPHeaders = []string{"X-Forwarded-For", "Forwarded", "X-Real-IP"}
+
+// clientIPHeaderFromEnv reads CLIENT_IP_HEADER, the forwarding header our
+// proxies set. Caddy and the Express proxy write X-Forwarded-For, the
+// default.
+func clientIPHeaderFromEnv() (string, error) {
+	header := os.Getenv("CLIENT_IP_HEADER")
+	if header == "" {
+		return "X-Forwarded-For", nil
+	}
+	for _, h := range clientIPHeaders {
+		if strings.EqualFold(header, h) {
+			return h, nil
+		}
+	}
+	return "", fmt.Errorf("CLIENT_IP_HEADER must be one of %s, got %q", strings.Join(clientIPHeaders, ", "), header)
+}
+
+// ClientResolver works out the real client address of a request that may
+// have passed through reverse proxies. Forwarding headers are only believed
+// when they were added by a trusted proxy, and only the one header the
+// proxies set is read: proxies pass the others on from the client untouched.
+type ClientResolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// NewClientResolver returns a resolver that reads header, one of
+// X-Forwarded-For, Forwarded or X-Real-IP. Empty means X-Forwarded-For.
+func NewClientResolver(trusted []netip.Prefix, header string) *ClientResolver {
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	return &ClientResolver{trusted: trusted, header: http.CanonicalHeaderKey(header)}
+}
+
+func (c *ClientResolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range c.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHost parses an address that may carry a port, brackets or quotes.
+func parseHost(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// forwardedFor returns the "for" parameters of an RFC 7239 Forwarded header,
+// in the order the proxies added them.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			for _, pair := range splitQuoted(element, ';') {
+				name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hops = append(hops, v)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s on sep outside of double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Resolve returns the client address of r.
+func (c *ClientResolver) Resolve(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, ok := parseHost(host)
+	if !ok || !c.isTrusted(peer) {
+		// Anyone can send forwarding headers; only proxies we run are believed
+		return host
+	}
+
+	var hops []string
+	switch c.header {
+	case "Forwarded":
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case "X-Real-Ip":
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			hops = []string{realIP}
+		}
+	default:
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	}
+
+	// Walk from the nearest hop back towards the client and stop at the
+	// first address that is not one of our proxies.
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHost(hops[i])
+		if !ok {
+			// Obfuscated or malformed entries ("unknown", "_hidden") end the chain
+			break
+		}
+		client = addr
+		if !c.isTrusted(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// Middleware stores the resolved client address in the request context.
+func (c *ClientResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, c.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the client address resolved by the client identity middleware.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	// Not behind the middleware: never trust forwarding headers
+	return NewClientResolver(nil, "").Resolve(r)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientResolverResolve(t *testing.T) {
+	trusted, err := ParseTrustedProxies("127.0.0.1,172.16.0.0/12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "direct client",
+			remote: "203.0.113.7:5000",
+			want:   "203.0.113.7",
+		},
+		{
+			name:    "untrusted peer cannot forward",
+			remote:  "203.0.113.7:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "proxy appends the client to X-Forwarded-For",
+			remote:  "172.18.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "spoofed X-Forwarded-For entry before the real client",
+			remote:  "172.18.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:   "spoofed Forwarded is ignored when the proxy writes X-Forwarded-For",
+			remote: "172.18.0.2:5000",
+			headers: map[string]string{
+				"Forwarded":       "for=1.2.3.4",
+				"X-Forwarded-For": "198.51.100.9",
+			},
+			want: "198.51.100.9",
+		},
+		{
+			name:    "spoofed X-Real-IP is ignored when the proxy writes X-Forwarded-For",
+			remote:  "172.18.0.2:5000",
+			headers: map[string]string{"X-Real-IP": "1.2.3.4"},
+			want:    "172.18.0.2",
+		},
+		{
+			name:   "Forwarded when the proxy writes it",
+			header: "Forwarded",
+			remote: "172.18.0.2:5000",
+			headers: map[string]string{
+				"Forwarded":       `for=1.2.3.4, for="[2001:db8::1]:4711"`,
+				"X-Forwarded-For": "1.2.3.4",
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:    "spoofed X-Forwarded-For is ignored when the proxy writes Forwarded",
+			header:  "Forwarded",
+			remote:  "172.18.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:    "172.18.0.2",
+		},
+		{
+			name:   "X-Real-IP when the proxy writes it",
+			header: "X-Real-IP",
+			remote: "127.0.0.1:5000",
+			headers: map[string]string{
+				"X-Real-IP":       "198.51.100.9",
+				"X-Forwarded-For": "1.2.3.4",
+			},
+			want: "198.51.100.9",
+		},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		if got := NewClientResolver(trusted, tt.header).Resolve(r); got != tt.want {
+			t.Errorf("%s: Resolve = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPHeaderFromEnv(t *testing.T) {
+	t.Setenv("CLIENT_IP_HEADER", "")
+	if got, err := clientIPHeaderFromEnv(); err != nil || got != "X-Forwarded-For" {
+		t.Errorf("default header = %q, %v", got, err)
+	}
+	t.Setenv("CLIENT_IP_HEADER", "forwarded")
+	if got, err := clientIPHeaderFromEnv(); err != nil || got != "Forwarded" {
+		t.Errorf("header = %q, %v, want Forwarded", got, err)
+	}
+	t.Setenv("CLIENT_IP_HEADER", "CF-Connecting-IP")
+	if _, err := clientIPHeaderFromEnv(); err == nil {
+		t.Error("an unsupported header was accepted")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.com/chatgpt"
+	"code.com/webpagescraper"
+	"github.com/joho/godotenv"
+)
+
+// Config is the configuration shared by every subcommand. It is read from
+// the .env file and the environment, with the environment taking precedence.
+type Config struct {
+	EnvFile   string
+	LogDir    string
+	DataDir   string
+	Port      int
+	SearchURL string
+	// Provider selects the LLM that answers questions (LLM_* variables).
+	Provider chatgpt.ProviderConfig
+	// Fallbacks are tried in order when the provider fails (LLM_FALLBACKS).
+	Fallbacks []chatgpt.ProviderConfig
+	// AttemptTimeout bounds each attempt of the fallback chain (LLM_ATTEMPT_TIMEOUT).
+	AttemptTimeout time.Duration
+	// AnswerTimeout bounds answering one question, retries and fallbacks
+	// included (ANSWER_TIMEOUT).
+	AnswerTimeout time.Duration
+	// PriceTable is a JSON file with model prices that extend the built-in ones.
+	PriceTable string
+	// Tools are the assistant's tools enabled for this deployment (TOOLS); nil enables all.
+	Tools []string
+	// HolidaysFile replaces the holiday rules of the calendar tool.
+	HolidaysFile string
+	// RatesFile gives the exchange rates in KM of the calculate tool.
+	RatesFile string
+	// Embedder embeds knowledge base passages and queries (EMBEDDINGS).
+	Embedder chatgpt.ProviderConfig
+	// KnowledgeIndex is the vector index written by kb-ingest (KNOWLEDGE_INDEX).
+	KnowledgeIndex string
+	// MedicationsFile is the medicines dataset written by med-import (MEDICATIONS_FILE).
+	MedicationsFile string
+	// GTFSFeeds are the public transport timetables of next_departures (GTFS_FEEDS).
+	GTFSFeeds []string
+}
+
+var cfg = Config{
+	EnvFile:   "./.env",
+	LogDir:    "/app/logs",
+	DataDir:   "/app/data",
+	Port:      8468,
+	SearchURL: webpagescraper.SearchURL,
+
+	AttemptTimeout: chatgpt.FallbackTimeout,
+	AnswerTimeout:  2 * time.Minute,
+}
+
+// loadConfig loads the .env file (if there is one) and the environment into cfg
+// and points the chatgpt and webpagescraper packages at the configured paths.
+func loadConfig() error {
+	if path := os.Getenv("ENV_FILE"); path != "" {
+		cfg.EnvFile = path
+	}
+	if err := godotenv.Load(cfg.EnvFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error loading %s: %v", cfg.EnvFile, err)
+	}
+
+	if dir := os.Getenv("LOG_DIR"); dir != "" {
+		cfg.LogDir = dir
+	}
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		cfg.DataDir = dir
+	}
+	if url := os.Getenv("SEARXNG_URL"); url != "" {
+		cfg.SearchURL = url
+	}
+	if v := os.Getenv("PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid PORT %q", v)
+		}
+		cfg.Port = port
+	}
+
+	cfg.Provider = chatgpt.ProviderConfig{
+		Name:         os.Getenv("LLM_PROVIDER"),
+		BaseURL:      os.Getenv("LLM_BASE_URL"),
+		APIKey:       os.Getenv("LLM_API_KEY"),
+		Model:        os.Getenv("LLM_MODEL"),
+		Capabilities: os.Getenv("LLM_CAPABILITIES"),
+	}
+	if cfg.Provider.Name == chatgpt.ProviderAnthropic && cfg.Provider.APIKey == "" {
+		cfg.Provider.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if _, _, err := chatgpt.NewProvider(cfg.Provider, os.Getenv("OPENAI_API_KEY")); err != nil {
+		return fmt.Errorf("invalid LLM provider configuration: %v", err)
+	}
+	if os.Getenv("MODERATION_PROVIDER") == "openai" && os.Getenv("OPENAI_API_KEY") == "" {
+		return errors.New("MODERATION_PROVIDER=openai needs OPENAI_API_KEY")
+	}
+
+	fallbacks, err := chatgpt.ParseFallbacks(os.Getenv("LLM_FALLBACKS"))
+	if err != nil {
+		return fmt.Errorf("invalid LLM_FALLBACKS: %v", err)
+	}
+	for i, fb := range fallbacks {
+		switch {
+		case fb.Name == cfg.Provider.Name && fb.BaseURL == cfg.Provider.BaseURL:
+			fallbacks[i].APIKey = cfg.Provider.APIKey
+		case fb.Name == chatgpt.ProviderAnthropic:
+			fallbacks[i].APIKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		if _, _, err := chatgpt.NewProvider(fallbacks[i], os.Getenv("OPENAI_API_KEY")); err != nil {
+			return fmt.Errorf("invalid LLM_FALLBACKS entry %d: %v", i+1, err)
+		}
+	}
+	cfg.Fallbacks = fallbacks
+	if v := os.Getenv("LLM_ATTEMPT_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid LLM_ATTEMPT_TIMEOUT %q", v)
+		}
+		cfg.AttemptTimeout = timeout
+	}
+	if v := os.Getenv("ANSWER_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid ANSWER_TIMEOUT %q", v)
+		}
+		cfg.AnswerTimeout = timeout
+	}
+
+	if cfg.PriceTable = os.Getenv("PRICE_TABLE"); cfg.PriceTable != "" {
+		if err := chatgpt.LoadPrices(cfg.PriceTable); err != nil {
+			return fmt.Errorf("error loading PRICE_TABLE: %v", err)
+		}
+	}
+
+	if cfg.HolidaysFile = os.Getenv("HOLIDAYS_FILE"); cfg.HolidaysFile != "" {
+		if err := chatgpt.LoadHolidays(cfg.HolidaysFile); err != nil {
+			return fmt.Errorf("error loading HOLIDAYS_FILE: %v", err)
+		}
+	}
+
+	if cfg.RatesFile = os.Getenv("RATES_FILE"); cfg.RatesFile != "" {
+		if err := chatgpt.LoadRates(cfg.RatesFile); err != nil {
+			return fmt.Errorf("error loading RATES_FILE: %v", err)
+		}
+	}
+
+	if cfg.Embedder, err = chatgpt.ParseEmbedder(os.Getenv("EMBEDDINGS")); err != nil {
+		return fmt.Errorf("invalid EMBEDDINGS: %v", err)
+	}
+	primary := cfg.Provider.Name
+	if primary == "" {
+		primary = chatgpt.ProviderOpenAI
+	}
+	if cfg.Embedder.Name == primary && cfg.Embedder.BaseURL == cfg.Provider.BaseURL {
+		cfg.Embedder.APIKey = cfg.Provider.APIKey
+	}
+	embedder, err := chatgpt.NewEmbedder(cfg.Embedder, os.Getenv("OPENAI_API_KEY"))
+	if err != nil {
+		return fmt.Errorf("invalid EMBEDDINGS: %v", err)
+	}
+	cfg.KnowledgeIndex = cfg.dataPath("knowledge.idx")
+	if path := os.Getenv("KNOWLEDGE_INDEX"); path != "" {
+		cfg.KnowledgeIndex = path
+	}
+	// A broken index only disables the knowledge base, so that kb-ingest
+	// can still rebuild it
+	knowledge, err := chatgpt.LoadKnowledgeIndex(cfg.KnowledgeIndex)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Default().Error("Knowledge base disabled", "error", err, "path", cfg.KnowledgeIndex)
+		knowledge = nil
+	}
+
+	cfg.MedicationsFile = cfg.dataPath("medications.json")
+	if path := os.Getenv("MEDICATIONS_FILE"); path != "" {
+		cfg.MedicationsFile = path
+	}
+	// A broken dataset only disables medication_info, so that med-import
+	// can still replace it
+	medicines, err := chatgpt.LoadMedicines(cfg.MedicationsFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Default().Error("Medication tool disabled", "error", err, "path", cfg.MedicationsFile)
+		medicines = nil
+	}
+
+	var transit *chatgpt.TransitIndex
+	for _, path := range strings.Split(os.Getenv("GTFS_FEEDS"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			cfg.GTFSFeeds = append(cfg.GTFSFeeds, path)
+		}
+	}
+	if len(cfg.GTFSFeeds) > 0 {
+		if transit, err = chatgpt.LoadGTFS(cfg.GTFSFeeds...); err != nil {
+			return fmt.Errorf("invalid GTFS_FEEDS: %v", err)
+		}
+	}
+
+	tools, err := chatgpt.ParseToolList(os.Getenv("TOOLS"))
+	if err != nil {
+		return fmt.Errorf("invalid TOOLS: %v", err)
+	}
+	cfg.Tools = tools
+
+	chatgpt.Provider = cfg.Provider
+	chatgpt.EnabledTools = cfg.Tools
+	chatgpt.Knowledge = knowledge
+	chatgpt.KnowledgeEmbedder = embedder
+	chatgpt.Medicines = medicines
+	chatgpt.Transit = transit
+	chatgpt.Fallbacks = cfg.Fallbacks
+	chatgpt.FallbackTimeout = cfg.AttemptTimeout
+	chatgpt.LogFile = cfg.logFile("logfile.log")
+	webpagescraper.LogFile = cfg.logFile("logfile.log")
+	webpagescraper.SearchURL = cfg.SearchURL
+	return nil
+}
+
+// logFile returns the path of a log file in the log directory.
+func (c Config) logFile(name string) string {
+	return filepath.Join(c.LogDir, name)
+}
+
+// dataPath returns the path of a file or directory in the data directory.
+func (c Config) dataPath(name string) string {
+	return filepath.Join(c.DataDir, name)
+}
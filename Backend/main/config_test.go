@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.com/chatgpt"
+	"code.com/webpagescraper"
+)
+
+func TestLoadConfigWithBrokenKnowledgeIndex(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(dir, "knowledge.idx")
+	if err := os.WriteFile(index, []byte("not a gob stream"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENV_FILE", filepath.Join(dir, "missing.env"))
+	t.Setenv("DATA_DIR", dir)
+	t.Setenv("LOG_DIR", dir)
+	t.Setenv("KNOWLEDGE_INDEX", index)
+	t.Setenv("EMBEDDINGS", "hash")
+	saved, logFile, scraperLogFile := cfg, chatgpt.LogFile, webpagescraper.LogFile
+	t.Cleanup(func() { cfg, chatgpt.LogFile, webpagescraper.LogFile = saved, logFile, scraperLogFile })
+
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if chatgpt.Knowledge != nil {
+		t.Error("a broken index was loaded")
+	}
+}
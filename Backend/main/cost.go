@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"code.com/chatgpt"
+)
+
+// answerLanguage returns the language recorded in a chatResponse. Error
+// messages record none, so for them it is the language the question was
+// to be answered in.
+func answerLanguage(resultingText, question string, opts chatgpt.Options) string {
+	var answer struct {
+		Language string `json:"language"`
+	}
+	if json.Unmarshal([]byte(resultingText), &answer) == nil && answer.Language != "" {
+		return answer.Language
+	}
+	return chatgpt.ResolveLanguage(opts.Language, question)
+}
+
+// CostRow is the spend of one day, API key or client.
+type CostRow struct {
+	Group     string  `json:"group"`
+	Questions int     `json:"questions"`
+	Tokens    int64   `json:"tokens"`
+	CostUSD   float64 `json:"cost_usd"`
+}
+
+// CostReport aggregates the cost of answered questions.
+type CostReport struct {
+	Total    CostRow   `json:"total"`
+	ByDay    []CostRow `json:"by_day"`
+	ByKey    []CostRow `json:"by_key"`
+	ByClient []CostRow `json:"by_client"`
+	// ByLanguage groups by answer language; entries from before languages
+	// were recorded count as "unknown".
+	ByLanguage []CostRow `json:"by_language"`
+}
+
+// collectCosts aggregates the "Resulting text" entries of the usage log
+// newer than since per day, API key, client IP and answer language.
+func collectCosts(entries []LogEntry, since time.Time) CostReport {
+	days := map[string]*CostRow{}
+	keys := map[string]*CostRow{}
+	clients := map[string]*CostRow{}
+	languages := map[string]*CostRow{}
+	report := CostReport{Total: CostRow{Group: "total"}}
+
+	add := func(rows map[string]*CostRow, group string, tokens int64, cost float64) {
+		row, ok := rows[group]
+		if !ok {
+			row = &CostRow{Group: group}
+			rows[group] = row
+		}
+		row.Questions++
+		row.Tokens += tokens
+		row.CostUSD += cost
+	}
+
+	for _, entry := range entries {
+		if entry["msg"] != "Resulting text" {
+			continue
+		}
+		timestamp, _ := entry["time"].(string)
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil || t.Before(since) {
+			continue
+		}
+		// Older entries have no usage fields; count the question at zero cost
+		tokens, _ := entry["total_tokens"].(float64)
+		cost, _ := entry["cost_usd"].(float64)
+		key, _ := entry["api_key"].(string)
+		if key == "" {
+			key = "public"
+		}
+		ip, _ := entry["ip"].(string)
+		language, _ := entry["language"].(string)
+		if language == "" {
+			language = "unknown"
+		}
+
+		add(days, timestamp[:10], int64(tokens), cost)
+		add(keys, key, int64(tokens), cost)
+		add(clients, ip, int64(tokens), cost)
+		add(languages, language, int64(tokens), cost)
+		report.Total.Questions++
+		report.Total.Tokens += int64(tokens)
+		report.Total.CostUSD += cost
+	}
+
+	report.ByDay = costRows(days)
+	sort.Slice(report.ByDay, func(i, j int) bool { return report.ByDay[i].Group > report.ByDay[j].Group })
+	report.ByKey = costRows(keys)
+	report.ByClient = costRows(clients)
+	report.ByLanguage = costRows(languages)
+	return report
+}
+
+// costRows returns the rows of a grouping, most expensive first.
+func costRows(rows map[string]*CostRow) []CostRow {
+	out := []CostRow{}
+	for _, row := range rows {
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CostUSD != out[j].CostUSD {
+			return out[i].CostUSD > out[j].CostUSD
+		}
+		return out[i].Group < out[j].Group
+	})
+	return out
+}
+
+func runCostReportCommand(args []string) int {
+	fs := flag.NewFlagSet("cost-report", flag.ContinueOnError)
+	since := fs.Duration("since", 30*24*time.Hour, "only questions newer than this, e.g. 24h")
+	by := fs.String("by", "", "only print one grouping: day, key, client or language")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	path := cfg.logFile("usage.log")
+	entries, err := parseLogFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+		return 1
+	}
+	report := collectCosts(entries, time.Now().Add(-*since))
+
+	switch *by {
+	case "":
+		return printJSON(report)
+	case "day":
+		return printJSON(report.ByDay)
+	case "key":
+		return printJSON(report.ByKey)
+	case "client":
+		return printJSON(report.ByClient)
+	case "language":
+		return printJSON(report.ByLanguage)
+	default:
+		fmt.Fprintf(os.Stderr, "cost-report: unknown grouping %q\n", *by)
+		return 2
+	}
+}
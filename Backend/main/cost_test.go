@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.com/chatgpt"
+)
+
+func TestCollectCosts(t *testing.T) {
+	lines := []string{
+		`{"time":"2026-03-01T09:00:00Z","msg":"Resulting text","ip":"10.0.0.1","api_key":"k1","total_tokens":1000,"cost_usd":0.002}`,
+		`{"time":"2026-03-01T10:00:00Z","msg":"Resulting text","ip":"10.0.0.2","api_key":"k1","total_tokens":500,"cost_usd":0.001}`,
+		`{"time":"2026-03-02T10:00:00Z","msg":"Resulting text","ip":"10.0.0.1","total_tokens":3000,"cost_usd":0.006}`,
+		// Logged before usage was recorded: counted at zero cost
+		`{"time":"2026-03-02T11:00:00Z","msg":"Resulting text","ip":"10.0.0.3","api_key":"public"}`,
+		// Not an answer, or too old
+		`{"time":"2026-03-02T10:00:00Z","msg":"Received text","ip":"10.0.0.1","total_tokens":99,"cost_usd":9}`,
+		`{"time":"2026-02-20T10:00:00Z","msg":"Resulting text","ip":"10.0.0.1","total_tokens":99,"cost_usd":9}`,
+		`not json`,
+	}
+	path := filepath.Join(t.TempDir(), "usage.log")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := parseLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := collectCosts(entries, time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC))
+
+	approx := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	if report.Total.Questions != 4 || report.Total.Tokens != 4500 || !approx(report.Total.CostUSD, 0.009) {
+		t.Errorf("total = %+v", report.Total)
+	}
+
+	tests := []struct {
+		name string
+		rows []CostRow
+		want []CostRow
+	}{
+		{"by day", report.ByDay, []CostRow{
+			{Group: "2026-03-02", Questions: 2, Tokens: 3000, CostUSD: 0.006},
+			{Group: "2026-03-01", Questions: 2, Tokens: 1500, CostUSD: 0.003},
+		}},
+		{"by key", report.ByKey, []CostRow{
+			{Group: "public", Questions: 2, Tokens: 3000, CostUSD: 0.006},
+			{Group: "k1", Questions: 2, Tokens: 1500, CostUSD: 0.003},
+		}},
+		{"by client", report.ByClient, []CostRow{
+			{Group: "10.0.0.1", Questions: 2, Tokens: 4000, CostUSD: 0.008},
+			{Group: "10.0.0.2", Questions: 1, Tokens: 500, CostUSD: 0.001},
+			{Group: "10.0.0.3", Questions: 1, Tokens: 0, CostUSD: 0},
+		}},
+	}
+	for _, tt := range tests {
+		if len(tt.rows) != len(tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, tt.rows, tt.want)
+			continue
+		}
+		for i, row := range tt.rows {
+			want := tt.want[i]
+			if row.Group != want.Group || row.Questions != want.Questions || row.Tokens != want.Tokens || !approx(row.CostUSD, want.CostUSD) {
+				t.Errorf("%s row %d: %+v, want %+v", tt.name, i, row, want)
+			}
+		}
+	}
+}
+
+func TestAnswerLanguage(t *testing.T) {
+	tests := []struct {
+		name, text, question, language string
+		want                           string
+	}{
+		{"answer", `{"content":{},"language":"hr"}`, "Kada je sljedeći praznik?", "", "hr"},
+		{"failure with a language", "An error occurred: timeout", "Kada je sljedeći praznik?", "en", "en"},
+		{"failure without a language", "An error occurred: timeout", "Gde mogu da kupim lekove?", "", "sr"},
+		{"answer without a language", `{"content":{}}`, "Kada je sljedeći praznik?", "", "bs"},
+	}
+	for _, tt := range tests {
+		if got := answerLanguage(tt.text, tt.question, chatgpt.Options{Language: tt.language}); got != tt.want {
+			t.Errorf("%s: language %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
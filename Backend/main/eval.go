@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.com/chatgpt"
+	"gopkg.in/yaml.v3"
+)
+
+const evalUsage = `usage: seniorlabai eval [flags]
+
+Runs questions through ChatGPTAnalyse under two configurations and writes a
+side-by-side report. A configuration is a comma separated list of
+provider=..., base_url=..., model=..., language=..., length=... and
+prompt=FILE settings. prompt replaces the answer rules of the system prompt
+with the contents of FILE, to compare two prompt variants.
+
+flags:
+`
+
+// EvalCase is one question of an evaluation dataset.
+type EvalCase struct {
+	Question     string   `yaml:"question" json:"question"`
+	Language     string   `yaml:"language,omitempty" json:"language,omitempty"`
+	AnswerLength string   `yaml:"length,omitempty" json:"length,omitempty"`
+	Emergency    bool     `yaml:"emergency,omitempty" json:"emergency,omitempty"`
+	MustContain  []string `yaml:"must_contain,omitempty" json:"must_contain,omitempty"`
+}
+
+// EvalDataset is the format of a curated YAML dataset:
+//
+//	cases:
+//	  - question: "Koji je broj hitne pomoći?"
+//	    emergency: true
+//	  - question: "Kako da platim račun za struju online?"
+//	    length: short
+//	    must_contain: ["e-banking"]
+type EvalDataset struct {
+	Cases []EvalCase `yaml:"cases"`
+}
+
+// EvalConfig is one side of the comparison.
+type EvalConfig struct {
+	Name         string `json:"name"`
+	Provider     string `json:"provider,omitempty"`
+	BaseURL      string `json:"base_url,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Language     string `json:"language,omitempty"`
+	AnswerLength string `json:"length,omitempty"`
+	// PromptFile holds a variant of the answer rules of the system prompt.
+	PromptFile string `json:"prompt,omitempty"`
+	Prompt     string `json:"-"`
+}
+
+// EvalRun is the answer of one configuration to one case.
+type EvalRun struct {
+	Answer     json.RawMessage  `json:"answer,omitempty"`
+	Short      string           `json:"-"`
+	Long       string           `json:"-"`
+	Error      string           `json:"error,omitempty"`
+	DurationMS int64            `json:"duration_ms"`
+	Checks     []CheckResult    `json:"checks"`
+	Passed     int              `json:"passed"`
+	Verdict    *chatgpt.Verdict `json:"verdict,omitempty"`
+}
+
+// EvalResult compares both configurations on one case.
+type EvalResult struct {
+	Case EvalCase  `json:"case"`
+	Runs []EvalRun `json:"runs"`
+}
+
+// EvalSummary aggregates the runs of one configuration.
+type EvalSummary struct {
+	Config     EvalConfig     `json:"config"`
+	Cases      int            `json:"cases"`
+	Errors     int            `json:"errors"`
+	Checks     int            `json:"checks"`
+	Passed     int            `json:"passed"`
+	PassRate   float64        `json:"pass_rate"`
+	Failures   map[string]int `json:"failures"`
+	JudgeScore float64        `json:"judge_score,omitempty"`
+	DurationMS int64          `json:"avg_duration_ms"`
+}
+
+// EvalReport is written as report.json and rendered as report.html.
+type EvalReport struct {
+	CreatedAt time.Time     `json:"created_at"`
+	Source    string        `json:"source"`
+	Summaries []EvalSummary `json:"summaries"`
+	Results   []EvalResult  `json:"results"`
+}
+
+// parseEvalConfig parses "model=gpt-4o,length=short" into a configuration.
+func parseEvalConfig(name, spec string) (EvalConfig, error) {
+	c := EvalConfig{Name: name}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return c, fmt.Errorf("invalid setting %q in %q", field, spec)
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			c.Name = value
+		case "provider":
+			c.Provider = value
+		case "base_url":
+			c.BaseURL = value
+		case "model":
+			c.Model = value
+		case "language":
+			c.Language = value
+		case "length":
+			c.AnswerLength = value
+		case "prompt":
+			data, err := os.ReadFile(value)
+			if err != nil {
+				return c, fmt.Errorf("error reading prompt: %v", err)
+			}
+			if strings.TrimSpace(string(data)) == "" {
+				return c, fmt.Errorf("prompt %s is empty", value)
+			}
+			c.PromptFile, c.Prompt = value, string(data)
+		default:
+			return c, fmt.Errorf("unknown setting %q in %q", key, spec)
+		}
+	}
+	return c, nil
+}
+
+// loadEvalDataset reads a curated YAML dataset.
+func loadEvalDataset(path string) ([]EvalCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ds EvalDataset
+	if err := yaml.Unmarshal(data, &ds); err != nil {
+		return nil, fmt.Errorf("invalid dataset %s: %v", path, err)
+	}
+	return ds.Cases, nil
+}
+
+// casesFromUsageLog collects distinct questions from the usage log, newest first.
+func casesFromUsageLog(path string, limit int) ([]EvalCase, error) {
+	entries, err := parseLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var cases []EvalCase
+	for _, entry := range entries {
+		if entry["msg"] != "Received text" {
+			continue
+		}
+		text, _ := entry["text"].(string)
+		text = strings.TrimSpace(text)
+		if text == "" || seen[strings.ToLower(text)] {
+			continue
+		}
+		seen[strings.ToLower(text)] = true
+		cases = append(cases, EvalCase{Question: text})
+		if limit > 0 && len(cases) >= limit {
+			break
+		}
+	}
+	return cases, nil
+}
+
+// evaluate answers a case under one configuration and scores the answer.
+// With checkLinks the links of the answer are requested as well.
+func evaluate(c EvalCase, conf EvalConfig, apiKey, judgeModel string, checkLinks bool) EvalRun {
+	opts := chatgpt.Options{Model: conf.Model, Language: conf.Language, AnswerLength: conf.AnswerLength, SystemPrompt: conf.Prompt}
+	if c.Language != "" {
+		opts.Language = c.Language
+	}
+	if c.AnswerLength != "" {
+		opts.AnswerLength = c.AnswerLength
+	}
+
+	var run EvalRun
+	prompt, err := promptFromText(c.Question)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	pipeline := chatgpt.NewPipeline(apiKey)
+	if conf.Provider != "" {
+		// Compare against another provider than the configured one
+		provider := chatgpt.ProviderConfig{Name: conf.Provider, BaseURL: conf.BaseURL, Model: conf.Model}
+		if provider.Name == cfg.Provider.Name {
+			provider.APIKey = cfg.Provider.APIKey
+		} else if provider.Name == chatgpt.ProviderAnthropic {
+			provider.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		if pipeline.Client, pipeline.Model, err = chatgpt.NewProvider(provider, apiKey); err != nil {
+			run.Error = err.Error()
+			return run
+		}
+	}
+	startTime := time.Now()
+	result := pipeline.Analyse(context.Background(), prompt, opts)
+	run.DurationMS = time.Since(startTime).Milliseconds()
+
+	var answer evalAnswer
+	if err := json.Unmarshal([]byte(result), &answer); err != nil {
+		// ChatGPTAnalyse reports failures as plain text
+		run.Error = result
+		return run
+	}
+	run.Answer = json.RawMessage(result)
+	run.Short = answer.Content.Shortresponse
+	run.Long = answer.Content.Longresponse
+	run.Checks = scoreAnswer(rubricCase{
+		Question:     c.Question,
+		AnswerLength: opts.AnswerLength,
+		Emergency:    c.Emergency,
+		MustContain:  c.MustContain,
+	}, answer)
+	if checkLinks {
+		run.Checks = append(run.Checks, reachableLinksCheck(context.Background(), linkClient, run.Long))
+	}
+	for _, check := range run.Checks {
+		if check.Passed {
+			run.Passed++
+		}
+	}
+
+	if judgeModel != "" {
+		verdict, err := chatgpt.Judge(context.Background(), apiKey, judgeModel, c.Question, result)
+		if err != nil {
+			logger.Warn("Judge failed", "error", err, "question", c.Question)
+		} else {
+			run.Verdict = &verdict
+		}
+	}
+	return run
+}
+
+// summarize aggregates the runs of configuration i.
+func summarize(conf EvalConfig, results []EvalResult, i int) EvalSummary {
+	s := EvalSummary{Config: conf, Cases: len(results), Failures: map[string]int{}}
+	var totalDuration int64
+	var judged, judgeTotal int
+	for _, r := range results {
+		run := r.Runs[i]
+		totalDuration += run.DurationMS
+		if run.Error != "" {
+			s.Errors++
+			continue
+		}
+		s.Checks += len(run.Checks)
+		s.Passed += run.Passed
+		for _, check := range run.Checks {
+			if !check.Passed {
+				s.Failures[check.Name]++
+			}
+		}
+		if run.Verdict != nil {
+			judged++
+			judgeTotal += run.Verdict.Score
+		}
+	}
+	if s.Checks > 0 {
+		s.PassRate = float64(s.Passed) / float64(s.Checks)
+	}
+	if judged > 0 {
+		s.JudgeScore = float64(judgeTotal) / float64(judged)
+	}
+	if len(results) > 0 {
+		s.DurationMS = totalDuration / int64(len(results))
+	}
+	return s
+}
+
+func runEvalCommand(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, evalUsage)
+		fs.PrintDefaults()
+	}
+	dataset := fs.String("dataset", "", "YAML dataset of questions (default: questions from the usage log)")
+	usagePath := fs.String("usage", cfg.logFile("usage.log"), "usage log to replay when no dataset is given")
+	limit := fs.Int("n", 20, "number of questions to replay from the usage log (0 = all)")
+	specA := fs.String("a", "model="+chatgpt.DefaultModel, "first configuration")
+	specB := fs.String("b", "", "second configuration (required)")
+	judge := fs.String("judge", "", "model used as LLM judge (empty = no judge)")
+	parallel := fs.Int("parallel", 2, "number of questions answered at once")
+	checkLinks := fs.Bool("links", true, "request every link of the answers; false checks only their syntax")
+	out := fs.String("out", "eval-report", "directory for report.json and report.html")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *specB == "" {
+		fs.Usage()
+		return 2
+	}
+
+	var configs []EvalConfig
+	for i, spec := range []string{*specA, *specB} {
+		conf, err := parseEvalConfig(string(rune('A'+i)), spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+			return 2
+		}
+		configs = append(configs, conf)
+	}
+	return runEval(configs, *dataset, *usagePath, *limit, *judge, *checkLinks, *parallel, *out)
+}
+
+func runEval(configs []EvalConfig, dataset, usagePath string, limit int, judgeModel string, checkLinks bool, parallel int, out string) int {
+	var cases []EvalCase
+	var err error
+	source := dataset
+	if dataset != "" {
+		cases, err = loadEvalDataset(dataset)
+	} else {
+		source = usagePath
+		cases, err = casesFromUsageLog(usagePath, limit)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading questions: %v\n", err)
+		return 1
+	}
+	if len(cases) == 0 {
+		fmt.Fprintf(os.Stderr, "no questions found in %s\n", source)
+		return 1
+	}
+
+	apiKey, err := loadOpenAIKey("eval")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]EvalResult, len(cases))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, c := range cases {
+		results[i] = EvalResult{Case: c, Runs: make([]EvalRun, len(configs))}
+		for j, conf := range configs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				results[i].Runs[j] = evaluate(c, conf, apiKey, judgeModel, checkLinks)
+			}()
+		}
+	}
+	wg.Wait()
+
+	report := EvalReport{CreatedAt: time.Now(), Source: source, Results: results}
+	for i, conf := range configs {
+		report.Summaries = append(report.Summaries, summarize(conf, results, i))
+	}
+	if err := writeEvalReport(report, out); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		return 1
+	}
+
+	for _, s := range report.Summaries {
+		fmt.Printf("%s: %d/%d checks passed (%.0f%%), %d errors", s.Config.Name, s.Passed, s.Checks, s.PassRate*100, s.Errors)
+		if s.JudgeScore > 0 {
+			fmt.Printf(", judge %.2f", s.JudgeScore)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("report written to %s\n", out)
+	return 0
+}
+
+var evalTemplateFuncs = template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+}
+
+func writeEvalReport(report EvalReport, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "report.json"), data, 0644); err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("eval.html").Funcs(evalTemplateFuncs).ParseFiles("./templates/eval.html")
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, "report.html"))
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEvalConfig(t *testing.T) {
+	dir := t.TempDir()
+	variant := filepath.Join(dir, "kratko.txt")
+	if err := os.WriteFile(variant, []byte("Odgovaraj u najviše tri rečenice."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "prazno.txt")
+	if err := os.WriteFile(empty, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec string
+		want EvalConfig
+	}{
+		{"", EvalConfig{Name: "A"}},
+		{"model=gpt-4o, length=short", EvalConfig{Name: "A", Model: "gpt-4o", AnswerLength: "short"}},
+		{"name=kratko,prompt=" + variant, EvalConfig{Name: "kratko", PromptFile: variant, Prompt: "Odgovaraj u najviše tri rečenice."}},
+		{"provider=anthropic,base_url=http://localhost:8080,language=en", EvalConfig{Name: "A", Provider: "anthropic", BaseURL: "http://localhost:8080", Language: "en"}},
+	}
+	for _, tt := range tests {
+		got, err := parseEvalConfig("A", tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"model", "temperature=0", "prompt=" + filepath.Join(dir, "missing.txt"), "prompt=" + empty} {
+		if _, err := parseEvalConfig("A", bad); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
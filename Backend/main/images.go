@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"code.com/chatgpt"
+)
+
+// maxRequestBytes bounds the body of requests without photos.
+const maxRequestBytes = 1 << 20
+
+// maxImageRequestBytes bounds a question including its attached photos.
+const maxImageRequestBytes = chatgpt.MaxImages*chatgpt.MaxImageBytes*4/3 + maxRequestBytes
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// readMultipartInput reads a question sent as a form with a "text" field and
+// up to chatgpt.MaxImages "image" files.
+func readMultipartInput(r *http.Request) (Input, [][]byte, error) {
+	if err := r.ParseMultipartForm(maxImageRequestBytes); err != nil {
+		return Input{}, nil, err
+	}
+	input := Input{Text: r.FormValue("text"), Language: r.FormValue("language")}
+
+	files := r.MultipartForm.File["image"]
+	if len(files) > chatgpt.MaxImages {
+		return Input{}, nil, chatgpt.ErrTooManyImages
+	}
+	var images [][]byte
+	for _, header := range files {
+		if header.Size > chatgpt.MaxImageBytes {
+			return Input{}, nil, chatgpt.ErrImageTooLarge
+		}
+		file, err := header.Open()
+		if err != nil {
+			return Input{}, nil, err
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return Input{}, nil, err
+		}
+		images = append(images, data)
+	}
+	return input, images, nil
+}
+
+// decodeImages decodes base64 photos from a JSON request. Data URLs such as
+// "data:image/jpeg;base64,..." are accepted as well.
+func decodeImages(encoded []string) ([][]byte, error) {
+	if len(encoded) > chatgpt.MaxImages {
+		return nil, chatgpt.ErrTooManyImages
+	}
+	var images [][]byte
+	for i, e := range encoded {
+		if strings.HasPrefix(e, "data:") {
+			comma := strings.IndexByte(e, ',')
+			if comma < 0 {
+				return nil, fmt.Errorf("image %d: malformed data URL", i+1)
+			}
+			e = e[comma+1:]
+		}
+		data, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return nil, fmt.Errorf("image %d: %v", i+1, err)
+		}
+		images = append(images, data)
+	}
+	return images, nil
+}
+
+// prepareImages strips metadata from and downsizes every attached photo.
+func prepareImages(raw [][]byte) ([][]byte, error) {
+	var images [][]byte
+	for _, data := range raw {
+		img, err := chatgpt.PrepareImage(data)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"code.com/chatgpt"
+)
+
+type upload struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// photoRequest builds a multipart question with the given image uploads.
+func photoRequest(t *testing.T, text string, uploads ...upload) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("text", text)
+	for _, u := range uploads {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="image"; filename="`+u.name+`"`)
+		header.Set("Content-Type", u.contentType)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(u.data)
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func pngPhoto(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 32, 24))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPhotoUploadRejected(t *testing.T) {
+	withTestEnv(t)
+
+	tests := []struct {
+		name   string
+		upload upload
+		want   int
+	}{
+		{"oversized", upload{"racun.png", "image/png", make([]byte, chatgpt.MaxImageBytes+1)}, http.StatusRequestEntityTooLarge},
+		// The declared type is ignored, the content decides
+		{"not an image", upload{"racun.png", "image/png", []byte("%PDF-1.7 račun za struju")}, http.StatusBadRequest},
+		{"corrupt png", upload{"racun.png", "image/png", pngPhoto(t)[:40]}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ChatGPTHandler(w, photoRequest(t, "Šta piše na računu?", tt.upload))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
+
+func TestPhotoUploadPrepared(t *testing.T) {
+	// A PNG sent with a JPEG name and type is still recognised by its content
+	r := photoRequest(t, "Šta piše na računu?", upload{"racun.jpg", "image/jpeg", pngPhoto(t)})
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, maxImageRequestBytes)
+
+	input, raw, err := readMultipartInput(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.Text != "Šta piše na računu?" || len(raw) != 1 {
+		t.Fatalf("input = %+v with %d images", input, len(raw))
+	}
+	images, err := prepareImages(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(images[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || cfg.Width != 32 || cfg.Height != 24 {
+		t.Errorf("prepared image is a %dx%d %s, want a 32x24 jpeg", cfg.Width, cfg.Height, format)
+	}
+}
+
+func TestPhotoUploadLimitsCount(t *testing.T) {
+	photo := upload{"racun.png", "image/png", pngPhoto(t)}
+	uploads := make([]upload, chatgpt.MaxImages+1)
+	for i := range uploads {
+		uploads[i] = photo
+	}
+	if _, _, err := readMultipartInput(photoRequest(t, "Pitanje", uploads...)); err != chatgpt.ErrTooManyImages {
+		t.Errorf("err = %v, want %v", err, chatgpt.ErrTooManyImages)
+	}
+}
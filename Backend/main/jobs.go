@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.com/chatgpt"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrQueueFull    = errors.New("job queue is full")
+	ErrJobNotQueued = errors.New("job is no longer queued")
+)
+
+// Job is a question answered asynchronously by the worker pool.
+type Job struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Text       string          `json:"text"`
+	Options    chatgpt.Options `json:"options"`
+	APIKey     string          `json:"api_key"`
+	ClientIP   string          `json:"ip"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// JobView is what clients see of a job. The question, options, client IP
+// and API key stay on the server.
+type JobView struct {
+	ID     string          `json:"id"`
+	Status string          `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// View returns the client view of the job. Errors are reported with the
+// same generic message as the synchronous handler.
+func (j Job) View() JobView {
+	view := JobView{ID: j.ID, Status: j.Status, Result: j.Result}
+	if j.Error != "" {
+		view.Error = answerFailedMessage
+	}
+	return view
+}
+
+// JobRunner produces the chatResponse JSON for a job.
+type JobRunner func(job Job) (json.RawMessage, error)
+
+// JobQueue runs jobs on a fixed pool of workers and persists every job as a
+// JSON file so that queued work survives restarts.
+type JobQueue struct {
+	mu    sync.Mutex
+	dir   string
+	ttl   time.Duration
+	jobs  map[string]*Job
+	queue chan string
+	run   JobRunner
+}
+
+// NewJobQueue loads persisted jobs from dir and requeues the unfinished ones.
+func NewJobQueue(dir string, ttl time.Duration, capacity int, run JobRunner) (*JobQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &JobQueue{
+		dir:   dir,
+		ttl:   ttl,
+		jobs:  map[string]*Job{},
+		queue: make(chan string, capacity),
+		run:   run,
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			logger.Warn("Skipping unreadable job file", "error", err, "path", file)
+			continue
+		}
+		q.jobs[job.ID] = &job
+		// Jobs interrupted by a restart are started again from scratch
+		if job.Status == JobQueued || job.Status == JobRunning {
+			job.Status = JobQueued
+			job.StartedAt = nil
+			select {
+			case q.queue <- job.ID:
+			default:
+				q.finish(&job, nil, ErrQueueFull)
+			}
+		}
+	}
+	return q, nil
+}
+
+func (q *JobQueue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// persist writes a job to disk. The caller must hold q.mu.
+func (q *JobQueue) persist(job *Job) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		logger.Error("Failed to encode job", "error", err, "job_id", job.ID)
+		return
+	}
+	tmp := q.path(job.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Error("Failed to write job", "error", err, "job_id", job.ID)
+		return
+	}
+	if err := os.Rename(tmp, q.path(job.ID)); err != nil {
+		logger.Error("Failed to write job", "error", err, "job_id", job.ID)
+	}
+}
+
+// Enqueue stores a new job and hands it to the worker pool.
+func (q *JobQueue) Enqueue(job Job) (Job, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return Job{}, err
+	}
+	job.ID = id
+	job.Status = JobQueued
+	job.CreatedAt = time.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- id:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[id] = &job
+	q.persist(&job)
+	return job, nil
+}
+
+// Get returns a snapshot of a job.
+func (q *JobQueue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// Cancel stops a job that no worker has picked up yet.
+func (q *JobQueue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	if job.Status != JobQueued {
+		return *job, ErrJobNotQueued
+	}
+	now := time.Now()
+	job.Status = JobCancelled
+	job.FinishedAt = &now
+	q.persist(job)
+	return *job, nil
+}
+
+// finish records the outcome of a job. The caller must hold q.mu.
+func (q *JobQueue) finish(job *Job, result json.RawMessage, err error) {
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = JobDone
+		job.Result = result
+	}
+	q.persist(job)
+}
+
+// Start launches the workers and the expiry loop. It returns immediately.
+func (q *JobQueue) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		go q.worker(i)
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			q.expire(time.Now())
+		}
+	}()
+}
+
+func (q *JobQueue) worker(n int) {
+	for id := range q.queue {
+		q.mu.Lock()
+		job, ok := q.jobs[id]
+		if !ok || job.Status != JobQueued {
+			q.mu.Unlock()
+			continue
+		}
+		now := time.Now()
+		job.Status = JobRunning
+		job.StartedAt = &now
+		q.persist(job)
+		snapshot := *job
+		q.mu.Unlock()
+
+		logger.Info("Job started",
+			"job_id", id,
+			"worker", n,
+			"queued_ms", now.Sub(snapshot.CreatedAt).Milliseconds())
+
+		result, err := q.run(snapshot)
+
+		q.mu.Lock()
+		q.finish(job, result, err)
+		status := job.Status
+		q.mu.Unlock()
+
+		if err != nil {
+			logger.Error("Job failed", "error", err, "job_id", id)
+		}
+		logger.Info("Job finished",
+			"job_id", id,
+			"worker", n,
+			"status", status,
+			"duration_ms", time.Since(now).Milliseconds())
+	}
+}
+
+// expire removes finished jobs older than the queue's TTL.
+func (q *JobQueue) expire(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if job.FinishedAt == nil || now.Sub(*job.FinishedAt) < q.ttl {
+			continue
+		}
+		if err := os.Remove(q.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error("Failed to remove expired job", "error", err, "job_id", id)
+			continue
+		}
+		delete(q.jobs, id)
+		logger.Info("Job expired", "job_id", id)
+	}
+}
+
+// runAnalysisJob answers a job with the normal ChatGPTAnalyse pipeline.
+func runAnalysisJob(job Job) (json.RawMessage, error) {
+	apiKey, err := loadOpenAIKey(job.ClientIP)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := promptFromText(job.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := withModeration(job.Options, apiKey, job.ClientIP, job.APIKey)
+	// No client waits on a job, so only ANSWER_TIMEOUT bounds it
+	resultingText, used := answerQuestion(context.Background(), job.Text, prompt, apiKey, job.APIKey, opts)
+	resultingText = saveAnswer(resultingText)
+	requestdata.Info("Resulting text", "text", resultingText, "ip", job.ClientIP, "api_key", job.APIKey, "job_id", job.ID, "language", answerLanguage(resultingText, job.Text, opts), "total_tokens", used.TotalTokens, "cost_usd", used.CostUSD)
+
+	// ChatGPTAnalyse reports failures as plain text instead of a chatResponse
+	if !json.Valid([]byte(resultingText)) {
+		return nil, errors.New(resultingText)
+	}
+	return json.RawMessage(resultingText), nil
+}
+
+// jobQueueFromEnv builds the job queue from JOB_* environment variables.
+func jobQueueFromEnv() (*JobQueue, int, error) {
+	dir := os.Getenv("JOB_DIR")
+	if dir == "" {
+		dir = cfg.dataPath("jobs")
+	}
+	workers := 4
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, 0, fmt.Errorf("invalid JOB_WORKERS %q", v)
+		}
+		workers = n
+	}
+	ttl := 24 * time.Hour
+	if v := os.Getenv("JOB_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid JOB_TTL %q: %v", v, err)
+		}
+		ttl = d
+	}
+
+	q, err := NewJobQueue(dir, ttl, 256, runAnalysisJob)
+	return q, workers, err
+}
+
+var jobQueue *JobQueue
+
+// CreateJob handles POST /v1/jobs.
+func CreateJob(w http.ResponseWriter, r *http.Request) {
+	clientIP := ClientIP(r)
+
+	key, err := authenticateRequest(r)
+	if err != nil {
+		logger.Warn("API key rejected", "error", err, "ip", clientIP)
+		http.Error(w, err.Error(), keyAuthStatus(err))
+		return
+	}
+	opts := chatgpt.Options{}
+	if key != nil {
+		opts = key.Options()
+	}
+
+	var input Input
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			logger.Warn("Request body too large", "ip", clientIP, "content_length", r.ContentLength)
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		logger.Error("Invalid JSON format", "error", err, "ip", clientIP)
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(input.Text) == "" {
+		http.Error(w, "Text is required", http.StatusBadRequest)
+		return
+	}
+	// Jobs are persisted as JSON, which is no place for photos
+	if len(input.Images) > 0 {
+		http.Error(w, "Photos are not supported for jobs, ask with POST / instead", http.StatusBadRequest)
+		return
+	}
+	if opts, err = withLanguage(opts, input.Language); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := countRequest(key); err != nil {
+		logger.Warn("API key rejected", "error", err, "ip", clientIP, "key_id", keyID(key))
+		http.Error(w, err.Error(), keyAuthStatus(err))
+		return
+	}
+
+	job, err := jobQueue.Enqueue(Job{
+		Text:     input.Text,
+		Options:  opts,
+		APIKey:   keyID(key),
+		ClientIP: clientIP,
+	})
+	if err != nil {
+		logger.Error("Failed to enqueue job", "error", err, "ip", clientIP)
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	requestdata.Info("Received text", "text", input.Text, "ip", clientIP, "api_key", keyID(key), "job_id", job.ID)
+	logger.Info("Job queued", "job_id", job.ID, "ip", clientIP)
+
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job.View())
+}
+
+// requestJob returns the job named in the request path. Jobs asked with a
+// partner key are only shown to that key.
+func requestJob(r *http.Request) (Job, error) {
+	job, err := jobQueue.Get(r.PathValue("id"))
+	if err != nil {
+		return Job{}, err
+	}
+	if job.APIKey != keyID(nil) {
+		var key APIKey
+		if keyStore != nil {
+			key, err = keyStore.Lookup(requestToken(r))
+		}
+		if keyStore == nil || err != nil || key.ID != job.APIKey {
+			return Job{}, ErrJobNotFound
+		}
+	}
+	return job, nil
+}
+
+// GetJob handles GET /v1/jobs/{id}.
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := requestJob(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job.View())
+}
+
+// CancelJob handles DELETE /v1/jobs/{id}. Only queued jobs can be cancelled.
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := requestJob(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	job, err = jobQueue.Cancel(job.ID)
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrJobNotQueued):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	logger.Info("Job cancelled", "job_id", job.ID)
+	writeJSON(w, http.StatusOK, job.View())
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestJobServer serves the job endpoints from a queue that answers with
+// run. No workers are started, so jobs stay queued until the test starts them.
+func newTestJobServer(t *testing.T, run JobRunner) (*httptest.Server, *JobQueue) {
+	t.Helper()
+	q, err := NewJobQueue(t.TempDir(), time.Hour, 4, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobQueue = q
+	t.Cleanup(func() { jobQueue = nil })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/jobs", CreateJob)
+	mux.HandleFunc("GET /v1/jobs/{id}", GetJob)
+	mux.HandleFunc("DELETE /v1/jobs/{id}", CancelJob)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, q
+}
+
+func createJob(t *testing.T, srv *httptest.Server, body string) (*http.Response, JobView) {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/v1/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var view JobView
+	if resp.StatusCode == http.StatusAccepted {
+		if err := json.NewDecoder(resp.Body).Decode(&view); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, view
+}
+
+func jobRequest(t *testing.T, method, url string) (int, JobView) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var view JobView
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&view); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, view
+}
+
+// pollJob polls a job until it leaves the queue.
+func pollJob(t *testing.T, url string) JobView {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status, view := jobRequest(t, http.MethodGet, url)
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status %d", url, status)
+		}
+		if view.Status != JobQueued && view.Status != JobRunning {
+			return view
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", url)
+	return JobView{}
+}
+
+func TestJobCreateAndPoll(t *testing.T) {
+	srv, q := newTestJobServer(t, func(job Job) (json.RawMessage, error) {
+		return json.RawMessage(`{"shortresponse":"` + job.Text + `"}`), nil
+	})
+
+	resp, view := createJob(t, srv, `{"text":"Kada je penzija?"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	if view.Status != JobQueued {
+		t.Errorf("new job status %q, want %q", view.Status, JobQueued)
+	}
+	location := resp.Header.Get("Location")
+	if location != "/v1/jobs/"+view.ID {
+		t.Errorf("Location = %q", location)
+	}
+	if _, err := os.Stat(filepath.Join(q.dir, view.ID+".json")); err != nil {
+		t.Errorf("job not persisted: %v", err)
+	}
+
+	q.Start(1)
+	done := pollJob(t, srv.URL+location)
+	if done.Status != JobDone || string(done.Result) != `{"shortresponse":"Kada je penzija?"}` {
+		t.Errorf("finished job = %+v", done)
+	}
+}
+
+func TestJobCreateRejectsBadInput(t *testing.T) {
+	srv, _ := newTestJobServer(t, nil)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"invalid json", `{"text":`, http.StatusBadRequest},
+		{"empty text", `{"text":"  "}`, http.StatusBadRequest},
+		{"with photos", `{"text":"Šta piše na računu?","images":["iVBORw0KGgo="]}`, http.StatusBadRequest},
+		{"too large", `{"text":"` + strings.Repeat("a", maxRequestBytes) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		resp, _ := createJob(t, srv, tt.body)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestJobFailureHidesError(t *testing.T) {
+	srv, q := newTestJobServer(t, func(job Job) (json.RawMessage, error) {
+		return nil, errors.New("An error occurred: 401 Unauthorized sk-proj-123")
+	})
+
+	resp, _ := createJob(t, srv, `{"text":"Kada je penzija?"}`)
+	q.Start(1)
+	failed := pollJob(t, srv.URL+resp.Header.Get("Location"))
+	if failed.Status != JobFailed || failed.Error != answerFailedMessage {
+		t.Errorf("failed job = %+v, want error %q", failed, answerFailedMessage)
+	}
+}
+
+func TestJobExpiry(t *testing.T) {
+	srv, q := newTestJobServer(t, func(job Job) (json.RawMessage, error) {
+		return json.RawMessage(`{}`), nil
+	})
+
+	resp, view := createJob(t, srv, `{"text":"Kada je penzija?"}`)
+	q.Start(1)
+	url := srv.URL + resp.Header.Get("Location")
+	pollJob(t, url)
+
+	q.expire(time.Now())
+	if status, _ := jobRequest(t, http.MethodGet, url); status != http.StatusOK {
+		t.Fatalf("job expired before its TTL: status %d", status)
+	}
+	q.expire(time.Now().Add(2 * time.Hour))
+	if status, _ := jobRequest(t, http.MethodGet, url); status != http.StatusNotFound {
+		t.Errorf("expired job: status %d, want %d", status, http.StatusNotFound)
+	}
+	if _, err := os.Stat(filepath.Join(q.dir, view.ID+".json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expired job file still present: %v", err)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	ran := make(chan string, 1)
+	srv, q := newTestJobServer(t, func(job Job) (json.RawMessage, error) {
+		ran <- job.ID
+		return json.RawMessage(`{}`), nil
+	})
+
+	resp, _ := createJob(t, srv, `{"text":"Kada je penzija?"}`)
+	url := srv.URL + resp.Header.Get("Location")
+	status, view := jobRequest(t, http.MethodDelete, url)
+	if status != http.StatusOK || view.Status != JobCancelled {
+		t.Fatalf("cancel: status %d, job %+v", status, view)
+	}
+	if status, _ := jobRequest(t, http.MethodDelete, url); status != http.StatusConflict {
+		t.Errorf("cancelling twice: status %d, want %d", status, http.StatusConflict)
+	}
+	if status, _ := jobRequest(t, http.MethodDelete, srv.URL+"/v1/jobs/missing"); status != http.StatusNotFound {
+		t.Errorf("cancelling a missing job: status %d, want %d", status, http.StatusNotFound)
+	}
+
+	// The worker skips the cancelled job but still runs the next one
+	resp, next := createJob(t, srv, `{"text":"Gdje je dom zdravlja?"}`)
+	q.Start(1)
+	pollJob(t, srv.URL+resp.Header.Get("Location"))
+	if id := <-ran; id != next.ID {
+		t.Errorf("worker ran job %s, want %s", id, next.ID)
+	}
+	if _, view := jobRequest(t, http.MethodGet, url); view.Status != JobCancelled {
+		t.Errorf("cancelled job status %q, want %q", view.Status, JobCancelled)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"code.com/chatgpt"
+)
+
+const keysUsage = `usage: seniorlabai keys <command> [flags]
+
+commands:
+  list                      list all api keys
+  create -name NAME [...]   issue a new api key
+  revoke ID                 revoke an api key
+  rotate ID                 issue a new secret for an api key
+`
+
+// runKeysCommand implements the "keys" admin command and returns the exit code.
+func runKeysCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	store, err := LoadKeyStore(apiKeysPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading api keys: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := store.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error listing api keys: %v\n", err)
+			return 1
+		}
+		return printJSON(keys)
+
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		name := fs.String("name", "", "name of the partner organisation")
+		daily := fs.Int("daily", 0, "daily request quota (0 = unlimited)")
+		monthly := fs.Int("monthly", 0, "monthly request quota (0 = unlimited)")
+		origins := fs.String("origins", "", "comma separated list of allowed browser origins")
+		language := fs.String("language", "", "default answer language (bs, hr, sr, sr-Cyrl, en); detected from the question when empty")
+		length := fs.String("length", "", "default answer length (short, normal, long)")
+		tools := fs.String("tools", "all", `tools the assistant may use with this key, comma separated, or "all" or "none"`)
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if strings.TrimSpace(*name) == "" {
+			fmt.Fprintln(os.Stderr, "keys create: -name is required")
+			return 2
+		}
+		if !chatgpt.ValidAnswerLength(*length) {
+			fmt.Fprintf(os.Stderr, "keys create: unknown answer length %q\n", *length)
+			return 2
+		}
+
+		key := APIKey{
+			Name:         *name,
+			DailyQuota:   *daily,
+			MonthlyQuota: *monthly,
+			Defaults:     KeyDefaults{Language: *language, AnswerLength: *length},
+		}
+		defaults, err := withLanguage(chatgpt.Options{}, *language)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "keys create: %v\n", err)
+			return 2
+		}
+		key.Defaults.Language = defaults.Language
+		if key.Tools, err = chatgpt.ParseToolList(*tools); err != nil {
+			fmt.Fprintf(os.Stderr, "keys create: %v\n", err)
+			return 2
+		}
+		for _, origin := range strings.Split(*origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				key.AllowedOrigins = append(key.AllowedOrigins, origin)
+			}
+		}
+		created, token, err := store.Create(key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating api key: %v\n", err)
+			return 1
+		}
+		return printJSON(issuedKeyResponse{Key: created, Token: token})
+
+	case "revoke", "rotate":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, keysUsage)
+			return 2
+		}
+		var resp issuedKeyResponse
+		if args[0] == "revoke" {
+			resp.Key, err = store.Revoke(args[1])
+		} else {
+			resp.Key, resp.Token, err = store.Rotate(args[1])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return printJSON(resp)
+
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+}
+
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding output: %v\n", err)
+		return 1
+	}
+	return 0
+}
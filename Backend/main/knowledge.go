@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"code.com/chatgpt"
+)
+
+// runKnowledgeIngestCommand indexes the vetted guides under the given
+// directories for the search_knowledge_base tool. Passages that did not
+// change since the last run are not embedded again.
+func runKnowledgeIngestCommand(args []string) int {
+	fs := flag.NewFlagSet("kb-ingest", flag.ContinueOnError)
+	index := fs.String("index", cfg.KnowledgeIndex, "index file to write")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: seniorlabai kb-ingest [-index F] DIR...")
+		return 2
+	}
+
+	previous := chatgpt.Knowledge
+	if *index != cfg.KnowledgeIndex {
+		previous, _ = chatgpt.LoadKnowledgeIndex(*index)
+	}
+	idx, stats, err := chatgpt.IngestDocuments(context.Background(), chatgpt.KnowledgeEmbedder, fs.Args(), previous)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if err := idx.Save(*index); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", *index, err)
+		return 1
+	}
+	return printJSON(struct {
+		Index string `json:"index"`
+		Model string `json:"model"`
+		chatgpt.IngestStats
+	}{*index, idx.Model, stats})
+}
+
+// runKnowledgeSearchCommand prints the passages search_knowledge_base would
+// give the model for a query.
+func runKnowledgeSearchCommand(args []string) int {
+	fs := flag.NewFlagSet("kb-search", flag.ContinueOnError)
+	count := fs.Int("count", chatgpt.KnowledgeResults, "number of passages")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	query := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if query == "" {
+		fmt.Fprintln(os.Stderr, "usage: seniorlabai kb-search [-count N] QUERY")
+		return 2
+	}
+
+	results, err := chatgpt.SearchKnowledge(context.Background(), chatgpt.Knowledge, chatgpt.KnowledgeEmbedder, query, *count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	type passage struct {
+		Document string  `json:"document"`
+		Title    string  `json:"title"`
+		Section  string  `json:"section,omitempty"`
+		Score    float32 `json:"score"`
+		Text     string  `json:"text"`
+	}
+	passages := []passage{}
+	for _, r := range results {
+		passages = append(passages, passage{r.Document, r.Title, r.Section, r.Score, r.Text})
+	}
+	return printJSON(passages)
+}
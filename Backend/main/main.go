@@ -3,11 +3,16 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"log/slog"
 
@@ -17,20 +22,84 @@ import (
 
 type Input struct {
 	Text string `json:"text"`
+	// Images are optional base64 encoded photos (plain or as data URLs).
+	Images []string `json:"images,omitempty"`
+	// Language selects the answer language, overriding the API key default.
+	// The language of the text is detected when it is empty.
+	Language string `json:"language,omitempty"`
 }
 
 var logger *slog.Logger
 var requestdata *slog.Logger
+var auditlog *slog.Logger
+var keyStore *KeyStore
 
-func ChatGPTHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve the IP address from X-Forwarded-For header or fall back to RemoteAddr
-	clientIP := r.Header.Get("X-Forwarded-For")
-	if clientIP == "" {
-		clientIP = r.RemoteAddr
-	} else {
-		// Extract the first IP if there are multiple IPs
-		clientIP = strings.Split(clientIP, ",")[0]
+const (
+	adminUsername = "SeniorLAB"
+	adminPassword = "jenajbolji"
+)
+
+// answerFailedMessage is sent to clients when a question could not be
+// answered. The underlying error can contain provider details and is only logged.
+const answerFailedMessage = "The question could not be answered, please try again later"
+
+// apiKeysPath returns the location of the API key file.
+func apiKeysPath() string {
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		return path
+	}
+	return cfg.dataPath("apikeys.json")
+}
+
+// loadOpenAIKey loads the environment and returns the OpenAI API key.
+// The returned error is safe to show to the client.
+func loadOpenAIKey(clientIP string) (string, error) {
+	// Load environment variables
+	if err := godotenv.Load(cfg.EnvFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Error("Environment configuration error",
+			"error", err,
+			"ip", clientIP,
+			"file", cfg.EnvFile)
+		return "", errors.New("Configuration error")
+	}
+
+	logger.Info("Environment loaded successfully", "ip", clientIP)
+
+	// Other providers bring their own key; OpenAI is then only needed for moderation and speech
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" && (cfg.Provider.Name == "" || cfg.Provider.Name == chatgpt.ProviderOpenAI) {
+		logger.Error("OPENAI_API_KEY is not set in .env file", "ip", clientIP)
+		return "", errors.New("API key error")
+	}
+	return apiKey, nil
+}
+
+// promptFromText wraps the user's question in the JSON envelope ChatGPTAnalyse expects.
+func promptFromText(text string) (string, error) {
+	jsonResp, err := json.Marshal(map[string]string{"received": text})
+	if err != nil {
+		return "", err
+	}
+	return string(jsonResp), nil
+}
+
+// withLanguage applies the answer language requested by the client. The
+// returned error is safe to show to the client.
+func withLanguage(opts chatgpt.Options, language string) (chatgpt.Options, error) {
+	if language == "" {
+		return opts, nil
+	}
+	code, ok := chatgpt.NormalizeLanguage(language)
+	if !ok {
+		return opts, fmt.Errorf("Unsupported language %q", language)
 	}
+	opts.Language = code
+	return opts, nil
+}
+
+func ChatGPTHandler(w http.ResponseWriter, r *http.Request) {
+	// Resolved by the client identity middleware from trusted proxy headers
+	clientIP := ClientIP(r)
 
 	logger.Info("Incoming request",
 		"method", r.Method,
@@ -42,65 +111,138 @@ func ChatGPTHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 
-		// Load environment variables
-		if err := godotenv.Load("./.env"); err != nil {
-			logger.Error("Environment configuration error",
+		// Resolve the partner API key, if any, and enforce its quota
+		key, err := authenticateRequest(r)
+		if err != nil {
+			logger.Warn("API key rejected",
 				"error", err,
 				"ip", clientIP,
-				"file", ".env")
-			http.Error(w, "Configuration error", http.StatusInternalServerError)
+				"origin", r.Header.Get("Origin"))
+			http.Error(w, err.Error(), keyAuthStatus(err))
 			return
 		}
+		opts := chatgpt.Options{}
+		if key != nil {
+			opts = key.Options()
+			logger.Info("API key accepted",
+				"ip", clientIP,
+				"key_id", key.ID,
+				"key_name", key.Name,
+				"day_count", key.Usage.DayCount,
+				"month_count", key.Usage.MonthCount)
+		}
 
-		logger.Info("Environment loaded successfully", "ip", clientIP)
-
-		apiKey := os.Getenv("OPENAI_API_KEY")
-		if apiKey == "" {
-			logger.Error("OPENAI_API_KEY is not set in .env file", "ip", clientIP)
-			http.Error(w, "API key error", http.StatusInternalServerError)
+		apiKey, err := loadOpenAIKey(clientIP)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		// Read the request body
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			logger.Error("Request body read error",
-				"error", err,
+		r.Body = http.MaxBytesReader(w, r.Body, maxImageRequestBytes)
+		defer r.Body.Close()
+
+		var input Input
+		var rawImages [][]byte
+		if isMultipart(r) {
+			// Photo questions may be uploaded as a form with "text" and "image" fields
+			input, rawImages, err = readMultipartInput(r)
+			if err != nil {
+				logger.Error("Invalid multipart request", "error", err, "ip", clientIP)
+				var tooLarge *http.MaxBytesError
+				switch {
+				case errors.As(err, &tooLarge):
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				case errors.Is(err, chatgpt.ErrImageTooLarge):
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				case errors.Is(err, chatgpt.ErrTooManyImages):
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					http.Error(w, "Invalid multipart request", http.StatusBadRequest)
+				}
+				return
+			}
+		} else {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				logger.Error("Request body read error",
+					"error", err,
+					"ip", clientIP,
+					"content_length", r.ContentLength)
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "Unable to read request body", http.StatusBadRequest)
+				return
+			}
+
+			logger.Info("Request body read successfully",
 				"ip", clientIP,
-				"content_length", r.ContentLength)
-			http.Error(w, "Unable to read request body", http.StatusBadRequest)
-			return
+				"body_size", len(body))
+
+			// Parse the JSON input
+			if err := json.Unmarshal(body, &input); err != nil {
+				logger.Error("Invalid JSON format", "error", err, "ip", clientIP)
+				http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+				return
+			}
+			if rawImages, err = decodeImages(input.Images); err != nil {
+				logger.Error("Invalid image encoding", "error", err, "ip", clientIP)
+				http.Error(w, "Invalid image encoding", http.StatusBadRequest)
+				return
+			}
 		}
-		defer r.Body.Close()
 
-		logger.Info("Request body read successfully",
-			"ip", clientIP,
-			"body_size", len(body))
+		if opts, err = withLanguage(opts, input.Language); err != nil {
+			logger.Warn("Unsupported language", "language", input.Language, "ip", clientIP)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		// Parse the JSON input
-		var input Input
-		if err := json.Unmarshal(body, &input); err != nil {
-			logger.Error("Invalid JSON format", "error", err, "ip", clientIP)
-			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		opts.Images, err = prepareImages(rawImages)
+		if err != nil {
+			logger.Error("Image rejected", "error", err, "ip", clientIP, "image_count", len(rawImages))
+			status := http.StatusBadRequest
+			if errors.Is(err, chatgpt.ErrImageTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
 			return
 		}
 
 		// Access the `text` field from the input
 		text := input.Text
-		requestdata.Info("Received text", "text", text, "ip", clientIP)
+		requestdata.Info("Received text", "text", text, "ip", clientIP, "api_key", keyID(key), "images", len(opts.Images))
 
 		// Process the `text` variable as needed
 		w.Header().Set("Content-Type", "application/json")
-		response := map[string]string{"received": text}
-		jsonResp, err := json.Marshal(response)
+		prompt, err := promptFromText(text)
 		if err != nil {
 			logger.Error("Error forming JSON response", "error", err, "ip", clientIP)
 			http.Error(w, "JSON error response", http.StatusInternalServerError)
 			return
 		}
 
-		resultingText := chatgpt.ChatGPTAnalyse(string(jsonResp), apiKey)
-		requestdata.Info("Resulting text", "text", resultingText, "ip", clientIP)
+		// Only valid questions count against the key's quota
+		if err := countRequest(key); err != nil {
+			logger.Warn("API key rejected", "error", err, "ip", clientIP, "key_id", keyID(key))
+			http.Error(w, err.Error(), keyAuthStatus(err))
+			return
+		}
+
+		opts = withModeration(opts, apiKey, clientIP, keyID(key))
+		resultingText, used := answerQuestion(r.Context(), text, prompt, apiKey, keyID(key), opts)
+		resultingText = saveAnswer(resultingText)
+		requestdata.Info("Resulting text", "text", resultingText, "ip", clientIP, "api_key", keyID(key), "language", answerLanguage(resultingText, text, opts), "total_tokens", used.TotalTokens, "cost_usd", used.CostUSD)
+
+		// ChatGPTAnalyse reports failures as plain text instead of a chatResponse
+		if !json.Valid([]byte(resultingText)) {
+			logger.Error("Question could not be answered", "error", resultingText, "ip", clientIP)
+			http.Error(w, answerFailedMessage, http.StatusBadGateway)
+			return
+		}
 		_, err = w.Write([]byte(resultingText))
 		if err != nil {
 			logger.Error("Error writing response", "error", err, "ip", clientIP)
@@ -116,10 +258,7 @@ func ChatGPTHandler(w http.ResponseWriter, r *http.Request) {
 // BasicAuth middleware provides simple HTTP basic authentication
 func BasicAuth(next http.Handler, username, password string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clientIP := r.Header.Get("X-Forwarded-For")
-		if clientIP == "" {
-			clientIP = r.RemoteAddr
-		}
+		clientIP := ClientIP(r)
 
 		logger.Info("Authentication attempt",
 			"ip", clientIP,
@@ -205,10 +344,7 @@ func parseLogFile(filepath string) ([]LogEntry, error) {
 }
 
 func serveLogViewer(w http.ResponseWriter, r *http.Request, logFile string) {
-	clientIP := r.Header.Get("X-Forwarded-For")
-	if clientIP == "" {
-		clientIP = r.RemoteAddr
-	}
+	clientIP := ClientIP(r)
 
 	logger.Info("Log viewer request",
 		"ip", clientIP,
@@ -219,10 +355,7 @@ func serveLogViewer(w http.ResponseWriter, r *http.Request, logFile string) {
 }
 
 func serveLogData(w http.ResponseWriter, r *http.Request, logFile string) {
-	clientIP := r.Header.Get("X-Forwarded-For")
-	if clientIP == "" {
-		clientIP = r.RemoteAddr
-	}
+	clientIP := ClientIP(r)
 
 	logger.Info("Log data request",
 		"ip", clientIP,
@@ -244,47 +377,80 @@ func serveLogData(w http.ResponseWriter, r *http.Request, logFile string) {
 
 func SendLogs(w http.ResponseWriter, r *http.Request) {
 	if strings.HasSuffix(r.URL.Path, "/data") {
-		serveLogData(w, r, "/app/logs/logfile.log")
+		serveLogData(w, r, cfg.logFile("logfile.log"))
+	} else {
+		serveLogViewer(w, r, cfg.logFile("logfile.log"))
+	}
+}
+
+func SendAudit(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/data") {
+		serveLogData(w, r, cfg.logFile("audit.log"))
 	} else {
-		serveLogViewer(w, r, "/app/logs/logfile.log")
+		serveLogViewer(w, r, cfg.logFile("audit.log"))
 	}
 }
 
 func SendUsage(w http.ResponseWriter, r *http.Request) {
 	if strings.HasSuffix(r.URL.Path, "/data") {
-		serveLogData(w, r, "/app/logs/usage.log")
+		serveLogData(w, r, cfg.logFile("usage.log"))
 	} else {
-		serveLogViewer(w, r, "/app/logs/usage.log")
+		serveLogViewer(w, r, cfg.logFile("usage.log"))
 	}
 }
 
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// runServe starts the HTTP server. It only returns on startup failure.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "port to listen on")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
 	slog.Info("Initializing server...")
 
+	logPath := cfg.logFile("logfile.log")
+	usagePath := cfg.logFile("usage.log")
+	auditPath := cfg.logFile("audit.log")
+
 	// Open the log file
-	file, err := os.OpenFile("/app/logs/logfile.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	file, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		// If logging setup fails, use the default logger to report the error and exit
 		slog.Default().Error("Error opening log file",
 			"error", err,
-			"path", "/app/logs/logfile.log")
-		os.Exit(1)
+			"path", logPath)
+		return 1
 	}
 	defer file.Close()
 
-	file2, err := os.OpenFile("/app/logs/usage.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	file2, err := os.OpenFile(usagePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		// If logging setup fails, use the default logger to report the error and exit
 		slog.Default().Error("Error opening usage log file",
 			"error", err,
-			"path", "/app/logs/usage.log")
-		os.Exit(1)
+			"path", usagePath)
+		return 1
 	}
 	defer file2.Close()
 
+	file3, err := os.OpenFile(auditPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		slog.Default().Error("Error opening audit log file",
+			"error", err,
+			"path", auditPath)
+		return 1
+	}
+	defer file3.Close()
+
 	slog.Info("Log files opened successfully",
-		"logfile", "/app/logs/logfile.log",
-		"usagelog", "/app/logs/usage.log")
+		"logfile", logPath,
+		"usagelog", usagePath,
+		"auditlog", auditPath)
 
 	// Create a JSON handler for structured logging
 	handler := slog.NewJSONHandler(file, &slog.HandlerOptions{
@@ -295,27 +461,152 @@ func main() {
 	})
 	logger = slog.New(handler)
 	requestdata = slog.New(handler2)
+	auditlog = slog.New(slog.NewJSONHandler(file3, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
 	slog.SetDefault(logger) // Set as the default logger
 	http.HandleFunc("/", ChatGPTHandler)
 
 	// Wrap the log endpoints with BasicAuth middleware
-	logHandler := BasicAuth(http.HandlerFunc(SendLogs), "SeniorLAB", "jenajbolji")
-	usageHandler := BasicAuth(http.HandlerFunc(SendUsage), "SeniorLAB", "jenajbolji")
+	logHandler := BasicAuth(http.HandlerFunc(SendLogs), adminUsername, adminPassword)
+	usageHandler := BasicAuth(http.HandlerFunc(SendUsage), adminUsername, adminPassword)
+	auditHandler := BasicAuth(http.HandlerFunc(SendAudit), adminUsername, adminPassword)
+	statsHandler := BasicAuth(http.HandlerFunc(SendStats), adminUsername, adminPassword)
 
 	// Handle both the viewer and data endpoints
 	http.Handle("/logfile", logHandler)
 	http.Handle("/logfile/data", logHandler)
 	http.Handle("/usage", usageHandler)
 	http.Handle("/usage/data", usageHandler)
+	http.Handle("/audit", auditHandler)
+	http.Handle("/audit/data", auditHandler)
+	http.Handle("/stats", statsHandler)
+	http.Handle("/stats/data", statsHandler)
+
+	// Partner API key administration
+	keyStore, err = LoadKeyStore(apiKeysPath())
+	if err != nil {
+		logger.Error("Error loading api keys",
+			"error", err,
+			"path", apiKeysPath())
+		return 1
+	}
+	// Request counts are kept in memory and written out periodically
+	go func() {
+		for range time.Tick(KeyUsageFlushInterval) {
+			if err := keyStore.Flush(); err != nil {
+				logger.Error("Error saving api key usage", "error", err)
+			}
+		}
+	}()
+	http.Handle("GET /admin/keys", BasicAuth(http.HandlerFunc(AdminListKeys), adminUsername, adminPassword))
+	http.Handle("POST /admin/keys", BasicAuth(http.HandlerFunc(AdminCreateKey), adminUsername, adminPassword))
+	http.Handle("POST /admin/keys/{id}/revoke", BasicAuth(http.HandlerFunc(AdminRevokeKey), adminUsername, adminPassword))
+	http.Handle("POST /admin/keys/{id}/rotate", BasicAuth(http.HandlerFunc(AdminRotateKey), adminUsername, adminPassword))
+
+	// Asynchronous job mode for long-running questions
+	var workers int
+	jobQueue, workers, err = jobQueueFromEnv()
+	if err != nil {
+		logger.Error("Error loading job queue", "error", err)
+		return 1
+	}
+	jobQueue.Start(workers)
+	http.HandleFunc("POST /v1/jobs", CreateJob)
+	http.HandleFunc("GET /v1/jobs/{id}", GetJob)
+	http.HandleFunc("DELETE /v1/jobs/{id}", CancelJob)
+
+	// Stored answers and their spoken versions
+	answerStore, err = answerStoreFromEnv()
+	if err != nil {
+		logger.Error("Error opening answer store", "error", err)
+		return 1
+	}
+	answerStore.Start(cfg.dataPath("audio"))
+	http.HandleFunc("GET /v1/answers/{id}/audio", AnswerAudio)
+	http.Handle("GET /admin/answers/{id}", BasicAuth(http.HandlerFunc(AdminGetAnswer), adminUsername, adminPassword))
+
+	// Spending limits, and the cached and FAQ answers served when they are reached
+	usageEntries, err := parseLogFile(usagePath)
+	if err != nil {
+		logger.Error("Error reading usage log", "error", err, "path", usagePath)
+		return 1
+	}
+	budget, err = budgetFromEnv(usageEntries)
+	if err != nil {
+		logger.Error("Invalid budget configuration", "error", err)
+		return 1
+	}
+	if err := cachedAnswers.loadFAQ(cfg.dataPath("faq.json")); err != nil {
+		logger.Error("Error loading FAQ answers", "error", err, "path", cfg.dataPath("faq.json"))
+		return 1
+	}
+	if budget != nil {
+		state := budget.State()
+		logger.Info("Budget enabled",
+			"level", state.Level.String(),
+			"spent_today", state.SpentToday,
+			"daily_limit", state.DailyLimit,
+			"spent_month", state.SpentMonth,
+			"monthly_limit", state.MonthlyLimit)
+	}
+
+	// Vetted guides for the search_knowledge_base tool
+	if kb := chatgpt.Knowledge; kb != nil {
+		logger.Info("Knowledge base loaded",
+			"index", cfg.KnowledgeIndex,
+			"model", kb.Model,
+			"documents", kb.Documents(),
+			"chunks", len(kb.Chunks))
+		if kb.Model != chatgpt.KnowledgeEmbedder.Model() {
+			logger.Warn("Knowledge index was built with another embedding model; run kb-ingest again",
+				"index_model", kb.Model,
+				"embedder_model", chatgpt.KnowledgeEmbedder.Model())
+		}
+	}
+
+	if len(chatgpt.Medicines) > 0 {
+		logger.Info("Medicines dataset loaded",
+			"file", cfg.MedicationsFile,
+			"medicines", len(chatgpt.Medicines))
+	}
+
+	if chatgpt.Transit != nil {
+		logger.Info("Public transport timetables loaded",
+			"feeds", cfg.GTFSFeeds,
+			"stops", chatgpt.Transit.Stops(),
+			"trips", chatgpt.Transit.Trips())
+	}
+
+	// Voice questions
+	http.HandleFunc("POST /v1/ask/audio", AskAudioHandler)
+
+	// Interactive conversations with progress events and streamed tokens
+	http.HandleFunc("GET /v1/ws", WebSocketHandler)
 
 	logger.Info("Starting server",
-		"port", 8468,
-		"handlers", []string{"/", "/logfile", "/usage"})
+		"port", cfg.Port,
+		"handlers", []string{"/", "/logfile", "/usage", "/audit", "/stats", "/admin/keys", "/admin/answers", "/v1/jobs", "/v1/ws", "/v1/answers", "/v1/ask/audio"})
+
+	// Resolve client addresses once, trusting forwarding headers only from our proxies
+	trustedProxies, err := trustedProxiesFromEnv()
+	if err != nil {
+		logger.Error("Invalid trusted proxy configuration", "error", err)
+		return 1
+	}
+	clientIPHeader, err := clientIPHeaderFromEnv()
+	if err != nil {
+		logger.Error("Invalid client IP header configuration", "error", err)
+		return 1
+	}
+	clientResolver := NewClientResolver(trustedProxies, clientIPHeader)
 
-	if err := http.ListenAndServe(":8468", nil); err != nil {
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	if err := http.ListenAndServe(addr, clientResolver.Middleware(http.DefaultServeMux)); err != nil {
 		logger.Error("Server startup failed",
 			"error", err,
-			"port", 8468)
-		os.Exit(1)
+			"port", cfg.Port)
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"code.com/chatgpt"
+)
+
+// runMedicationImportCommand checks a medicines dataset, e.g. a CSV export
+// of the register of medicines, and writes it as the JSON dataset of the
+// medication_info tool.
+func runMedicationImportCommand(args []string) int {
+	fs := flag.NewFlagSet("med-import", flag.ContinueOnError)
+	out := fs.String("o", cfg.MedicationsFile, "dataset file to write")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: seniorlabai med-import [-o F] FILE")
+		return 2
+	}
+
+	medicines, err := chatgpt.LoadMedicines(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if err := chatgpt.SaveMedicines(*out, medicines); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", *out, err)
+		return 1
+	}
+
+	withWarnings := 0
+	for _, m := range medicines {
+		if len(m.Warnings) > 0 {
+			withWarnings++
+		}
+	}
+	return printJSON(map[string]any{
+		"file":          *out,
+		"medicines":     len(medicines),
+		"with_warnings": withWarnings,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"code.com/chatgpt"
+)
+
+// withModeration adds the moderation stage configured through MODERATION_*
+// environment variables to opts. Flagged requests go to the audit log.
+// Moderation is off by default when there is no OpenAI key. Questions are
+// not answered when moderation fails, unless MODERATION_FAIL_OPEN is set.
+func withModeration(opts chatgpt.Options, apiKey, clientIP, apiKeyID string) chatgpt.Options {
+	provider := os.Getenv("MODERATION_PROVIDER")
+	if provider == "" && apiKey == "" {
+		provider = "off"
+	}
+	switch provider {
+	case "off":
+		return opts
+	case "stub":
+		var blocked []string
+		for _, word := range strings.Split(os.Getenv("MODERATION_BLOCKLIST"), ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				blocked = append(blocked, word)
+			}
+		}
+		opts.Moderator = chatgpt.StubModerator{Blocked: blocked}
+	case "", "openai":
+		opts.Moderator = chatgpt.NewOpenAIModerator(apiKey)
+	default:
+		logger.Error("Unknown moderation provider, using openai", "provider", provider)
+		opts.Moderator = chatgpt.NewOpenAIModerator(apiKey)
+	}
+
+	policy, err := chatgpt.ParseModerationPolicy(os.Getenv("MODERATION_THRESHOLDS"))
+	if err != nil {
+		logger.Error("Invalid moderation thresholds, using defaults", "error", err)
+		policy = chatgpt.DefaultModerationPolicy
+	}
+	if v := os.Getenv("MODERATION_FAIL_OPEN"); v != "" {
+		if policy.FailOpen, err = strconv.ParseBool(v); err != nil {
+			logger.Error("Invalid MODERATION_FAIL_OPEN, questions are not answered when moderation fails", "value", v)
+		}
+	}
+	opts.ModerationPolicy = &policy
+	if auditlog != nil {
+		opts.Audit = auditlog.With("ip", clientIP, "api_key", apiKeyID)
+	}
+	return opts
+}
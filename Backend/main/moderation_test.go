@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"code.com/chatgpt"
+)
+
+func TestWithModerationProvider(t *testing.T) {
+	tests := []struct {
+		provider string
+		apiKey   string
+		want     bool
+	}{
+		{"", "sk-test", true},
+		{"", "", false},
+		{"off", "sk-test", false},
+		{"stub", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("MODERATION_PROVIDER", tt.provider)
+		opts := withModeration(chatgpt.Options{}, tt.apiKey, "127.0.0.1", "")
+		if got := opts.Moderator != nil; got != tt.want {
+			t.Errorf("provider %q with key %q: moderated = %v, want %v", tt.provider, tt.apiKey, got, tt.want)
+		}
+	}
+}
+
+func TestWithModerationFailOpen(t *testing.T) {
+	t.Setenv("MODERATION_PROVIDER", "stub")
+	for _, tt := range []struct {
+		value string
+		want  bool
+	}{{"", false}, {"true", true}, {"false", false}, {"sometimes", false}} {
+		t.Setenv("MODERATION_FAIL_OPEN", tt.value)
+		if got := withModeration(chatgpt.Options{}, "", "127.0.0.1", "").ModerationPolicy.FailOpen; got != tt.want {
+			t.Errorf("MODERATION_FAIL_OPEN=%q: fail open = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.com/chatgpt"
+	"golang.org/x/net/html"
+)
+
+// CheckResult is the outcome of one rubric check on one answer.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// evalAnswer is the part of a chatResponse the rubric looks at.
+type evalAnswer struct {
+	Content struct {
+		Longresponse  string `json:"longresponse"`
+		Shortresponse string `json:"shortresponse"`
+		Title         string `json:"title"`
+	} `json:"content"`
+	InternetSearch bool `json:"internet_search"`
+	Moderated      bool `json:"moderated"`
+}
+
+// wordLimitSlack is how far over the prompt's word limit an answer may go
+// before the check fails; the model counts words loosely.
+const wordLimitSlack = 1.1
+
+var (
+	markdownPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\*\*[^*]+\*\*`),
+		regexp.MustCompile(`__[^_]+__`),
+		regexp.MustCompile(`(?m)^\s*#{1,6}\s`),
+		regexp.MustCompile(`\[[^\]]+\]\([^)]+\)`),
+		regexp.MustCompile("`"),
+		regexp.MustCompile(`(?m)^\s*[-*]\s+\S`),
+	}
+	htmlTagPattern = regexp.MustCompile(`<[a-zA-Z/][^>]*>`)
+
+	emergencyKeywords = []string{"hitn", "policij", "vatrogas", "nasilj", "emergency", "police", "ambulance", "fire"}
+	// emergencyNumbers must appear when the question asks for emergency services.
+	emergencyNumbers = []string{"122", "123", "124"}
+	// wrongEmergencyNumbers belong to other countries and must never be given.
+	wrongEmergencyNumbers = regexp.MustCompile(`\b(911|192|193|194|999)\b`)
+)
+
+// rubricCase is what the rubric needs to know about the question.
+type rubricCase struct {
+	Question     string
+	AnswerLength string
+	// Emergency forces the emergency number check; otherwise it runs when
+	// the question mentions emergency services.
+	Emergency bool
+	// MustContain lists phrases the long response has to contain.
+	MustContain []string
+}
+
+// scoreAnswer runs the rubric checks on an answer.
+func scoreAnswer(c rubricCase, a evalAnswer) []CheckResult {
+	long := a.Content.Longresponse
+	short := a.Content.Shortresponse
+	longText := htmlText(long)
+
+	var checks []CheckResult
+	longLimit, shortLimit := chatgpt.WordLimits(c.AnswerLength)
+	checks = append(checks,
+		wordLimitCheck("long_word_limit", longText, longLimit),
+		wordLimitCheck("short_word_limit", short, shortLimit),
+		noMarkdownCheck(long, short),
+		plainShortCheck(short),
+		linksCheck(long),
+	)
+	if c.Emergency || mentionsEmergency(c.Question) {
+		checks = append(checks, emergencyCheck(longText+" "+short))
+	}
+	for _, phrase := range c.MustContain {
+		result := CheckResult{Name: "contains", Passed: strings.Contains(strings.ToLower(longText), strings.ToLower(phrase))}
+		result.Detail = phrase
+		checks = append(checks, result)
+	}
+	return checks
+}
+
+func wordLimitCheck(name, text string, limit int) CheckResult {
+	words := len(strings.Fields(text))
+	return CheckResult{
+		Name:   name,
+		Passed: words > 0 && float64(words) <= float64(limit)*wordLimitSlack,
+		Detail: fmt.Sprintf("%d words, limit %d", words, limit),
+	}
+}
+
+func noMarkdownCheck(texts ...string) CheckResult {
+	for _, text := range texts {
+		for _, p := range markdownPatterns {
+			if m := p.FindString(text); m != "" {
+				return CheckResult{Name: "no_markdown", Detail: fmt.Sprintf("found %q", m)}
+			}
+		}
+	}
+	return CheckResult{Name: "no_markdown", Passed: true}
+}
+
+func plainShortCheck(short string) CheckResult {
+	if m := htmlTagPattern.FindString(short); m != "" {
+		return CheckResult{Name: "plain_short_response", Detail: fmt.Sprintf("found %q", m)}
+	}
+	return CheckResult{Name: "plain_short_response", Passed: true}
+}
+
+// linksCheck verifies that every link is an absolute http(s) URL with a
+// clean path that opens in a new tab, as the system prompt demands. It does
+// not request the links; reachableLinksCheck does.
+func linksCheck(long string) CheckResult {
+	links := answerLinks(long)
+	for _, link := range links {
+		u, err := url.Parse(link.href)
+		switch {
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			return CheckResult{Name: "valid_links", Detail: fmt.Sprintf("invalid url %q", link.href)}
+		case strings.Contains(u.Path, "//"):
+			return CheckResult{Name: "valid_links", Detail: fmt.Sprintf("double slash in %q", link.href)}
+		case link.target != "_blank":
+			return CheckResult{Name: "valid_links", Detail: fmt.Sprintf("%q does not open in a new tab", link.href)}
+		}
+	}
+	return CheckResult{Name: "valid_links", Passed: true, Detail: fmt.Sprintf("%d links", len(links))}
+}
+
+func emergencyCheck(text string) CheckResult {
+	if m := wrongEmergencyNumbers.FindString(text); m != "" {
+		return CheckResult{Name: "emergency_numbers", Detail: fmt.Sprintf("foreign emergency number %s", m)}
+	}
+	var missing []string
+	for _, n := range emergencyNumbers {
+		if !strings.Contains(text, n) {
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) > 0 {
+		return CheckResult{Name: "emergency_numbers", Detail: "missing " + strings.Join(missing, ", ")}
+	}
+	return CheckResult{Name: "emergency_numbers", Passed: true}
+}
+
+func mentionsEmergency(question string) bool {
+	q := strings.ToLower(question)
+	for _, k := range emergencyKeywords {
+		if strings.Contains(q, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkClient requests the links of answers in reachableLinksCheck.
+var linkClient = &http.Client{Timeout: 10 * time.Second}
+
+// reachableLinksCheck requests every http(s) link of an answer. A link that
+// cannot be reached or does not answer with a success status, such as a page
+// the model made up, fails the check.
+func reachableLinksCheck(ctx context.Context, client *http.Client, long string) CheckResult {
+	checked := map[string]bool{}
+	for _, link := range answerLinks(long) {
+		u, err := url.Parse(link.href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || checked[link.href] {
+			// linksCheck reports invalid links
+			continue
+		}
+		checked[link.href] = true
+		status, err := linkStatus(ctx, client, link.href)
+		if err != nil {
+			return CheckResult{Name: "reachable_links", Detail: fmt.Sprintf("%s: %v", link.href, err)}
+		}
+		if status >= 400 {
+			return CheckResult{Name: "reachable_links", Detail: fmt.Sprintf("%s: status %d", link.href, status)}
+		}
+	}
+	return CheckResult{Name: "reachable_links", Passed: true, Detail: fmt.Sprintf("%d links", len(checked))}
+}
+
+// linkStatus returns the status of a HEAD request, or of a GET request for
+// servers that do not support HEAD. Redirects are followed.
+func linkStatus(ctx context.Context, client *http.Client, href string) (int, error) {
+	status := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, href, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	return status, nil
+}
+
+type answerLink struct {
+	href   string
+	target string
+}
+
+// answerLinks returns the <a> elements of an HTML fragment.
+func answerLinks(fragment string) []answerLink {
+	var links []answerLink
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		token := z.Token()
+		if token.Data != "a" {
+			continue
+		}
+		var link answerLink
+		for _, attr := range token.Attr {
+			switch attr.Key {
+			case "href":
+				link.href = attr.Val
+			case "target":
+				link.target = attr.Val
+			}
+		}
+		links = append(links, link)
+	}
+}
+
+// htmlText returns the visible text of an HTML fragment.
+func htmlText(fragment string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			b.Write(z.Text())
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			b.WriteByte(' ')
+		}
+	}
+}
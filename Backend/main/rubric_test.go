@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testAnswer(long, short string) evalAnswer {
+	var a evalAnswer
+	a.Content.Longresponse = long
+	a.Content.Shortresponse = short
+	return a
+}
+
+// checkByName returns the named check, or ok == false if it did not run.
+func checkByName(checks []CheckResult, name string) (CheckResult, bool) {
+	for _, c := range checks {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return CheckResult{}, false
+}
+
+func TestScoreAnswer(t *testing.T) {
+	good := testAnswer(
+		`Penzije se isplaćuju od 10. u mjesecu.<br>Više na <a href="https://www.pio.ba/isplate" target="_blank">stranici PIO</a>.`,
+		"Penzije se isplaćuju od 10. u mjesecu.")
+
+	tests := []struct {
+		name   string
+		c      rubricCase
+		answer evalAnswer
+		check  string
+		passed bool
+		ran    bool
+	}{
+		{"good answer", rubricCase{Question: "Kada je penzija?"}, good, "valid_links", true, true},
+		{"within word limit", rubricCase{Question: "Kada je penzija?", AnswerLength: "short"}, good, "short_word_limit", true, true},
+		{"empty short response", rubricCase{}, testAnswer("Tekst.", ""), "short_word_limit", false, true},
+		{"over word limit", rubricCase{AnswerLength: "short"}, testAnswer("Tekst.", strings.Repeat("riječ ", 34)), "short_word_limit", false, true},
+		{"within slack", rubricCase{AnswerLength: "short"}, testAnswer("Tekst.", strings.Repeat("riječ ", 33)), "short_word_limit", true, true},
+		{"words in tags are not counted", rubricCase{AnswerLength: "short"}, testAnswer("<b>Jedan</b><br>dva", "Kratko."), "long_word_limit", true, true},
+		{"markdown bold", rubricCase{}, testAnswer("**Važno**: ponesite lične dokumente.", "Kratko."), "no_markdown", false, true},
+		{"markdown list", rubricCase{}, testAnswer("Ponesite:\n- ličnu kartu", "Kratko."), "no_markdown", false, true},
+		{"markdown link", rubricCase{}, testAnswer("Vidi [PIO](https://www.pio.ba)", "Kratko."), "no_markdown", false, true},
+		{"html in short response", rubricCase{}, testAnswer("Tekst.", "Nazovite <b>124</b>."), "plain_short_response", false, true},
+		{"relative link", rubricCase{}, testAnswer(`<a href="/isplate" target="_blank">PIO</a>`, "Kratko."), "valid_links", false, true},
+		{"double slash", rubricCase{}, testAnswer(`<a href="https://www.pio.ba//isplate" target="_blank">PIO</a>`, "Kratko."), "valid_links", false, true},
+		{"same tab link", rubricCase{}, testAnswer(`<a href="https://www.pio.ba">PIO</a>`, "Kratko."), "valid_links", false, true},
+		{"no emergency question", rubricCase{Question: "Kada je penzija?"}, good, "emergency_numbers", false, false},
+		{"emergency numbers given", rubricCase{Question: "Koji je broj hitne pomoći?"}, testAnswer("Policija 122, vatrogasci 123, hitna pomoć 124.", "Hitna pomoć: 124."), "emergency_numbers", true, true},
+		{"emergency number missing", rubricCase{Question: "Kako da pozovem policiju?"}, testAnswer("Policija 122.", "Policija: 122."), "emergency_numbers", false, true},
+		{"foreign emergency number", rubricCase{Emergency: true}, testAnswer("Nazovite 911, 122, 123 ili 124.", "Kratko."), "emergency_numbers", false, true},
+		{"phrase present", rubricCase{MustContain: []string{"10. U MJESECU"}}, good, "contains", true, true},
+		{"phrase missing", rubricCase{MustContain: []string{"PIO/MIO"}}, good, "contains", false, true},
+	}
+	for _, tt := range tests {
+		got, ran := checkByName(scoreAnswer(tt.c, tt.answer), tt.check)
+		if ran != tt.ran {
+			t.Errorf("%s: check %s ran = %v, want %v", tt.name, tt.check, ran, tt.ran)
+			continue
+		}
+		if ran && got.Passed != tt.passed {
+			t.Errorf("%s: %s passed = %v (%s), want %v", tt.name, tt.check, got.Passed, got.Detail, tt.passed)
+		}
+	}
+}
+
+func TestReachableLinksCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/isplate":
+		case r.URL.Path == "/stari-link":
+			http.Redirect(w, r, "/isplate", http.StatusMovedPermanently)
+		case r.URL.Path == "/bez-head" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/bez-head":
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	link := func(path string) string {
+		return `<a href="` + srv.URL + path + `" target="_blank">PIO</a>`
+	}
+
+	tests := []struct {
+		name   string
+		long   string
+		passed bool
+	}{
+		{"no links", "Tekst.", true},
+		{"reachable", link("/isplate") + link("/isplate"), true},
+		{"redirected", link("/stari-link"), true},
+		{"no HEAD support", link("/bez-head"), true},
+		{"made up page", link("/isplate") + link("/izmisljeno"), false},
+		{"invalid links are left to valid_links", `<a href="/isplate">PIO</a>`, true},
+		{"unreachable host", `<a href="http://127.0.0.1:1/isplate" target="_blank">PIO</a>`, false},
+	}
+	for _, tt := range tests {
+		got := reachableLinksCheck(context.Background(), srv.Client(), tt.long)
+		if got.Passed != tt.passed {
+			t.Errorf("%s: passed = %v (%s), want %v", tt.name, got.Passed, got.Detail, tt.passed)
+		}
+	}
+}
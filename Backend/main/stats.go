@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"code.com/chatgpt"
+)
+
+// KeyDayStat is the number of questions asked with one API key on one day.
+type KeyDayStat struct {
+	Day      string `json:"day"`
+	APIKey   string `json:"api_key"`
+	KeyName  string `json:"key_name"`
+	Requests int    `json:"requests"`
+}
+
+// Stats is the payload of the stats dashboard.
+type Stats struct {
+	Days []KeyDayStat `json:"days"`
+	Keys []APIKey     `json:"keys"`
+	// Models counts which models answered and how often the fallback chain was used.
+	Models chatgpt.FallbackMetrics `json:"models"`
+	// Costs is the spend of the last 30 days.
+	Costs CostReport `json:"costs"`
+	// Budget is set when spending limits are configured.
+	Budget *BudgetState `json:"budget,omitempty"`
+}
+
+// collectStats aggregates usage log entries per day and API key.
+func collectStats(entries []LogEntry, keys []APIKey) Stats {
+	names := map[string]string{"public": "Public frontend"}
+	for _, k := range keys {
+		names[k.ID] = k.Name
+	}
+
+	counts := map[[2]string]int{}
+	for _, entry := range entries {
+		if entry["msg"] != "Received text" {
+			continue
+		}
+		timestamp, _ := entry["time"].(string)
+		if len(timestamp) < 10 {
+			continue
+		}
+		key, _ := entry["api_key"].(string)
+		if key == "" {
+			key = "public"
+		}
+		counts[[2]string{timestamp[:10], key}]++
+	}
+
+	stats := Stats{Days: []KeyDayStat{}, Keys: keys}
+	for k, n := range counts {
+		stats.Days = append(stats.Days, KeyDayStat{
+			Day:      k[0],
+			APIKey:   k[1],
+			KeyName:  names[k[1]],
+			Requests: n,
+		})
+	}
+	sort.Slice(stats.Days, func(i, j int) bool {
+		if stats.Days[i].Day != stats.Days[j].Day {
+			return stats.Days[i].Day > stats.Days[j].Day
+		}
+		return stats.Days[i].APIKey < stats.Days[j].APIKey
+	})
+	return stats
+}
+
+func serveStatsData(w http.ResponseWriter, r *http.Request) {
+	clientIP := ClientIP(r)
+
+	logger.Info("Stats data request",
+		"ip", clientIP,
+		"path", r.URL.Path)
+
+	usageLogPath := cfg.logFile("usage.log")
+	entries, err := parseLogFile(usageLogPath)
+	if err != nil {
+		logger.Error("Failed to parse usage log",
+			"error", err,
+			"ip", clientIP,
+			"path", usageLogPath)
+		http.Error(w, "Error reading usage log", http.StatusInternalServerError)
+		return
+	}
+
+	keys := []APIKey{}
+	if keyStore != nil {
+		if keys, err = keyStore.List(); err != nil {
+			logger.Error("Failed to list api keys", "error", err, "ip", clientIP)
+			http.Error(w, "Error reading api keys", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	stats := collectStats(entries, keys)
+	stats.Models = chatgpt.Metrics()
+	stats.Costs = collectCosts(entries, time.Now().AddDate(0, 0, -30))
+	if budget != nil {
+		state := budget.State()
+		stats.Budget = &state
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func SendStats(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/data") {
+		serveStatsData(w, r)
+	} else {
+		http.ServeFile(w, r, "./templates/stats.html")
+	}
+}
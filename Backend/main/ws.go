@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.com/chatgpt"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection limits
+const (
+	wsMaxConnections  = 200
+	wsMaxMessageBytes = 16 << 10
+	wsMaxInFlight     = 2
+	wsMaxQuestions    = 50
+	wsMaxHistory      = 5
+	wsWriteWait       = 10 * time.Second
+)
+
+// Keepalive timing, variables so tests can shorten them
+var (
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 60 * time.Second
+)
+
+var wsConnections atomic.Int64
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// Origins of partner keys are checked after the handshake; the public
+	// endpoint accepts any origin just like ChatGPTHandler does.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsClientMessage is a message sent by the client.
+// Type is "ask" (with ID and Text) or "cancel" (with ID).
+type wsClientMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	// Language selects the answer language of the question.
+	Language string `json:"language,omitempty"`
+}
+
+// wsServerMessage is a message pushed to the client. Type is "progress",
+// "token", "answer", "error" or "cancelled".
+type wsServerMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Stage   string          `json:"stage,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Delta   string          `json:"delta,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// wsSession is the state of one open conversation.
+type wsSession struct {
+	conn     *websocket.Conn
+	clientIP string
+	key      *APIKey
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	inFlight map[string]context.CancelFunc
+	history  []chatgpt.Turn
+	asked    int
+}
+
+func (s *wsSession) send(msg wsServerMessage) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		logger.Warn("WebSocket write failed", "error", err, "ip", s.clientIP)
+	}
+}
+
+func (s *wsSession) ping() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+}
+
+// WebSocketHandler handles /v1/ws. A connection stays open for a whole
+// conversation; earlier answers are passed to the model as history.
+func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	clientIP := ClientIP(r)
+
+	// Browsers cannot set headers on WebSocket requests, so accept the key as a query parameter too
+	token := requestToken(r)
+	if token == "" {
+		token = r.URL.Query().Get("api_key")
+	}
+	var key *APIKey
+	if token != "" && keyStore != nil {
+		k, err := keyStore.Lookup(token)
+		if err == nil && !k.AllowsOrigin(r.Header.Get("Origin")) {
+			err = ErrOriginRejected
+		}
+		if err != nil {
+			logger.Warn("API key rejected", "error", err, "ip", clientIP)
+			http.Error(w, err.Error(), keyAuthStatus(err))
+			return
+		}
+		key = &k
+	}
+
+	if wsConnections.Add(1) > wsMaxConnections {
+		wsConnections.Add(-1)
+		logger.Warn("WebSocket connection limit reached", "ip", clientIP, "limit", wsMaxConnections)
+		http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer wsConnections.Add(-1)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("WebSocket upgrade failed", "error", err, "ip", clientIP)
+		return
+	}
+	defer conn.Close()
+
+	logger.Info("WebSocket connected",
+		"ip", clientIP,
+		"api_key", keyID(key),
+		"connections", wsConnections.Load())
+
+	s := &wsSession{
+		conn:     conn,
+		clientIP: clientIP,
+		key:      key,
+		inFlight: map[string]context.CancelFunc{},
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Keepalive: the client must answer our pings within wsPongWait
+	conn.SetReadLimit(wsMaxMessageBytes)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.ping(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var msg wsClientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Warn("WebSocket read failed", "error", err, "ip", clientIP)
+			}
+			break
+		}
+		switch msg.Type {
+		case "ask":
+			s.ask(ctx, msg)
+		case "cancel":
+			s.cancel(msg.ID)
+		default:
+			s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "unknown message type"})
+		}
+	}
+
+	logger.Info("WebSocket disconnected", "ip", clientIP, "questions", s.asked)
+}
+
+// ask starts answering a question in the background.
+func (s *wsSession) ask(ctx context.Context, msg wsClientMessage) {
+	if msg.ID == "" || strings.TrimSpace(msg.Text) == "" {
+		s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "id and text are required"})
+		return
+	}
+
+	s.mu.Lock()
+	if _, busy := s.inFlight[msg.ID]; busy {
+		s.mu.Unlock()
+		s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "question id already in use"})
+		return
+	}
+	if len(s.inFlight) >= wsMaxInFlight {
+		s.mu.Unlock()
+		s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "too many questions in progress"})
+		return
+	}
+	if s.asked >= wsMaxQuestions {
+		s.mu.Unlock()
+		s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: "question limit for this connection reached"})
+		return
+	}
+	s.asked++
+	qctx, cancel := context.WithCancel(ctx)
+	s.inFlight[msg.ID] = cancel
+	history := append([]chatgpt.Turn(nil), s.history...)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.finish(msg.ID)
+
+		opts := chatgpt.Options{}
+		if s.key != nil {
+			opts = s.key.Options()
+		}
+		opts, err := withLanguage(opts, msg.Language)
+		if err != nil {
+			s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: err.Error()})
+			return
+		}
+		opts.History = history
+		opts.OnEvent = func(e chatgpt.Event) {
+			s.send(wsServerMessage{Type: e.Type, ID: msg.ID, Stage: e.Stage, Message: e.Message, Delta: e.Delta})
+		}
+
+		apiKey, err := loadOpenAIKey(s.clientIP)
+		if err != nil {
+			s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: err.Error()})
+			return
+		}
+		prompt, err := promptFromText(msg.Text)
+		if err != nil {
+			s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: err.Error()})
+			return
+		}
+		// Each valid question counts against the key's quota
+		if err := countRequest(s.key); err != nil {
+			s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: err.Error()})
+			return
+		}
+
+		requestdata.Info("Received text", "text", msg.Text, "ip", s.clientIP, "api_key", keyID(s.key), "transport", "websocket")
+		opts = withModeration(opts, apiKey, s.clientIP, keyID(s.key))
+		resultingText, used := answerQuestion(qctx, msg.Text, prompt, apiKey, keyID(s.key), opts)
+		resultingText = saveAnswer(resultingText)
+		requestdata.Info("Resulting text", "text", resultingText, "ip", s.clientIP, "api_key", keyID(s.key), "transport", "websocket", "language", answerLanguage(resultingText, msg.Text, opts), "total_tokens", used.TotalTokens, "cost_usd", used.CostUSD)
+
+		if qctx.Err() != nil {
+			s.send(wsServerMessage{Type: "cancelled", ID: msg.ID})
+			return
+		}
+		var answer struct {
+			Content struct {
+				Shortresponse string `json:"shortresponse"`
+			} `json:"content"`
+		}
+		if err := json.Unmarshal([]byte(resultingText), &answer); err != nil {
+			logger.Error("Question could not be answered", "error", resultingText, "ip", s.clientIP, "transport", "websocket")
+			s.send(wsServerMessage{Type: "error", ID: msg.ID, Error: answerFailedMessage})
+			return
+		}
+
+		s.mu.Lock()
+		s.history = append(s.history, chatgpt.Turn{Question: msg.Text, Answer: answer.Content.Shortresponse})
+		if len(s.history) > wsMaxHistory {
+			s.history = s.history[len(s.history)-wsMaxHistory:]
+		}
+		s.mu.Unlock()
+
+		s.send(wsServerMessage{Type: "answer", ID: msg.ID, Result: json.RawMessage(resultingText)})
+	}()
+}
+
+// cancel stops a question that is still being answered.
+func (s *wsSession) cancel(id string) {
+	s.mu.Lock()
+	cancel, ok := s.inFlight[id]
+	s.mu.Unlock()
+	if !ok {
+		s.send(wsServerMessage{Type: "error", ID: id, Error: "no such question in progress"})
+		return
+	}
+	logger.Info("WebSocket question cancelled", "ip", s.clientIP, "question_id", id)
+	cancel()
+}
+
+func (s *wsSession) finish(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.inFlight[id]; ok {
+		cancel()
+		delete(s.inFlight, id)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.com/chatgpt"
+	"github.com/gorilla/websocket"
+)
+
+// newTestWebSocket serves WebSocketHandler with analyse in place of the
+// pipeline and returns a connected client.
+func newTestWebSocket(t *testing.T, analyse func(ctx context.Context, prompt, apikey string, opts chatgpt.Options) (string, chatgpt.Usage)) *websocket.Conn {
+	t.Helper()
+	withTestEnv(t)
+	analyseQuestion = analyse
+	srv := httptest.NewServer(http.HandlerFunc(WebSocketHandler))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		waitForDisconnect(t)
+		srv.Close()
+		analyseQuestion = chatgpt.ChatGPTAnalyseUsage
+	})
+	return conn
+}
+
+// waitForDisconnect waits until every WebSocket handler has returned.
+func waitForDisconnect(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for wsConnections.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("WebSocket handler did not return")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readUntil reads server messages until one of the given type arrives.
+func readUntil(t *testing.T, conn *websocket.Conn, typ string) wsServerMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg wsServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for %q: %v", typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg wsClientMessage) {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebSocketAnswersWithHistory(t *testing.T) {
+	histories := make(chan []chatgpt.Turn, 2)
+	conn := newTestWebSocket(t, func(ctx context.Context, prompt, apikey string, opts chatgpt.Options) (string, chatgpt.Usage) {
+		histories <- opts.History
+		opts.OnEvent(chatgpt.Event{Type: "progress", Stage: "search"})
+		return `{"content":{"shortresponse":"Penzije se isplaćuju od 10. u mjesecu."}}`, chatgpt.Usage{}
+	})
+
+	send(t, conn, wsClientMessage{Type: "ask", ID: "1", Text: "Kada je penzija?"})
+	if msg := readUntil(t, conn, "progress"); msg.ID != "1" || msg.Stage != "search" {
+		t.Errorf("progress = %+v", msg)
+	}
+	answer := readUntil(t, conn, "answer")
+	if answer.ID != "1" || !strings.Contains(string(answer.Result), "10. u mjesecu") {
+		t.Errorf("answer = %+v", answer)
+	}
+	if h := <-histories; len(h) != 0 {
+		t.Errorf("first question history = %+v", h)
+	}
+
+	send(t, conn, wsClientMessage{Type: "ask", ID: "2", Text: "A za decembar?"})
+	readUntil(t, conn, "answer")
+	want := chatgpt.Turn{Question: "Kada je penzija?", Answer: "Penzije se isplaćuju od 10. u mjesecu."}
+	if h := <-histories; len(h) != 1 || h[0] != want {
+		t.Errorf("second question history = %+v, want [%+v]", h, want)
+	}
+}
+
+func TestWebSocketHidesPipelineErrors(t *testing.T) {
+	conn := newTestWebSocket(t, func(ctx context.Context, prompt, apikey string, opts chatgpt.Options) (string, chatgpt.Usage) {
+		return "An error occurred: 401 Unauthorized sk-proj-123", chatgpt.Usage{}
+	})
+
+	send(t, conn, wsClientMessage{Type: "ask", ID: "1", Text: "Kada je penzija?"})
+	if msg := readUntil(t, conn, "error"); msg.ID != "1" || msg.Error != answerFailedMessage {
+		t.Errorf("error = %+v, want %q", msg, answerFailedMessage)
+	}
+}
+
+func TestWebSocketCancel(t *testing.T) {
+	started := make(chan struct{})
+	conn := newTestWebSocket(t, func(ctx context.Context, prompt, apikey string, opts chatgpt.Options) (string, chatgpt.Usage) {
+		close(started)
+		<-ctx.Done()
+		return "An error occurred: " + ctx.Err().Error(), chatgpt.Usage{}
+	})
+
+	send(t, conn, wsClientMessage{Type: "ask", ID: "1", Text: "Kada je penzija?"})
+	<-started
+	send(t, conn, wsClientMessage{Type: "cancel", ID: "1"})
+	if msg := readUntil(t, conn, "cancelled"); msg.ID != "1" {
+		t.Errorf("cancelled = %+v", msg)
+	}
+
+	send(t, conn, wsClientMessage{Type: "cancel", ID: "1"})
+	if msg := readUntil(t, conn, "error"); msg.Error != "no such question in progress" {
+		t.Errorf("cancelling a finished question: %+v", msg)
+	}
+}
+
+func TestWebSocketLimitsQuestionsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	conn := newTestWebSocket(t, func(ctx context.Context, prompt, apikey string, opts chatgpt.Options) (string, chatgpt.Usage) {
+		<-release
+		return `{"content":{"shortresponse":"Da."}}`, chatgpt.Usage{}
+	})
+
+	for i := 0; i < wsMaxInFlight; i++ {
+		send(t, conn, wsClientMessage{Type: "ask", ID: string(rune('a' + i)), Text: "Pitanje"})
+	}
+	send(t, conn, wsClientMessage{Type: "ask", ID: "z", Text: "Pitanje"})
+	if msg := readUntil(t, conn, "error"); msg.ID != "z" || msg.Error != "too many questions in progress" {
+		t.Errorf("error = %+v", msg)
+	}
+
+	close(release)
+	for i := 0; i < wsMaxInFlight; i++ {
+		readUntil(t, conn, "answer")
+	}
+	send(t, conn, wsClientMessage{Type: "ask", ID: "z", Text: "Pitanje"})
+	if msg := readUntil(t, conn, "answer"); msg.ID != "z" {
+		t.Errorf("answer after the others finished = %+v", msg)
+	}
+}
+
+func TestWebSocketPingTimeout(t *testing.T) {
+	pingInterval, pongWait := wsPingInterval, wsPongWait
+	wsPingInterval, wsPongWait = 20*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() { wsPingInterval, wsPongWait = pingInterval, pongWait })
+
+	analyse := func(ctx context.Context, prompt, apikey string, opts chatgpt.Options) (string, chatgpt.Usage) {
+		return `{"content":{"shortresponse":"Da."}}`, chatgpt.Usage{}
+	}
+
+	// A client that keeps reading answers pings and stays connected
+	conn := newTestWebSocket(t, analyse)
+	deadline := time.Now().Add(3 * wsPongWait)
+	conn.SetReadDeadline(deadline)
+	if _, _, err := conn.ReadMessage(); err == nil || time.Now().Before(deadline) {
+		t.Fatalf("connection of a responsive client closed early: %v", err)
+	}
+
+	// A client that stops reading never answers pings and is disconnected
+	silent := newTestWebSocket(t, analyse)
+	time.Sleep(3 * wsPongWait)
+	silent.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := silent.ReadMessage(); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Errorf("silent client: err = %v, want the connection closed by the server", err)
+	}
+}
@@ -0,0 +1,228 @@
+package speech
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported audio format, use webm, ogg or wav")
+	ErrUnknownDuration   = errors.New("could not determine audio duration")
+)
+
+// DetectFormat returns "wav", "ogg" or "webm" based on the file's magic bytes.
+func DetectFormat(data []byte) (string, error) {
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return "wav", nil
+	case len(data) >= 4 && string(data[0:4]) == "OggS":
+		return "ogg", nil
+	case len(data) >= 4 && bytes.Equal(data[0:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "webm", nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Duration returns the playing time of a recording in the given format.
+func Duration(data []byte, format string) (time.Duration, error) {
+	switch format {
+	case "wav":
+		return wavDuration(data)
+	case "ogg":
+		return oggDuration(data)
+	case "webm":
+		return webmDuration(data)
+	}
+	return 0, ErrUnsupportedFormat
+}
+
+func wavDuration(data []byte) (time.Duration, error) {
+	var byteRate uint32
+	pos := 12
+	for pos+8 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+		switch id {
+		case "fmt ":
+			if body+12 > len(data) {
+				return 0, ErrUnknownDuration
+			}
+			byteRate = binary.LittleEndian.Uint32(data[body+8 : body+12])
+		case "data":
+			if byteRate == 0 {
+				return 0, ErrUnknownDuration
+			}
+			// Streamed recordings may not know the data size up front
+			if size <= 0 || body+size > len(data) {
+				size = len(data) - body
+			}
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second)), nil
+		}
+		pos = body + size + size%2
+	}
+	return 0, ErrUnknownDuration
+}
+
+func oggDuration(data []byte) (time.Duration, error) {
+	var (
+		rate     uint32
+		preSkip  uint64
+		granule  int64
+		pos      int
+		firstPkt = true
+	)
+	for pos+27 <= len(data) && string(data[pos:pos+4]) == "OggS" {
+		pageGranule := int64(binary.LittleEndian.Uint64(data[pos+6 : pos+14]))
+		segments := int(data[pos+26])
+		if pos+27+segments > len(data) {
+			break
+		}
+		bodyLen := 0
+		for _, l := range data[pos+27 : pos+27+segments] {
+			bodyLen += int(l)
+		}
+		body := pos + 27 + segments
+		if body+bodyLen > len(data) {
+			bodyLen = len(data) - body
+		}
+		packet := data[body : body+bodyLen]
+
+		if firstPkt {
+			firstPkt = false
+			switch {
+			case len(packet) >= 12 && string(packet[0:8]) == "OpusHead":
+				rate = 48000
+				preSkip = uint64(binary.LittleEndian.Uint16(packet[10:12]))
+			case len(packet) >= 16 && string(packet[1:7]) == "vorbis":
+				rate = binary.LittleEndian.Uint32(packet[12:16])
+			}
+		}
+		if pageGranule > granule {
+			granule = pageGranule
+		}
+		pos = body + bodyLen
+	}
+	if rate == 0 || granule <= 0 {
+		return 0, ErrUnknownDuration
+	}
+	samples := uint64(granule)
+	if samples > preSkip {
+		samples -= preSkip
+	}
+	return time.Duration(float64(samples) / float64(rate) * float64(time.Second)), nil
+}
+
+// Matroska/WebM element IDs used to find the duration
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlCluster       = 0x1F43B675
+	ebmlTimecode      = 0xE7
+	ebmlBlockGroup    = 0xA0
+	ebmlBlock         = 0xA1
+	ebmlSimpleBlock   = 0xA3
+)
+
+// readVint reads an EBML variable length integer. For IDs the length marker is
+// kept; for sizes it is removed and an all-ones value reports an unknown size.
+func readVint(data []byte, keepMarker bool) (value uint64, n int, unknown bool) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false
+	}
+	n = 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || n > len(data) {
+		return 0, 0, false
+	}
+	value = uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> n)
+	}
+	allOnes := value == uint64(0xFF>>n)
+	for _, b := range data[1:n] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	return value, n, allOnes && !keepMarker
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// webmDuration reads the Duration element of the segment info, or falls back
+// to the timecode of the last block. Browser recordings made with
+// MediaRecorder often have no duration and clusters of unknown size, so the
+// file is scanned linearly instead of as a tree.
+func webmDuration(data []byte) (time.Duration, error) {
+	var (
+		scale       uint64 = 1000000
+		declared    float64
+		clusterTime uint64
+		lastTime    uint64
+	)
+	pos := 0
+	for pos < len(data) {
+		id, idLen, _ := readVint(data[pos:], true)
+		if idLen == 0 {
+			break
+		}
+		size, sizeLen, unknown := readVint(data[pos+idLen:], false)
+		if sizeLen == 0 {
+			break
+		}
+		body := pos + idLen + sizeLen
+
+		switch id {
+		case ebmlSegment, ebmlInfo, ebmlCluster, ebmlBlockGroup:
+			// Descend into master elements
+			pos = body
+			continue
+		}
+		if unknown || body+int(size) > len(data) {
+			break
+		}
+		value := data[body : body+int(size)]
+		switch id {
+		case ebmlTimecodeScale:
+			scale = readUint(value)
+		case ebmlDuration:
+			if len(value) == 4 {
+				declared = float64(math.Float32frombits(binary.BigEndian.Uint32(value)))
+			} else if len(value) == 8 {
+				declared = math.Float64frombits(binary.BigEndian.Uint64(value))
+			}
+		case ebmlTimecode:
+			clusterTime = readUint(value)
+		case ebmlSimpleBlock, ebmlBlock:
+			_, trackLen, _ := readVint(value, false)
+			if trackLen > 0 && trackLen+2 <= len(value) {
+				rel := int16(binary.BigEndian.Uint16(value[trackLen : trackLen+2]))
+				if t := int64(clusterTime) + int64(rel); t > int64(lastTime) {
+					lastTime = uint64(t)
+				}
+			}
+		}
+		pos = body + int(size)
+	}
+
+	if declared > 0 {
+		return time.Duration(declared * float64(scale)), nil
+	}
+	if lastTime > 0 {
+		return time.Duration(lastTime * scale), nil
+	}
+	return 0, ErrUnknownDuration
+}
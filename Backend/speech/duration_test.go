@@ -0,0 +1,175 @@
+package speech
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// wavFixture builds a 16 kHz mono PCM recording of the given length.
+func wavFixture(seconds int) []byte {
+	const byteRate = 32000
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+seconds*byteRate))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(16000), uint32(byteRate), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(seconds*byteRate))
+	b.Write(make([]byte, seconds*byteRate))
+	return b.Bytes()
+}
+
+// oggPage builds an Ogg page holding a single packet.
+func oggPage(granule uint64, packet []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("OggS")
+	b.Write([]byte{0, 0})
+	binary.Write(&b, binary.LittleEndian, granule)
+	b.Write(make([]byte, 12)) // serial, sequence and checksum
+	var segments []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	b.WriteByte(byte(len(segments)))
+	b.Write(segments)
+	b.Write(packet)
+	return b.Bytes()
+}
+
+// oggOpusFixture builds an Opus stream of the given length with a pre-skip of 312 samples.
+func oggOpusFixture(seconds int) []byte {
+	head := []byte("OpusHead")
+	head = append(head, 1, 1)
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = binary.LittleEndian.AppendUint32(head, 16000)
+	head = append(head, 0, 0, 0)
+	data := oggPage(0, head)
+	data = append(data, oggPage(0, []byte("OpusTags"))...)
+	data = append(data, oggPage(uint64(seconds*48000/2+312), make([]byte, 300))...)
+	return append(data, oggPage(uint64(seconds*48000+312), make([]byte, 300))...)
+}
+
+// oggVorbisFixture builds a 44.1 kHz Vorbis stream of the given length.
+func oggVorbisFixture(seconds int) []byte {
+	head := append([]byte{1}, "vorbis"...)
+	head = binary.LittleEndian.AppendUint32(head, 0)
+	head = append(head, 1)
+	head = binary.LittleEndian.AppendUint32(head, 44100)
+	head = append(head, make([]byte, 14)...)
+	data := oggPage(0, head)
+	return append(data, oggPage(uint64(seconds*44100), make([]byte, 100))...)
+}
+
+// ebml encodes an element with an 8 byte size, or an unknown size when body is nil.
+func ebml(id uint32, body ...[]byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	content := bytes.Join(body, nil)
+	if body == nil {
+		b = append(b, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	} else {
+		b = append(b, 0x01)
+		b = append(b, binary.BigEndian.AppendUint64(nil, uint64(len(content)))[1:]...)
+	}
+	return append(b, content...)
+}
+
+// simpleBlock builds a SimpleBlock for track 1 at a timecode relative to its cluster.
+func simpleBlock(rel int16) []byte {
+	return ebml(ebmlSimpleBlock, []byte{0x81}, binary.BigEndian.AppendUint16(nil, uint16(rel)), []byte{0x80}, make([]byte, 20))
+}
+
+// webmFixture builds a WebM file whose segment info declares its duration in milliseconds.
+func webmFixture(milliseconds float64) []byte {
+	header := ebml(0x1A45DFA3, ebml(0x4282, []byte("webm")))
+	info := ebml(ebmlInfo,
+		ebml(ebmlTimecodeScale, []byte{0x0F, 0x42, 0x40}),
+		ebml(ebmlDuration, binary.BigEndian.AppendUint64(nil, math.Float64bits(milliseconds))))
+	cluster := ebml(ebmlCluster, ebml(ebmlTimecode, []byte{0}), simpleBlock(0))
+	return append(header, ebml(ebmlSegment, info, cluster)...)
+}
+
+// mediaRecorderFixture builds a WebM file as browsers record it: no declared
+// duration and segment and clusters of unknown size.
+func mediaRecorderFixture() []byte {
+	header := ebml(0x1A45DFA3, ebml(0x4282, []byte("webm")))
+	data := append(header, ebml(ebmlSegment)...)
+	data = append(data, ebml(ebmlInfo, ebml(ebmlTimecodeScale, []byte{0x0F, 0x42, 0x40}))...)
+	data = append(data, ebml(ebmlCluster)...)
+	data = append(data, ebml(ebmlTimecode, []byte{0})...)
+	data = append(data, simpleBlock(0)...)
+	data = append(data, simpleBlock(800)...)
+	data = append(data, ebml(ebmlCluster)...)
+	data = append(data, ebml(ebmlTimecode, []byte{0x03, 0xE8})...)
+	data = append(data, simpleBlock(0)...)
+	return append(data, simpleBlock(2000)...)
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+		want   time.Duration
+		err    error
+	}{
+		{name: "wav", data: wavFixture(3), format: "wav", want: 3 * time.Second},
+		{name: "streamed wav without a data size", data: func() []byte {
+			data := wavFixture(2)
+			binary.LittleEndian.PutUint32(data[40:44], 0xFFFFFFFF)
+			return data
+		}(), format: "wav", want: 2 * time.Second},
+		{name: "ogg opus", data: oggOpusFixture(2), format: "ogg", want: 2 * time.Second},
+		{name: "ogg vorbis", data: oggVorbisFixture(5), format: "ogg", want: 5 * time.Second},
+		{name: "webm with a declared duration", data: webmFixture(4500), format: "webm", want: 4500 * time.Millisecond},
+		{name: "webm from MediaRecorder", data: mediaRecorderFixture(), format: "webm", want: 3 * time.Second},
+		{name: "wav without a data chunk", data: wavFixture(1)[:36], format: "wav", err: ErrUnknownDuration},
+		{name: "ogg with an unknown codec", data: oggPage(48000, []byte("FLAC header")), format: "ogg", err: ErrUnknownDuration},
+		{name: "webm without blocks", data: ebml(0x1A45DFA3, ebml(0x4282, []byte("webm"))), format: "webm", err: ErrUnknownDuration},
+		{name: "unsupported format", data: []byte("ID3"), format: "mp3", err: ErrUnsupportedFormat},
+	}
+	for _, tt := range tests {
+		got, err := Duration(tt.data, tt.format)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: duration = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string][]byte{
+		"wav":  wavFixture(1),
+		"ogg":  oggOpusFixture(1),
+		"webm": webmFixture(1000),
+	}
+	for want, data := range tests {
+		if got, err := DetectFormat(data); err != nil || got != want {
+			t.Errorf("DetectFormat = %q, %v, want %s", got, err, want)
+		}
+	}
+	if _, err := DetectFormat([]byte("ID3\x04")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("mp3: err = %v, want ErrUnsupportedFormat", err)
+	}
+}
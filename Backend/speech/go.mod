@@ -0,0 +1,15 @@
+module code.com/speech
+
+go 1.23.4
+
+require (
+	github.com/openai/openai-go v0.1.0-alpha.41
+	golang.org/x/net v0.33.0
+)
+
+require (
+	github.com/tidwall/gjson v1.14.4 // indirect
+	github.com/tidwall/match v1.1.1 // indirect
+	github.com/tidwall/pretty v1.2.1 // indirect
+	github.com/tidwall/sjson v1.2.5 // indirect
+)
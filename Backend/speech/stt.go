@@ -0,0 +1,83 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Transcriber turns a spoken question into text.
+type Transcriber interface {
+	// Transcribe returns the text of a recording in the given format
+	// ("wav", "ogg" or "webm"). language is an ISO-639-1 hint and may be empty.
+	Transcribe(ctx context.Context, audio []byte, format, language string) (string, error)
+}
+
+// OpenAITranscriber uses the OpenAI transcription API (Whisper).
+type OpenAITranscriber struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAITranscriber creates a transcriber for the given model, whisper-1 by default.
+func NewOpenAITranscriber(apiKey, model string) *OpenAITranscriber {
+	if model == "" {
+		model = openai.AudioModelWhisper1
+	}
+	return &OpenAITranscriber{
+		client: openai.NewClient(option.WithAPIKey(apiKey)),
+		model:  model,
+	}
+}
+
+// namedReader gives the multipart upload a file name, which the API uses to detect the format.
+type namedReader struct {
+	*bytes.Reader
+	name string
+}
+
+func (r namedReader) Name() string { return r.name }
+
+func (t *OpenAITranscriber) Transcribe(ctx context.Context, audio []byte, format, language string) (string, error) {
+	params := openai.AudioTranscriptionNewParams{
+		File:  openai.F[io.Reader](namedReader{Reader: bytes.NewReader(audio), name: "question." + format}),
+		Model: openai.F(t.model),
+	}
+	if language != "" {
+		params.Language = openai.F(language)
+	}
+	res, err := t.client.Audio.Transcriptions.New(ctx, params)
+	if err != nil {
+		return "", fmt.Errorf("transcription request failed: %v", err)
+	}
+	return strings.TrimSpace(res.Text), nil
+}
+
+// StubTranscriber returns a fixed transcript. It is meant for local development and tests.
+type StubTranscriber struct {
+	Text string
+}
+
+func (t StubTranscriber) Transcribe(ctx context.Context, audio []byte, format, language string) (string, error) {
+	if t.Text != "" {
+		return t.Text, nil
+	}
+	return "Koji je broj hitne pomoći?", nil
+}
+
+// NewTranscriber returns the transcriber for a provider name ("openai" or "stub").
+func NewTranscriber(provider, apiKey, model string) (Transcriber, error) {
+	switch provider {
+	case "", "openai":
+		return NewOpenAITranscriber(apiKey, model), nil
+	case "stub":
+		return StubTranscriber{}, nil
+	default:
+		return nil, fmt.Errorf("unknown speech-to-text provider %q", provider)
+	}
+}
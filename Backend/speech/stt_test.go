@@ -0,0 +1,72 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+func TestOpenAITranscriberUploads(t *testing.T) {
+	var filename, language, model string
+	var upload []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("reading the upload: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		upload, _ = io.ReadAll(file)
+		filename, language, model = header.Filename, r.FormValue("language"), r.FormValue("model")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"text":" Koji je broj hitne pomoći? "}`)
+	}))
+	defer srv.Close()
+
+	transcriber := &OpenAITranscriber{
+		client: openai.NewClient(option.WithAPIKey("test"), option.WithBaseURL(srv.URL+"/"), option.WithMaxRetries(0)),
+		model:  openai.AudioModelWhisper1,
+	}
+	fixtures := map[string][]byte{
+		"wav":  wavFixture(1),
+		"ogg":  oggOpusFixture(1),
+		"webm": mediaRecorderFixture(),
+	}
+	for format, audio := range fixtures {
+		text, err := transcriber.Transcribe(context.Background(), audio, format, "bs")
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if text != "Koji je broj hitne pomoći?" {
+			t.Errorf("%s: transcript %q", format, text)
+		}
+		if filename != "question."+format || !bytes.Equal(upload, audio) {
+			t.Errorf("%s: uploaded %s with %d bytes, want question.%s with %d", format, filename, len(upload), format, len(audio))
+		}
+		if language != "bs" || model != "whisper-1" {
+			t.Errorf("%s: language %q, model %q", format, language, model)
+		}
+	}
+}
+
+func TestNewTranscriber(t *testing.T) {
+	for _, provider := range []string{"", "openai", "stub"} {
+		if _, err := NewTranscriber(provider, "test", ""); err != nil {
+			t.Errorf("provider %q: %v", provider, err)
+		}
+	}
+	if _, err := NewTranscriber("google", "test", ""); err == nil {
+		t.Error("an unknown provider was accepted")
+	}
+	text, err := StubTranscriber{}.Transcribe(context.Background(), wavFixture(1), "wav", "")
+	if err != nil || text == "" {
+		t.Errorf("stub transcript = %q, %v", text, err)
+	}
+}
@@ -0,0 +1,144 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"golang.org/x/net/html"
+)
+
+// Synthesizer turns text into spoken audio.
+type Synthesizer interface {
+	// Synthesize returns the audio for text and its MIME type.
+	Synthesize(ctx context.Context, text string) ([]byte, string, error)
+	// Extension is the file extension (without dot) of the produced audio.
+	Extension() string
+}
+
+// OpenAISynthesizer uses the OpenAI text-to-speech API.
+type OpenAISynthesizer struct {
+	client *openai.Client
+	model  string
+	voice  string
+}
+
+// NewOpenAISynthesizer creates a synthesizer for the given model and voice.
+// Empty values fall back to tts-1 and the "nova" voice.
+func NewOpenAISynthesizer(apiKey, model, voice string) *OpenAISynthesizer {
+	if model == "" {
+		model = openai.SpeechModelTTS1
+	}
+	if voice == "" {
+		voice = string(openai.AudioSpeechNewParamsVoiceNova)
+	}
+	return &OpenAISynthesizer{
+		client: openai.NewClient(option.WithAPIKey(apiKey)),
+		model:  model,
+		voice:  voice,
+	}
+}
+
+func (s *OpenAISynthesizer) Synthesize(ctx context.Context, text string) ([]byte, string, error) {
+	resp, err := s.client.Audio.Speech.New(ctx, openai.AudioSpeechNewParams{
+		Model:          openai.F(s.model),
+		Voice:          openai.F(openai.AudioSpeechNewParamsVoice(s.voice)),
+		Input:          openai.F(text),
+		ResponseFormat: openai.F(openai.AudioSpeechNewParamsResponseFormatMP3),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("text-to-speech request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	audio, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading text-to-speech audio: %v", err)
+	}
+	return audio, "audio/mpeg", nil
+}
+
+func (s *OpenAISynthesizer) Extension() string { return "mp3" }
+
+// StubSynthesizer produces silent WAV audio whose length grows with the text.
+// It is meant for local development and tests.
+type StubSynthesizer struct{}
+
+func (StubSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, string, error) {
+	const sampleRate = 8000
+	// Roughly 60 ms of silence per character, capped at one minute
+	samples := len(text) * sampleRate * 60 / 1000
+	if samples > sampleRate*60 {
+		samples = sampleRate * 60
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+samples))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))         // fmt chunk size
+	binary.Write(&buf, binary.LittleEndian, uint16(1))          // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1))          // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate)) // sample rate
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate)) // byte rate
+	binary.Write(&buf, binary.LittleEndian, uint16(1))          // block align
+	binary.Write(&buf, binary.LittleEndian, uint16(8))          // bits per sample
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(samples))
+	buf.Write(bytes.Repeat([]byte{128}, samples))
+	return buf.Bytes(), "audio/wav", nil
+}
+
+func (StubSynthesizer) Extension() string { return "wav" }
+
+// NewSynthesizer returns the synthesizer for a provider name ("openai" or "stub").
+func NewSynthesizer(provider, apiKey, model, voice string) (Synthesizer, error) {
+	switch provider {
+	case "", "openai":
+		return NewOpenAISynthesizer(apiKey, model, voice), nil
+	case "stub":
+		return StubSynthesizer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown text-to-speech provider %q", provider)
+	}
+}
+
+// PlainText converts the HTML of a longresponse into text suitable for
+// reading aloud: tags are dropped, <br> and block elements become line breaks.
+func PlainText(fragment string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(collapseSpaces(b.String()))
+		case html.TextToken:
+			// Text already unescapes entities
+			b.Write(tokenizer.Text())
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "br", "p", "li", "div", "h1", "h2", "h3", "h4", "h5", "h6":
+				b.WriteString("\n")
+			}
+		}
+	}
+}
+
+// collapseSpaces removes repeated blanks and empty lines.
+func collapseSpaces(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
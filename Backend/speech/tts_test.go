@@ -0,0 +1,23 @@
+package speech
+
+import "testing"
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"Dobar dan", "Dobar dan"},
+		{"Prvi red<br>Drugi red", "Prvi red\nDrugi red"},
+		{"<p>Nazovite <b>hitnu pomoć</b> na broj 124.</p><p>Ostanite mirni.</p>", "Nazovite hitnu pomoć na broj 124.\nOstanite mirni."},
+		{"<ul><li>Lijekovi</li><li>Penzija</li></ul>", "Lijekovi\nPenzija"},
+		{"Cijena: 5 KM &amp; PDV", "Cijena: 5 KM & PDV"},
+		{"Znak &amp;lt; ostaje napisan", "Znak &lt; ostaje napisan"},
+		{"  Previše   razmaka <br><br>  ", "Previše razmaka"},
+	}
+	for _, tt := range tests {
+		if got := PlainText(tt.html); got != tt.want {
+			t.Errorf("PlainText(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
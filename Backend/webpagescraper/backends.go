@@ -0,0 +1,108 @@
+package webpagescraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// Fetcher downloads a web page.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+// Searcher returns the URLs of up to count results for query.
+type Searcher interface {
+	Search(ctx context.Context, query string, count int) ([]string, error)
+}
+
+// DefaultFetcher is the Fetcher used by WebpageAnalyse and GoogleSearch.
+var DefaultFetcher Fetcher = PageFetcher
+
+// HTTPFetcher fetches pages over HTTP. A nil Client means http.DefaultClient.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func (f HTTPFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, MaxPageBytes))
+}
+
+// redditURL matches results that are skipped because they rarely scrape well.
+var redditURL = regexp.MustCompile(`^https:\/\/(?:old\.)?reddit\.com.*$`)
+
+// SearXNG searches through the JSON API of a SearXNG instance.
+type SearXNG struct {
+	BaseURL string
+	// Client is used for the search request. Nil means http.DefaultClient.
+	Client *http.Client
+}
+
+func (s SearXNG) Search(ctx context.Context, query string, count int) ([]string, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	searchURL := s.BaseURL + "/search?q=" + url.QueryEscape(query) + "&format=json&safesearch=1"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, searchURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		Results []struct {
+			ParsedURL []string `json:"parsed_url"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("invalid search response (status %d): %v", resp.StatusCode, err)
+	}
+
+	var urls []string
+	for _, result := range data.Results {
+		if len(urls) >= count {
+			break
+		}
+		fullURL := ""
+		for i, part := range result.ParsedURL {
+			if i == 0 {
+				fullURL += part + "://"
+			} else if i == 1 {
+				fullURL += part + "/"
+			} else {
+				fullURL += part
+			}
+		}
+		if fullURL == "" || redditURL.MatchString(fullURL) {
+			continue
+		}
+		urls = append(urls, fullURL)
+	}
+	return urls, nil
+}
@@ -0,0 +1,183 @@
+package webpagescraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxPageBytes caps how much of a page HTTPFetcher downloads.
+const MaxPageBytes = 2 << 20
+
+// FetchTokenLimit caps the tokens of a page returned by FetchPage. It is
+// well below the 70000 tokens GoogleSearch allows for all of its results
+// together, since the model may fetch several pages in one answer.
+const FetchTokenLimit = 20000
+
+// MaxRedirects is the number of redirects PageClient follows.
+const MaxRedirects = 5
+
+// ErrInvalidURL is returned by FetchPage for URLs it will not open.
+var ErrInvalidURL = errors.New("invalid url")
+
+// nonPublic are ranges that pass IsGlobalUnicast and IsPrivate but are not
+// public hosts, such as carrier-grade NAT and NAT64.
+var nonPublic = []*net.IPNet{
+	mustCIDR("100.64.0.0/10"),
+	mustCIDR("192.0.0.0/24"),
+	mustCIDR("198.18.0.0/15"),
+	mustCIDR("2001:db8::/32"),
+	mustCIDR("64:ff9b::/96"),
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// publicIP reports whether ip is a public unicast address.
+func publicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, n := range nonPublic {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// PageClient is the HTTP client for pages named by users, the model or
+// search results. It checks the address a host name resolves to before
+// connecting and validates every redirect, so neither DNS nor a redirect
+// can lead it to an internal service.
+var PageClient = newPageClient(publicIP)
+
+// PageFetcher fetches pages with PageClient.
+var PageFetcher Fetcher = HTTPFetcher{Client: PageClient}
+
+// newPageClient returns a client that only connects to addresses allowed
+// by allow.
+func newPageClient(allow func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		// Control runs after resolution, with the address actually dialed
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allow(ip) {
+				return fmt.Errorf("%w: %s is not a public address", ErrInvalidURL, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		// No proxy: it would connect to internal addresses on our behalf
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        20,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= MaxRedirects {
+				return fmt.Errorf("%w: more than %d redirects", ErrInvalidURL, MaxRedirects)
+			}
+			_, err := ValidatePageURL(req.URL.String())
+			return err
+		},
+	}
+}
+
+// ValidatePageURL checks that a URL given by a user or the model points to
+// a public web page, and returns it with a scheme. Internal hosts such as
+// localhost, private addresses or single-label docker service names are
+// rejected so the assistant cannot be used to read them.
+func ValidatePageURL(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("%w: credentials are not allowed", ErrInvalidURL)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", fmt.Errorf("%w: no host", ErrInvalidURL)
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		return "", fmt.Errorf("%w: port %s is not allowed", ErrInvalidURL, port)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return "", fmt.Errorf("%w: %s is not a public address", ErrInvalidURL, host)
+		}
+		return u.String(), nil
+	}
+	if !strings.Contains(host, ".") || host == "localhost" || strings.HasSuffix(host, ".localhost") ||
+		strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return "", fmt.Errorf("%w: %s is not a public host", ErrInvalidURL, host)
+	}
+	return u.String(), nil
+}
+
+// FetchPage validates rawURL, scrapes it with fetcher and returns the
+// cleaned text, published date and URL, cut to FetchTokenLimit tokens.
+func FetchPage(ctx context.Context, fetcher Fetcher, rawURL string) (string, error) {
+	logger, err := initializeLogger()
+	if err != nil {
+		return "", errors.New("failed to initialize logger")
+	}
+
+	pageURL, err := ValidatePageURL(rawURL)
+	if err != nil {
+		logger.Warn("Rejected page URL", "url", rawURL, "error", err)
+		return "", err
+	}
+	content, err := scrapeWith(ctx, fetcher, pageURL)
+	if err != nil {
+		return "", err
+	}
+
+	tokens := TokenCounter(content)
+	if tokens < 0 {
+		// Without an encoder assume about four characters per token
+		tokens = len(content) / 4
+	}
+	if tokens > FetchTokenLimit {
+		// Cut the page text, keeping the published date and URL after it
+		body, tail := content, ""
+		if i := strings.Index(content, "\n</HTML CONTENT>"); i >= 0 {
+			body, tail = content[:i], content[i:]
+		}
+		keep := len(content) * FetchTokenLimit / tokens
+		keep = max(0, min(len(body), keep-len(tail)))
+		for keep > 0 && keep < len(body) && !utf8.RuneStart(body[keep]) {
+			keep--
+		}
+		content = body[:keep] + "\n[... page truncated ...]" + tail
+		logger.Warn("Page truncated to token limit",
+			"url", pageURL,
+			"token_count", tokens,
+			"limit", FetchTokenLimit)
+	}
+	return content, nil
+}
@@ -3,15 +3,11 @@ package webpagescraper
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
 
@@ -20,8 +16,20 @@ import (
 	"golang.org/x/net/html"
 )
 
+// LogFile is the file the package writes its structured logs to.
+var LogFile = "./logfile.log"
+
+// LogHandler, when set, receives the package's logs instead of LogFile.
+var LogHandler slog.Handler
+
+// SearchURL is the base URL of the SearXNG instance used by GoogleSearch.
+var SearchURL = "http://searxng:8080"
+
 func initializeLogger() (*slog.Logger, error) {
-	file, err := os.OpenFile("./logfile.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if LogHandler != nil {
+		return slog.New(LogHandler), nil
+	}
+	file, err := os.OpenFile(LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		// If logging setup fails, use the default logger to report the error and exit
 		slog.Default().Error("Error opening log file", "error", err)
@@ -37,6 +45,11 @@ func initializeLogger() (*slog.Logger, error) {
 }
 
 func scrapeWebpage(url string) (string, error) {
+	return scrapeWith(context.Background(), DefaultFetcher, url)
+}
+
+// scrapeWith fetches url with fetcher and extracts its text and published date.
+func scrapeWith(ctx context.Context, fetcher Fetcher, url string) (string, error) {
 	logger, err := initializeLogger()
 	if err != nil {
 		return "", errors.New("failed to initialize logger")
@@ -56,29 +69,15 @@ func scrapeWebpage(url string) (string, error) {
 
 	// Fetch the URL
 	logger.Info("Fetching URL", "url", url)
-	resp, err := http.Get(url)
+	bodyBytes, err := fetcher.Fetch(ctx, url)
 	if err != nil {
 		logger.Error("Error fetching URL",
 			"url", url,
-			"error", err,
-			"status_code", resp.StatusCode)
+			"error", err)
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	// Read the entire response body
 	var result string
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("Error reading response body from URL %s: %v", url, err)
-		result = "There was an error reading this page, disregard it!"
-	}
-	if result != "" {
-		finalResult := "<HTML CONTENT>\n" + result + "\n</HTML CONTENT>" +
-			"\n<PUBLISHED DATE>\n" + "No published date found" + "\n</PUBLISHED DATE>\n" + "<URL>\n" + url + "\n</URL>"
-		return finalResult, nil
-	}
-
 	tokenreader := bytes.NewReader(bodyBytes)
 	tokenizer := html.NewTokenizer(tokenreader)
 	textTags := []string{
@@ -153,12 +152,16 @@ func scrapeWebpage(url string) (string, error) {
 }
 
 func WebpageAnalyse(url string) string {
+	return webpageAnalyse(context.Background(), DefaultFetcher, url)
+}
+
+func webpageAnalyse(ctx context.Context, fetcher Fetcher, url string) string {
 	logger, err := initializeLogger()
 	if err != nil {
 		return "Failed to initialize logger"
 	}
 
-	content, err := scrapeWebpage(url)
+	content, err := scrapeWith(ctx, fetcher, url)
 	if err != nil {
 		logger.Error("Failed to scrape webpage", "error", err, "Url", url)
 	}
@@ -193,6 +196,12 @@ func TokenCounter(text string) int {
 }
 
 func GoogleSearch(query string, count int) string {
+	return SearchAndScrape(context.Background(), SearXNG{BaseURL: SearchURL}, DefaultFetcher, query, count)
+}
+
+// SearchAndScrape searches for query with searcher, scrapes up to count of the
+// results with fetcher and returns their combined content for the model.
+func SearchAndScrape(parent context.Context, searcher Searcher, fetcher Fetcher, query string, count int) string {
 	logger, err := initializeLogger()
 	if err != nil {
 		return "Failed to initialize logger"
@@ -202,88 +211,21 @@ func GoogleSearch(query string, count int) string {
 		"query", query,
 		"requested_results", count)
 
-	encodedQuery := url.QueryEscape(query)
-	searchURL := "http://searxng:8080/search?q=" + encodedQuery + "&format=json&safesearch=1"
-	logger.Info("Search request prepared",
-		"encoded_query", encodedQuery,
-		"search_url", searchURL)
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", searchURL, nil)
-	if err != nil {
-		logger.Error("Failed to create HTTP request",
-			"error", err,
-			"url", searchURL)
-		return "Failed to create search request"
-	}
-
-	req.Header.Set("Accept", "application/json")
-	resp, err := client.Do(req)
+	urls, err := searcher.Search(parent, query, count)
 	if err != nil {
-		logger.Error("Failed to execute HTTP request",
+		logger.Error("Search failed",
 			"error", err,
-			"url", searchURL)
+			"query", query)
 		return "Failed to execute search request"
 	}
-	defer resp.Body.Close()
 
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("Failed to read response body",
-			"error", err,
-			"url", searchURL)
-		return "Failed to read search results"
-	}
-
-	var data map[string]interface{}
-	err = json.Unmarshal(bodyText, &data)
-	if err != nil {
-		logger.Error("Failed to parse JSON response",
-			"error", err,
-			"response_body", string(bodyText))
-		return "Failed to parse search results"
-	}
-
-	logger.Info("Successfully retrieved search results",
-		"status_code", resp.StatusCode,
-		"body_size", len(bodyText))
-	URLlist := ""
-	re := regexp.MustCompile(`^https:\/\/(?:old\.)?reddit\.com.*$`)
-	if results, ok := data["results"].([]interface{}); ok {
-		for i, result := range results {
-			if i >= count {
-				break
-			}
-			if re.MatchString(searchURL) {
-				i -= 1
-				continue
-			}
-			// Each result is a map, so convert it
-			if resultMap, ok := result.(map[string]interface{}); ok {
-				// Fetch the "parsed_url" field
-				if parsedURLs, ok := resultMap["parsed_url"].([]interface{}); ok {
-					fullURL := ""
-					for i, part := range parsedURLs {
-						if i == 0 {
-							fullURL += part.(string) + "://"
-						} else if i == 1 {
-							fullURL += part.(string) + "/"
-						} else {
-							fullURL += part.(string)
-						}
-					}
-					URLlist += fullURL + "\n"
-				}
-			}
-		}
-	} else {
-		logger.Error("No 'results' key found or it's not an array")
-	}
+	URLlist := strings.Join(urls, "\n")
 	urlMap := urlsToMap(URLlist)
 	var (
 		prompt string
 		mu     sync.Mutex
 	)
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
 	var wg sync.WaitGroup
 	for _, url := range urlMap {
@@ -301,7 +243,7 @@ func GoogleSearch(query string, count int) string {
 
 			logger.Info("Starting webpage analysis in goroutine",
 				"url", url)
-			analysis := WebpageAnalyse(url)
+			analysis := webpageAnalyse(ctx, fetcher, url)
 
 			mu.Lock()
 			originalLength := len(prompt)